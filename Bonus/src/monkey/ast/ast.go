@@ -9,6 +9,7 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Span() token.Span
 }
 
 type Statement interface {
@@ -33,6 +34,14 @@ func (p *Program) TokenLiteral() string {
 	}
 }
 
+func (p *Program) Span() token.Span {
+	if len(p.Statements) == 0 {
+		return token.Span{}
+	}
+
+	return nodeSpan(p.Statements[0]).Join(nodeSpan(p.Statements[len(p.Statements)-1]))
+}
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 
@@ -45,12 +54,15 @@ func (p *Program) String() string {
 
 type LetStatement struct {
 	Token token.Token // the token.LET token
-	Name  *Identifier
+	Name  Pattern
 	Value Expression
 }
 
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Span() token.Span {
+	return ls.Token.Span().Join(nodeSpan(ls.Value))
+}
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
@@ -73,6 +85,9 @@ type ReturnStatement struct {
 
 func (r *ReturnStatement) statementNode()       {}
 func (r *ReturnStatement) TokenLiteral() string { return r.Token.Literal }
+func (r *ReturnStatement) Span() token.Span {
+	return r.Token.Span().Join(nodeSpan(r.ReturnValue))
+}
 func (r *ReturnStatement) String() string {
 	var out bytes.Buffer
 
@@ -85,8 +100,67 @@ func (r *ReturnStatement) String() string {
 	return out.String()
 }
 
+// ThrowStatement raises Value as an error, see TryExpression.
+type ThrowStatement struct {
+	Token token.Token // the token.THROW token
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode()       {}
+func (ts *ThrowStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *ThrowStatement) Span() token.Span {
+	return ts.Token.Span().Join(nodeSpan(ts.Value))
+}
+func (ts *ThrowStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ts.TokenLiteral() + " ")
+	if ts.Value != nil {
+		out.WriteString(ts.Value.String())
+	}
+
+	out.WriteString(";")
+	return out.String()
+}
+
+// FunctionStatement declares a named function. Declarations are hoisted: the
+// name is bound as the enclosing block starts, so functions declared together
+// can call each other whatever order they come in.
+type FunctionStatement struct {
+	Token    token.Token // the token.FUNCTION token
+	Name     *Identifier
+	Function *FunctionLiteral
+}
+
+func (fs *FunctionStatement) statementNode()       {}
+func (fs *FunctionStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *FunctionStatement) Span() token.Span {
+	return fs.Token.Span().Join(fs.Function.Span())
+}
+func (fs *FunctionStatement) String() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range fs.Function.Parameters {
+		params = append(params, p.String())
+	}
+
+	out.WriteString(fs.TokenLiteral() + " ")
+	out.WriteString(fs.Name.String())
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") ")
+	out.WriteString(fs.Function.Body.String())
+
+	return out.String()
+}
+
+// Loops are statements, but they can also be used as expressions. Their value
+// is the one given to break, or null.
+
 type ForLoopStatement struct {
 	Token               token.Token // the token.FOR token
+	Label               *Identifier // nil unless the loop is labelled
 	InitializeStatement Statement
 	ContinueExpression  Expression
 	StepExpression      Expression
@@ -94,10 +168,25 @@ type ForLoopStatement struct {
 }
 
 func (fls *ForLoopStatement) statementNode()       {}
+func (fls *ForLoopStatement) expressionNode()      {}
 func (fls *ForLoopStatement) TokenLiteral() string { return fls.Token.Literal }
+func (fls *ForLoopStatement) Span() token.Span {
+	span := fls.Token.Span()
+	if fls.Label != nil {
+		span = span.Join(fls.Label.Span())
+	}
+	if fls.Body != nil {
+		span = span.Join(fls.Body.Span())
+	}
+
+	return span
+}
 func (fls *ForLoopStatement) String() string {
 	var out bytes.Buffer
 
+	if fls.Label != nil {
+		out.WriteString(fls.Label.String() + ": ")
+	}
 	out.WriteString(fls.TokenLiteral() + " (")
 
 	if fls.InitializeStatement != nil {
@@ -114,6 +203,155 @@ func (fls *ForLoopStatement) String() string {
 	return out.String()
 }
 
+// ForInStatement runs Body once for each item of Iterable. With one
+// variable it is bound to the item, with two they are bound to the index (or
+// the key, for a hash) and the item.
+type ForInStatement struct {
+	Token     token.Token // the token.FOR token
+	Label     *Identifier // nil unless the loop is labelled
+	Variables []Pattern
+	Iterable  Expression
+	Body      *BlockStatement
+}
+
+func (fis *ForInStatement) statementNode()       {}
+func (fis *ForInStatement) expressionNode()      {}
+func (fis *ForInStatement) TokenLiteral() string { return fis.Token.Literal }
+func (fis *ForInStatement) Span() token.Span {
+	span := fis.Token.Span()
+	if fis.Label != nil {
+		span = span.Join(fis.Label.Span())
+	}
+	if fis.Body != nil {
+		span = span.Join(fis.Body.Span())
+	}
+
+	return span
+}
+func (fis *ForInStatement) String() string {
+	var out bytes.Buffer
+
+	if fis.Label != nil {
+		out.WriteString(fis.Label.String() + ": ")
+	}
+
+	variables := []string{}
+	for _, v := range fis.Variables {
+		variables = append(variables, v.String())
+	}
+
+	out.WriteString(fis.TokenLiteral() + " (")
+	out.WriteString(strings.Join(variables, ", "))
+	out.WriteString(" in " + fis.Iterable.String() + ") ")
+	out.WriteString(fis.Body.String())
+
+	return out.String()
+}
+
+// WhileStatement runs Body for as long as Condition holds. A do-while loop
+// tests the condition after each iteration rather than before, so the body
+// always runs at least once.
+type WhileStatement struct {
+	Token     token.Token // the token.WHILE token, or token.DO for a do-while
+	Label     *Identifier // nil unless the loop is labelled
+	Condition Expression
+	Body      *BlockStatement
+	IsDoWhile bool
+}
+
+func (ws *WhileStatement) statementNode()       {}
+func (ws *WhileStatement) expressionNode()      {}
+func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+func (ws *WhileStatement) Span() token.Span {
+	span := ws.Token.Span().Join(nodeSpan(ws.Condition))
+	if ws.Label != nil {
+		span = span.Join(ws.Label.Span())
+	}
+	if ws.Body != nil {
+		span = span.Join(ws.Body.Span())
+	}
+
+	return span
+}
+func (ws *WhileStatement) String() string {
+	var out bytes.Buffer
+
+	if ws.Label != nil {
+		out.WriteString(ws.Label.String() + ": ")
+	}
+
+	if ws.IsDoWhile {
+		out.WriteString("do " + ws.Body.String() + " while (" + ws.Condition.String() + ")")
+	} else {
+		out.WriteString("while (" + ws.Condition.String() + ") " + ws.Body.String())
+	}
+
+	return out.String()
+}
+
+// BreakStatement leaves the innermost loop, or the loop with the given label.
+// The loop's value is Value, or null when there is none.
+type BreakStatement struct {
+	Token token.Token // the token.BREAK token
+	Label *Identifier
+	Value Expression
+}
+
+func (bs *BreakStatement) statementNode()       {}
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) Span() token.Span {
+	return loopControlSpan(bs.Token, bs.Label).Join(nodeSpan(bs.Value))
+}
+func (bs *BreakStatement) String() string {
+	if bs.Value == nil {
+		return loopControlString(bs.TokenLiteral(), bs.Label)
+	}
+
+	keyword := bs.TokenLiteral()
+	if bs.Label != nil {
+		keyword += " " + bs.Label.String()
+	}
+
+	// A lone identifier would be read back as a label
+	if ident, ok := bs.Value.(*Identifier); ok {
+		return keyword + " (" + ident.String() + ");"
+	}
+
+	return keyword + " " + bs.Value.String() + ";"
+}
+
+// ContinueStatement skips to the next iteration of the innermost loop, or
+// of the loop with the given label.
+type ContinueStatement struct {
+	Token token.Token // the token.CONTINUE token
+	Label *Identifier
+}
+
+func (cs *ContinueStatement) statementNode()       {}
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStatement) Span() token.Span {
+	return loopControlSpan(cs.Token, cs.Label)
+}
+func (cs *ContinueStatement) String() string {
+	return loopControlString(cs.TokenLiteral(), cs.Label)
+}
+
+func loopControlSpan(tok token.Token, label *Identifier) token.Span {
+	if label != nil {
+		return tok.Span().Join(label.Span())
+	}
+
+	return tok.Span()
+}
+
+func loopControlString(keyword string, label *Identifier) string {
+	if label != nil {
+		return keyword + " " + label.String() + ";"
+	}
+
+	return keyword + ";"
+}
+
 type ExpressionStatement struct {
 	Token      token.Token // the first token of the expression
 	Expression Expression
@@ -121,6 +359,9 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Span() token.Span {
+	return es.Token.Span().Join(nodeSpan(es.Expression))
+}
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String() + ";"
@@ -134,8 +375,10 @@ type Identifier struct {
 }
 
 func (i *Identifier) expressionNode()      {}
+func (i *Identifier) patternNode()         {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
 func (i *Identifier) String() string       { return i.Value }
+func (i *Identifier) Span() token.Span     { return i.Token.Span() }
 
 type IntegerLiteral struct {
 	Token token.Token
@@ -145,6 +388,17 @@ type IntegerLiteral struct {
 func (i *IntegerLiteral) expressionNode()      {}
 func (i *IntegerLiteral) TokenLiteral() string { return i.Token.Literal }
 func (i *IntegerLiteral) String() string       { return i.Token.Literal }
+func (i *IntegerLiteral) Span() token.Span     { return i.Token.Span() }
+
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (f *FloatLiteral) expressionNode()      {}
+func (f *FloatLiteral) TokenLiteral() string { return f.Token.Literal }
+func (f *FloatLiteral) String() string       { return f.Token.Literal }
+func (f *FloatLiteral) Span() token.Span     { return f.Token.Span() }
 
 type StringLiteral struct {
 	Token token.Token
@@ -154,14 +408,19 @@ type StringLiteral struct {
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
 func (sl *StringLiteral) String() string       { return sl.Token.Literal }
+func (sl *StringLiteral) Span() token.Span     { return sl.Token.Span() }
 
 type ArrayLiteral struct {
-	Token    token.Token
+	Token    token.Token // the '[' token
 	Elements []Expression
+	EndToken token.Token // the ']' token
 }
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Span() token.Span {
+	return al.Token.Span().Join(al.EndToken.Span())
+}
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 
@@ -177,6 +436,22 @@ func (al *ArrayLiteral) String() string {
 	return out.String()
 }
 
+// SpreadExpression stands for the items of Value, in an array literal or the
+// arguments of a call, or for its pairs in a hash literal.
+type SpreadExpression struct {
+	Token token.Token // the '...' token
+	Value Expression
+}
+
+func (se *SpreadExpression) expressionNode()      {}
+func (se *SpreadExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SpreadExpression) Span() token.Span {
+	return se.Token.Span().Join(nodeSpan(se.Value))
+}
+func (se *SpreadExpression) String() string {
+	return se.Token.Literal + se.Value.String()
+}
+
 type PrefixExpression struct {
 	Token    token.Token
 	Operator string
@@ -185,6 +460,9 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Span() token.Span {
+	return pe.Token.Span().Join(nodeSpan(pe.Right))
+}
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -196,6 +474,30 @@ func (pe *PrefixExpression) String() string {
 	return out.String()
 }
 
+// PostfixExpression is `x++` or `x--`, which evaluate to the value x had
+// before it was changed. The prefix forms are PrefixExpressions.
+type PostfixExpression struct {
+	Token    token.Token // the operator token
+	Left     Expression
+	Operator string
+}
+
+func (pe *PostfixExpression) expressionNode()      {}
+func (pe *PostfixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PostfixExpression) Span() token.Span {
+	return nodeSpan(pe.Left).Join(pe.Token.Span())
+}
+func (pe *PostfixExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(pe.Left.String())
+	out.WriteString(pe.Operator)
+	out.WriteString(")")
+
+	return out.String()
+}
+
 type InfixExpression struct {
 	Token    token.Token
 	Left     Expression
@@ -205,6 +507,9 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) expressionNode()      {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Span() token.Span {
+	return nodeSpan(ie.Left).Join(ie.Token.Span()).Join(nodeSpan(ie.Right))
+}
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 
@@ -218,13 +523,17 @@ func (ie *InfixExpression) String() string {
 }
 
 type IndexExpression struct {
-	Token token.Token
-	Left  Expression
-	Index Expression
+	Token    token.Token // the '[' token
+	Left     Expression
+	Index    Expression
+	EndToken token.Token // the ']' token
 }
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Span() token.Span {
+	return nodeSpan(ie.Left).Join(ie.Token.Span()).Join(ie.EndToken.Span())
+}
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 
@@ -245,6 +554,7 @@ type Boolean struct {
 func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
 func (b *Boolean) String() string       { return b.Token.Literal }
+func (b *Boolean) Span() token.Span     { return b.Token.Span() }
 
 type IfExpression struct {
 	Token       token.Token
@@ -254,6 +564,18 @@ type IfExpression struct {
 
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Span() token.Span {
+	span := ie.Token.Span()
+	for _, clause := range ie.Clauses {
+		span = span.Join(clause.Span())
+	}
+
+	if ie.Alternative != nil {
+		span = span.Join(ie.Alternative.Span())
+	}
+
+	return span
+}
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
@@ -277,6 +599,15 @@ type IfClause struct {
 	Consequence *BlockStatement
 }
 
+func (ic *IfClause) Span() token.Span {
+	span := nodeSpan(ic.Condition)
+	if ic.Consequence != nil {
+		span = span.Join(ic.Consequence.Span())
+	}
+
+	return span
+}
+
 func (ic *IfClause) String() string {
 	var out bytes.Buffer
 
@@ -288,13 +619,119 @@ func (ic *IfClause) String() string {
 	return out.String()
 }
 
+// MatchExpression evaluates the body of the first arm whose pattern matches
+// Subject and whose guard, if any, holds.
+type MatchExpression struct {
+	Token    token.Token // the 'match' token
+	Subject  Expression
+	Arms     []*MatchArm
+	EndToken token.Token // the '}' token
+}
+
+func (me *MatchExpression) expressionNode()      {}
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MatchExpression) Span() token.Span {
+	return me.Token.Span().Join(me.EndToken.Span())
+}
+func (me *MatchExpression) String() string {
+	var out bytes.Buffer
+
+	arms := []string{}
+	for _, arm := range me.Arms {
+		arms = append(arms, arm.String())
+	}
+
+	out.WriteString("match (")
+	out.WriteString(me.Subject.String())
+	out.WriteString(") { ")
+	out.WriteString(strings.Join(arms, ", "))
+	out.WriteString(" }")
+
+	return out.String()
+}
+
+// MatchArm is one `pattern if guard => body` case of a match expression.
+type MatchArm struct {
+	Pattern Pattern
+	Guard   Expression // nil if there is no guard
+	Body    *BlockStatement
+}
+
+func (ma *MatchArm) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ma.Pattern.String())
+	if ma.Guard != nil {
+		out.WriteString(" if ")
+		out.WriteString(ma.Guard.String())
+	}
+	out.WriteString(" => ")
+	out.WriteString(ma.Body.String())
+
+	return out.String()
+}
+
+// TryExpression evaluates Block, handing an error raised in it to Catch. Its
+// value is that of whichever of the two ran last. Finally, if there is one,
+// runs after them however they end. At least one of Catch and Finally is set.
+type TryExpression struct {
+	Token          token.Token // the 'try' token
+	Block          *BlockStatement
+	CatchParameter *Identifier // nil if the catch doesn't name the error
+	Catch          *BlockStatement
+	Finally        *BlockStatement
+}
+
+func (te *TryExpression) expressionNode()      {}
+func (te *TryExpression) TokenLiteral() string { return te.Token.Literal }
+func (te *TryExpression) Span() token.Span {
+	span := te.Token.Span()
+	for _, block := range []*BlockStatement{te.Block, te.Catch, te.Finally} {
+		if block != nil {
+			span = span.Join(block.Span())
+		}
+	}
+
+	return span
+}
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try ")
+	out.WriteString(te.Block.String())
+
+	if te.Catch != nil {
+		out.WriteString(" catch ")
+		if te.CatchParameter != nil {
+			out.WriteString("(" + te.CatchParameter.String() + ") ")
+		}
+		out.WriteString(te.Catch.String())
+	}
+
+	if te.Finally != nil {
+		out.WriteString(" finally ")
+		out.WriteString(te.Finally.String())
+	}
+
+	return out.String()
+}
+
 type BlockStatement struct {
-	Token      token.Token
+	Token      token.Token // the '{' token, or the first token of a single statement block
 	Statements []Statement
+	EndToken   token.Token // the '}' token, unset for single statement blocks
 }
 
 func (bs *BlockStatement) statementNode()       {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Span() token.Span {
+	span := bs.Token.Span()
+	for _, s := range bs.Statements {
+		span = span.Join(nodeSpan(s))
+	}
+
+	return span.Join(bs.EndToken.Span())
+}
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 
@@ -311,12 +748,21 @@ func (bs *BlockStatement) String() string {
 
 type FunctionLiteral struct {
 	Token      token.Token
+	Name       string // the name it is declared or bound with, empty if it has none
 	Parameters []*FunctionParameter
 	Body       *BlockStatement
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Span() token.Span {
+	span := fl.Token.Span()
+	if fl.Body != nil {
+		span = span.Join(fl.Body.Span())
+	}
+
+	return span
+}
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
@@ -335,8 +781,22 @@ func (fl *FunctionLiteral) String() string {
 }
 
 type FunctionParameter struct {
-	Name       *Identifier
+	Name       Pattern // always an *Identifier for a variodic parameter
 	IsVariodic bool
+	Default    Expression // nil if the parameter must be given
+}
+
+func (fp *FunctionParameter) Span() token.Span {
+	if fp.Name == nil {
+		return token.Span{}
+	}
+
+	span := fp.Name.Span()
+	if fp.Default != nil {
+		span = span.Join(fp.Default.Span())
+	}
+
+	return span
 }
 
 func (fp *FunctionParameter) String() string {
@@ -348,17 +808,28 @@ func (fp *FunctionParameter) String() string {
 
 	out.WriteString(fp.Name.String())
 
+	if fp.Default != nil {
+		out.WriteString(" = ")
+		out.WriteString(fp.Default.String())
+	}
+
 	return out.String()
 }
 
 type CallExpression struct {
-	Token     token.Token
-	Function  Expression // Identifier or FunctionLiteral
-	Arguments []Expression
+	Token          token.Token // the '(' token
+	Function       Expression  // Identifier or FunctionLiteral
+	Arguments      []Expression
+	NamedArguments []*NamedArgument // always after the positional arguments
+	EndToken       token.Token      // the ')' token
+	Tail           bool             // the last thing its function does, see parser.markTailCalls
 }
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Span() token.Span {
+	return nodeSpan(ce.Function).Join(ce.Token.Span()).Join(ce.EndToken.Span())
+}
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -366,6 +837,9 @@ func (ce *CallExpression) String() string {
 	for _, arg := range ce.Arguments {
 		args = append(args, arg.String())
 	}
+	for _, arg := range ce.NamedArguments {
+		args = append(args, arg.String())
+	}
 
 	out.WriteString(ce.Function.String())
 	out.WriteString("(")
@@ -375,19 +849,42 @@ func (ce *CallExpression) String() string {
 	return out.String()
 }
 
+// NamedArgument is an argument given by parameter name, as in f(port: 80).
+type NamedArgument struct {
+	Name  *Identifier
+	Value Expression
+}
+
+func (na *NamedArgument) Span() token.Span {
+	return na.Name.Span().Join(nodeSpan(na.Value))
+}
+
+func (na *NamedArgument) String() string {
+	return na.Name.String() + ": " + na.Value.String()
+}
+
 type HashLiteral struct {
-	Token token.Token // the '{' token
-	Pairs map[Expression]Expression
+	Token    token.Token // the '{' token
+	Pairs    map[Expression]Expression
+	Order    []Expression // the keys of Pairs and any spreads, as written
+	EndToken token.Token  // the '}' token
 }
 
 func (hl *HashLiteral) expressionNode()      {}
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Span() token.Span {
+	return hl.Token.Span().Join(hl.EndToken.Span())
+}
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for k, v := range hl.Pairs {
-		pairs = append(pairs, k.String()+":"+v.String())
+	for _, k := range hl.Order {
+		if spread, ok := k.(*SpreadExpression); ok {
+			pairs = append(pairs, spread.String())
+		} else {
+			pairs = append(pairs, k.String()+":"+hl.Pairs[k].String())
+		}
 	}
 
 	out.WriteString("{")
@@ -396,3 +893,197 @@ func (hl *HashLiteral) String() string {
 
 	return out.String()
 }
+
+// Pattern is what let, a function parameter, a for-in variable or an
+// assignment binds a value to: either a name, or an array or hash pattern
+// that takes the value apart and binds its pieces. The arms of a match
+// expression can also use wildcard, literal and type patterns.
+type Pattern interface {
+	Expression
+	patternNode()
+}
+
+// ArrayPattern binds the items of an array in order. Rest, if present, is
+// bound to an array of the items left after Elements.
+type ArrayPattern struct {
+	Token    token.Token // the '[' token
+	Elements []*PatternElement
+	Rest     *Identifier
+	EndToken token.Token // the ']' token
+}
+
+func (ap *ArrayPattern) expressionNode()      {}
+func (ap *ArrayPattern) patternNode()         {}
+func (ap *ArrayPattern) TokenLiteral() string { return ap.Token.Literal }
+func (ap *ArrayPattern) Span() token.Span {
+	return ap.Token.Span().Join(ap.EndToken.Span())
+}
+func (ap *ArrayPattern) String() string {
+	elements := []string{}
+	for _, e := range ap.Elements {
+		elements = append(elements, e.String())
+	}
+
+	if ap.Rest != nil {
+		elements = append(elements, "..."+ap.Rest.String())
+	}
+
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// Required is the number of items the array must have, which is every
+// element up to the last one without a default.
+func (ap *ArrayPattern) Required() int {
+	for i := len(ap.Elements) - 1; i >= 0; i-- {
+		if ap.Elements[i].Default == nil {
+			return i + 1
+		}
+	}
+
+	return 0
+}
+
+// WildcardPattern is the `_` of a match pattern, which matches anything and
+// binds nothing.
+type WildcardPattern struct {
+	Token token.Token
+}
+
+func (wp *WildcardPattern) expressionNode()      {}
+func (wp *WildcardPattern) patternNode()         {}
+func (wp *WildcardPattern) TokenLiteral() string { return wp.Token.Literal }
+func (wp *WildcardPattern) Span() token.Span     { return wp.Token.Span() }
+func (wp *WildcardPattern) String() string       { return wp.Token.Literal }
+
+// LiteralPattern matches values of the same type equal to Value, which is a
+// number, string or boolean literal, or a negated number.
+type LiteralPattern struct {
+	Value Expression
+}
+
+func (lp *LiteralPattern) expressionNode()      {}
+func (lp *LiteralPattern) patternNode()         {}
+func (lp *LiteralPattern) TokenLiteral() string { return lp.Value.TokenLiteral() }
+func (lp *LiteralPattern) Span() token.Span     { return lp.Value.Span() }
+func (lp *LiteralPattern) String() string       { return lp.Value.String() }
+
+// TypePattern matches values of the type named by Token, such as `int` or
+// `string`, binding them to Name if there is one.
+type TypePattern struct {
+	Token token.Token
+	Name  *Identifier
+}
+
+func (tp *TypePattern) expressionNode()      {}
+func (tp *TypePattern) patternNode()         {}
+func (tp *TypePattern) TokenLiteral() string { return tp.Token.Literal }
+func (tp *TypePattern) Span() token.Span {
+	span := tp.Token.Span()
+	if tp.Name != nil {
+		span = span.Join(tp.Name.Span())
+	}
+
+	return span
+}
+func (tp *TypePattern) String() string {
+	if tp.Name == nil {
+		return tp.Token.Literal
+	}
+
+	return tp.Token.Literal + " " + tp.Name.String()
+}
+
+// HashPattern binds the values of a hash by key. `{name}` is short for
+// `{name: name}`.
+type HashPattern struct {
+	Token    token.Token // the '{' token
+	Pairs    []*HashPatternPair
+	EndToken token.Token // the '}' token
+}
+
+func (hp *HashPattern) expressionNode()      {}
+func (hp *HashPattern) patternNode()         {}
+func (hp *HashPattern) TokenLiteral() string { return hp.Token.Literal }
+func (hp *HashPattern) Span() token.Span {
+	return hp.Token.Span().Join(hp.EndToken.Span())
+}
+func (hp *HashPattern) String() string {
+	pairs := []string{}
+	for _, p := range hp.Pairs {
+		pairs = append(pairs, p.String())
+	}
+
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// HashPatternPair binds the value under the string Key to Value.
+type HashPatternPair struct {
+	Key   *Identifier
+	Value *PatternElement
+}
+
+func (hpp *HashPatternPair) String() string {
+	if name, ok := hpp.Value.Target.(*Identifier); ok && name.Value == hpp.Key.Value {
+		return hpp.Value.String()
+	}
+
+	return hpp.Key.String() + ": " + hpp.Value.String()
+}
+
+// PatternElement is one target of an array or hash pattern. Default, if
+// present, is evaluated and bound instead when the value is missing.
+type PatternElement struct {
+	Target  Pattern
+	Default Expression
+}
+
+func (pe *PatternElement) String() string {
+	if pe.Default == nil {
+		return pe.Target.String()
+	}
+
+	return pe.Target.String() + " = " + pe.Default.String()
+}
+
+// PatternNames returns the identifiers a pattern binds, in order.
+func PatternNames(pattern Pattern) []*Identifier {
+	switch pattern := pattern.(type) {
+	case *Identifier:
+		return []*Identifier{pattern}
+
+	case *ArrayPattern:
+		names := []*Identifier{}
+		for _, e := range pattern.Elements {
+			names = append(names, PatternNames(e.Target)...)
+		}
+		if pattern.Rest != nil {
+			names = append(names, pattern.Rest)
+		}
+		return names
+
+	case *HashPattern:
+		names := []*Identifier{}
+		for _, p := range pattern.Pairs {
+			names = append(names, PatternNames(p.Value.Target)...)
+		}
+		return names
+
+	case *TypePattern:
+		if pattern.Name != nil {
+			return []*Identifier{pattern.Name}
+		}
+		return nil
+
+	default:
+		return nil
+	}
+}
+
+// nodeSpan returns the span of node, or an invalid span if the node is missing.
+func nodeSpan(node Node) token.Span {
+	if node == nil {
+		return token.Span{}
+	}
+
+	return node.Span()
+}
@@ -0,0 +1,355 @@
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"monkey/token"
+	"sort"
+)
+
+type Instructions []byte
+
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+
+		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+	operandCount := len(def.OperandWidths)
+
+	if len(operands) != operandCount {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n",
+			len(operands), operandCount)
+	}
+
+	switch operandCount {
+	case 0:
+		return def.Name
+	case 1:
+		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
+	case 3:
+		return fmt.Sprintf("%s %d %d %d", def.Name, operands[0], operands[1], operands[2])
+	}
+
+	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
+}
+
+type Opcode byte
+
+const (
+	OpConstant Opcode = iota
+	OpPop
+	OpPopBelow // pops values from under the top of the stack, keeping the top
+	OpDup
+	OpDupTwo    // duplicates the top two values, keeping their order
+	OpPushBelow // moves the top of the stack under the values below it
+
+	// Pushes the absence of a value, which is what statements such as let
+	// evaluate to in the tree-walking evaluator
+	OpEmpty
+	OpNull
+	OpTrue
+	OpFalse
+
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+	OpPow
+	OpBitAnd
+	OpBitOr
+	OpBitXor
+	OpShiftLeft
+	OpShiftRight
+	OpEqual
+	OpNotEqual
+	OpLessThan
+	OpGreaterThan
+	OpLessEqual
+	OpGreaterEqual
+
+	OpMinus
+	OpBang
+	OpBitNot
+
+	OpJump
+	OpJumpNotTruthy
+	OpJumpNotEmpty // jumps if the top of the stack is a value, leaving it there
+
+	// Define ops pop the value they bind, Set ops leave it on the stack as
+	// the result of the assignment
+	OpGetGlobal
+	OpDefineGlobal
+	OpSetGlobal
+	OpGetLocal
+	OpDefineLocal
+	OpSetLocal
+	OpClearLocals
+	OpGetFree
+	OpSetFree
+
+	OpArray
+	OpHash
+	OpIndex
+	OpSetIndex // leaves the assigned value on the stack, like the other Set ops
+
+	// The spread ops pop a value and add its items to the array, or its
+	// pairs to the hash, below it
+	OpSpreadArray
+	OpSpreadHash
+
+	// OpIterator replaces the value on top of the stack with an iterator
+	// over it. OpIterNext pushes the values for the iterator's next item, or
+	// jumps to its operand once there are no more items.
+	OpIterator
+	OpIterNext
+
+	// The pattern ops check that the value on top of the stack can be taken
+	// apart by an array or hash pattern, leaving it in place. The element
+	// ops push one of its items, or nothing (as OpEmpty does) when an item
+	// with a default is missing.
+	OpArrayPattern
+	OpArrayElement
+	OpArrayRest
+	OpHashPattern
+	OpHashElement
+
+	// OpMatch pushes the values bound by a match pattern if the value on
+	// top of the stack matches it, and jumps to its last operand if not.
+	// OpNoMatch reports that no arm matched the value.
+	OpMatch
+	OpNoMatch
+
+	// OpTry starts the code protected by a try, sending errors raised before
+	// the matching OpEndTry to its operand with the error value pushed, and
+	// the stack and frames as they were at the OpTry. OpThrow raises the
+	// value on top of the stack as an error.
+	OpTry
+	OpEndTry
+	OpThrow
+
+	// OpError raises a runtime error with the message in its operand's
+	// constant, for code that can only fail, such as assigning to 5
+	OpError
+
+	OpCall
+	OpCallNamed  // the last arguments are named by an array of strings
+	OpCallSpread // the positional arguments are in an array
+	OpReturnValue
+	OpClosure
+
+	// OpTailCall, OpTailCallNamed and OpTailCallSpread are the calls in tail
+	// position, see ast.CallExpression. A function they call takes over the
+	// frame of the function making the call instead of getting its own.
+	OpTailCall
+	OpTailCallNamed
+	OpTailCallSpread
+
+	// OpNameFunction gives the function on top of the stack the name in its
+	// operand's constant, if it has none yet, leaving it in place
+	OpNameFunction
+
+	// OpJumpHasArgument skips the code computing a parameter's default when
+	// the call gave an argument for it
+	OpJumpHasArgument
+)
+
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+	OpPop:      {"OpPop", []int{}},
+	OpPopBelow: {"OpPopBelow", []int{2}},
+	OpDup:      {"OpDup", []int{}},
+
+	OpDupTwo:    {"OpDupTwo", []int{}},
+	OpPushBelow: {"OpPushBelow", []int{2}},
+
+	OpEmpty: {"OpEmpty", []int{}},
+	OpNull:  {"OpNull", []int{}},
+	OpTrue:  {"OpTrue", []int{}},
+	OpFalse: {"OpFalse", []int{}},
+
+	OpAdd:          {"OpAdd", []int{}},
+	OpSub:          {"OpSub", []int{}},
+	OpMul:          {"OpMul", []int{}},
+	OpDiv:          {"OpDiv", []int{}},
+	OpMod:          {"OpMod", []int{}},
+	OpPow:          {"OpPow", []int{}},
+	OpBitAnd:       {"OpBitAnd", []int{}},
+	OpBitOr:        {"OpBitOr", []int{}},
+	OpBitXor:       {"OpBitXor", []int{}},
+	OpShiftLeft:    {"OpShiftLeft", []int{}},
+	OpShiftRight:   {"OpShiftRight", []int{}},
+	OpEqual:        {"OpEqual", []int{}},
+	OpNotEqual:     {"OpNotEqual", []int{}},
+	OpLessThan:     {"OpLessThan", []int{}},
+	OpGreaterThan:  {"OpGreaterThan", []int{}},
+	OpLessEqual:    {"OpLessEqual", []int{}},
+	OpGreaterEqual: {"OpGreaterEqual", []int{}},
+
+	OpMinus:  {"OpMinus", []int{}},
+	OpBang:   {"OpBang", []int{}},
+	OpBitNot: {"OpBitNot", []int{}},
+
+	OpJump:          {"OpJump", []int{2}},
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
+	OpJumpNotEmpty:  {"OpJumpNotEmpty", []int{2}},
+
+	OpGetGlobal:    {"OpGetGlobal", []int{2}},
+	OpDefineGlobal: {"OpDefineGlobal", []int{2}},
+	OpSetGlobal:    {"OpSetGlobal", []int{2}},
+	OpGetLocal:     {"OpGetLocal", []int{2}},
+	OpDefineLocal:  {"OpDefineLocal", []int{2}},
+	OpSetLocal:     {"OpSetLocal", []int{2}},
+	OpClearLocals:  {"OpClearLocals", []int{2, 2}}, // first slot, number of slots
+	OpGetFree:      {"OpGetFree", []int{1}},
+	OpSetFree:      {"OpSetFree", []int{1}},
+
+	OpArray:    {"OpArray", []int{2}},
+	OpHash:     {"OpHash", []int{2}},
+	OpIndex:    {"OpIndex", []int{}},
+	OpSetIndex: {"OpSetIndex", []int{}},
+
+	OpSpreadArray: {"OpSpreadArray", []int{}},
+	OpSpreadHash:  {"OpSpreadHash", []int{}},
+
+	OpIterator: {"OpIterator", []int{}},
+	OpIterNext: {"OpIterNext", []int{1, 2}}, // number of values, exit position
+
+	OpArrayPattern: {"OpArrayPattern", []int{2, 2, 1}}, // required items, elements, has rest
+	OpArrayElement: {"OpArrayElement", []int{2}},
+	OpArrayRest:    {"OpArrayRest", []int{2}},
+	OpHashPattern:  {"OpHashPattern", []int{}},
+	OpHashElement:  {"OpHashElement", []int{2, 1}}, // key constant, has default
+
+	OpMatch:   {"OpMatch", []int{2, 1, 2}}, // pattern constant, number of values, jump position
+	OpNoMatch: {"OpNoMatch", []int{}},
+
+	OpTry:    {"OpTry", []int{2}}, // catch position
+	OpEndTry: {"OpEndTry", []int{}},
+	OpThrow:  {"OpThrow", []int{}},
+
+	OpError: {"OpError", []int{2}}, // message constant
+
+	OpCall:        {"OpCall", []int{1}},
+	OpCallNamed:   {"OpCallNamed", []int{1, 2}},  // number of arguments, names constant
+	OpCallSpread:  {"OpCallSpread", []int{1, 2}}, // number of named arguments, names constant
+	OpReturnValue: {"OpReturnValue", []int{}},
+	OpClosure:     {"OpClosure", []int{2}},
+
+	OpTailCall:       {"OpTailCall", []int{1}},
+	OpTailCallNamed:  {"OpTailCallNamed", []int{1, 2}},
+	OpTailCallSpread: {"OpTailCallSpread", []int{1, 2}},
+
+	OpNameFunction: {"OpNameFunction", []int{2}}, // name constant
+
+	OpJumpHasArgument: {"OpJumpHasArgument", []int{2, 2}}, // local slot, jump position
+}
+
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+
+	return def, nil
+}
+
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+
+		offset += width
+	}
+
+	return operands, offset
+}
+
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
+
+// SourceMap records which source span each run of instructions was compiled
+// from, so that runtime errors can be reported at the right place.
+type SourceMap []SourceMapEntry
+
+type SourceMapEntry struct {
+	Offset int // offset of the first instruction compiled from Span
+	Span   token.Span
+}
+
+// Lookup returns the span of the instruction at offset.
+func (m SourceMap) Lookup(offset int) token.Span {
+	idx := sort.Search(len(m), func(i int) bool { return m[i].Offset > offset })
+	if idx == 0 {
+		return token.Span{}
+	}
+
+	return m[idx-1].Span
+}
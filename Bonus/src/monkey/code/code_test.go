@@ -0,0 +1,124 @@
+package code
+
+import (
+	"monkey/token"
+	"testing"
+)
+
+func TestMake(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		expected []byte
+	}{
+		{OpConstant, []int{65534}, []byte{byte(OpConstant), 255, 254}},
+		{OpAdd, []int{}, []byte{byte(OpAdd)}},
+		{OpGetFree, []int{255}, []byte{byte(OpGetFree), 255}},
+		{OpClearLocals, []int{1, 2}, []byte{byte(OpClearLocals), 0, 1, 0, 2}},
+		{OpIterNext, []int{2, 258}, []byte{byte(OpIterNext), 2, 1, 2}},
+		{OpPushBelow, []int{3}, []byte{byte(OpPushBelow), 0, 3}},
+		{OpArrayPattern, []int{1, 2, 1}, []byte{byte(OpArrayPattern), 0, 1, 0, 2, 1}},
+		{OpCallNamed, []int{3, 258}, []byte{byte(OpCallNamed), 3, 1, 2}},
+		{OpSpreadArray, []int{}, []byte{byte(OpSpreadArray)}},
+		{OpMatch, []int{1, 2, 770}, []byte{byte(OpMatch), 0, 1, 2, 3, 2}},
+		{OpTry, []int{258}, []byte{byte(OpTry), 1, 2}},
+	}
+
+	for _, tt := range tests {
+		instruction := Make(tt.op, tt.operands...)
+
+		if len(instruction) != len(tt.expected) {
+			t.Fatalf("instruction has wrong length. want=%d, got=%d",
+				len(tt.expected), len(instruction))
+		}
+
+		for i, b := range tt.expected {
+			if instruction[i] != b {
+				t.Errorf("wrong byte at pos %d. want=%d, got=%d",
+					i, b, instruction[i])
+			}
+		}
+	}
+}
+
+func TestInstructionsString(t *testing.T) {
+	instructions := []Instructions{
+		Make(OpAdd),
+		Make(OpGetLocal, 1),
+		Make(OpConstant, 2),
+		Make(OpConstant, 65535),
+		Make(OpClearLocals, 3, 4),
+	}
+
+	expected := `0000 OpAdd
+0001 OpGetLocal 1
+0004 OpConstant 2
+0007 OpConstant 65535
+0010 OpClearLocals 3 4
+`
+
+	concatted := Instructions{}
+	for _, ins := range instructions {
+		concatted = append(concatted, ins...)
+	}
+
+	if concatted.String() != expected {
+		t.Errorf("instructions wrongly formatted.\nwant=%q\ngot=%q",
+			expected, concatted.String())
+	}
+}
+
+func TestReadOperands(t *testing.T) {
+	tests := []struct {
+		op        Opcode
+		operands  []int
+		bytesRead int
+	}{
+		{OpConstant, []int{65535}, 2},
+		{OpGetFree, []int{255}, 1},
+		{OpClearLocals, []int{1, 65535}, 4},
+	}
+
+	for _, tt := range tests {
+		instruction := Make(tt.op, tt.operands...)
+
+		def, err := Lookup(byte(tt.op))
+		if err != nil {
+			t.Fatalf("definition not found: %q\n", err)
+		}
+
+		operandsRead, n := ReadOperands(def, instruction[1:])
+		if n != tt.bytesRead {
+			t.Fatalf("n wrong. want=%d, got=%d", tt.bytesRead, n)
+		}
+
+		for i, want := range tt.operands {
+			if operandsRead[i] != want {
+				t.Errorf("operand wrong. want=%d, got=%d", want, operandsRead[i])
+			}
+		}
+	}
+}
+
+func TestSourceMapLookup(t *testing.T) {
+	first := token.Span{Start: token.Position{Line: 1, Column: 1}}
+	second := token.Span{Start: token.Position{Line: 2, Column: 5}}
+
+	sourceMap := SourceMap{{Offset: 0, Span: first}, {Offset: 4, Span: second}}
+
+	tests := []struct {
+		offset   int
+		expected token.Span
+	}{
+		{0, first},
+		{3, first},
+		{4, second},
+		{10, second},
+	}
+
+	for _, tt := range tests {
+		if got := sourceMap.Lookup(tt.offset); got != tt.expected {
+			t.Errorf("wrong span for offset %d. want=%s, got=%s", tt.offset, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,1751 @@
+package compiler
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/code"
+	"monkey/object"
+	"monkey/token"
+)
+
+// Error is a problem found while compiling, such as an assignment to
+// something that isn't a variable.
+type Error struct {
+	Message string
+	Span    token.Span
+}
+
+func (e *Error) Error() string {
+	if e.Span.IsValid() {
+		return e.Span.String() + ": " + e.Message
+	}
+
+	return e.Message
+}
+
+type Bytecode struct {
+	Main        *object.CompiledFunction // the program itself
+	Constants   []object.Object
+	GlobalNames []string // by slot, for error messages and builtin lookup
+}
+
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+type CompilationScope struct {
+	instructions code.Instructions
+	sourceMap    code.SourceMap
+
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+
+	stackDepth int // values pushed by this function's code so far
+	loops      []*loopContext
+	tries      []*tryContext // the tries whose handlers are in place, innermost last
+}
+
+type loopContext struct {
+	label         string
+	endsOnReturn  bool  // a return ends only the loop, see compileReturnStatement
+	stackDepth    int   // stack depth when entering an iteration
+	resultDepth   int   // stack depth below the loop's value, once it ends
+	exitJumps     []int // positions of jumps to be patched to where the loop ends without a value
+	breakJumps    []int // positions of jumps to be patched to the end, with the value pushed
+	continueJumps []int // positions of jumps to be patched to the next iteration
+	tries         int   // number of tries the loop is inside
+}
+
+// tryContext is a try whose handler is in place while the code being compiled
+// runs. Code that jumps out of it has to remove the handler and run the
+// finally block itself, see leaveTries.
+type tryContext struct {
+	finally *ast.BlockStatement // nil if there is none
+}
+
+type Compiler struct {
+	constants   []object.Object
+	symbolTable *SymbolTable
+
+	// Blocks in the main program keep their variables in local slots of the
+	// main frame rather than in globals
+	mainSymbols *functionSymbols
+
+	scopes     []*CompilationScope
+	scopeIndex int
+
+	span token.Span // the node being compiled, recorded against emitted instructions
+
+	// The first operand found too large for its instruction, see checkOperands
+	err *Error
+}
+
+func New() *Compiler {
+	return NewWithState(NewSymbolTable(), []object.Object{})
+}
+
+// NewWithState creates a compiler that continues from an earlier one, which is
+// how the REPL keeps globals alive between lines.
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	return &Compiler{
+		constants:   constants,
+		symbolTable: s,
+		mainSymbols: &functionSymbols{},
+		scopes:      []*CompilationScope{{}},
+	}
+}
+
+func (c *Compiler) Compile(node ast.Node) (err error) {
+	// The parser leaves holes where it failed, these evaluate to nothing
+	if node == nil {
+		c.emit(code.OpEmpty)
+		return nil
+	}
+
+	previousSpan := c.span
+	c.span = node.Span()
+	defer func() {
+		c.span = previousSpan
+
+		// Operands are checked as they are emitted, which is too deep to
+		// return an error from, so the node they were emitted for reports it
+		if err == nil && c.err != nil {
+			err = c.err
+		}
+	}()
+
+	switch node := node.(type) {
+	case *ast.Program:
+		if err := c.compileStatements(node.Statements); err != nil {
+			return err
+		}
+
+		c.emit(code.OpReturnValue)
+
+	case *ast.BlockStatement:
+		return c.compileBlock(node)
+
+	case *ast.LetStatement:
+		return c.compileLetStatement(node)
+
+	case *ast.FunctionStatement:
+		// Already defined by hoistFunctions at the start of the block
+		c.emit(code.OpEmpty)
+
+	case *ast.ReturnStatement:
+		return c.compileReturnStatement(node)
+
+	case *ast.ThrowStatement:
+		return c.compileThrowStatement(node)
+
+	case *ast.ForLoopStatement:
+		return c.compileForLoopStatement(node)
+
+	case *ast.ForInStatement:
+		return c.compileForInStatement(node)
+
+	case *ast.WhileStatement:
+		return c.compileWhileStatement(node)
+
+	case *ast.BreakStatement:
+		return c.compileBreakStatement(node)
+
+	case *ast.ContinueStatement:
+		return c.compileContinueStatement(node)
+
+	case *ast.ExpressionStatement:
+		return c.Compile(node.Expression)
+
+	case *ast.IfExpression:
+		return c.compileIfExpression(node)
+
+	case *ast.MatchExpression:
+		return c.compileMatchExpression(node)
+
+	case *ast.TryExpression:
+		return c.compileTryExpression(node)
+
+	case *ast.PrefixExpression:
+		if node.Operator == "++" || node.Operator == "--" {
+			return c.compileUpdate(node.Operator, node.Right, nil, false)
+		}
+
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+
+		switch node.Operator {
+		case "!":
+			c.emit(code.OpBang)
+		case "-":
+			c.emit(code.OpMinus)
+		case "~":
+			c.emit(code.OpBitNot)
+		default:
+			return c.errorf("unknown operator: %s", node.Operator)
+		}
+
+	case *ast.PostfixExpression:
+		return c.compileUpdate(node.Operator, node.Left, nil, true)
+
+	case *ast.InfixExpression:
+		return c.compileInfixExpression(node)
+
+	case *ast.CallExpression:
+		if err := c.Compile(node.Function); err != nil {
+			return err
+		}
+
+		spread := hasSpread(node.Arguments)
+		if spread {
+			if err := c.compileElements(node.Arguments); err != nil {
+				return err
+			}
+		} else {
+			for _, arg := range node.Arguments {
+				if err := c.Compile(arg); err != nil {
+					return err
+				}
+			}
+		}
+
+		names := make([]object.Object, len(node.NamedArguments))
+		for i, arg := range node.NamedArguments {
+			if err := c.Compile(arg.Value); err != nil {
+				return err
+			}
+
+			names[i] = &object.String{Value: arg.Name.Value}
+		}
+
+		callOp, namedOp, spreadOp := code.OpCall, code.OpCallNamed, code.OpCallSpread
+		if node.Tail {
+			callOp, namedOp, spreadOp = code.OpTailCall, code.OpTailCallNamed, code.OpTailCallSpread
+		}
+
+		if spread {
+			if len(names) > 255 {
+				return c.errorf("too many arguments in call: %d", len(names))
+			}
+
+			c.emit(spreadOp, len(names), c.addConstant(&object.Array{Elements: names}))
+			break
+		}
+
+		numArgs := len(node.Arguments) + len(names)
+		if numArgs > 255 {
+			return c.errorf("too many arguments in call: %d", numArgs)
+		}
+
+		if len(names) == 0 {
+			c.emit(callOp, numArgs)
+		} else {
+			c.emit(namedOp, numArgs, c.addConstant(&object.Array{Elements: names}))
+		}
+
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+
+		c.emit(code.OpIndex)
+
+	case *ast.Identifier:
+		c.loadSymbol(c.resolve(node.Value))
+
+	case *ast.IntegerLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: node.Value}))
+
+	case *ast.FloatLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Float{Value: node.Value}))
+
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	case *ast.StringLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: node.Value}))
+
+	case *ast.ArrayLiteral:
+		return c.compileElements(node.Elements)
+
+	case *ast.HashLiteral:
+		return c.compileHashLiteral(node)
+
+	case *ast.FunctionLiteral:
+		return c.compileFunctionLiteral(node)
+
+	default:
+		return c.errorf("cannot compile %T", node)
+	}
+
+	return nil
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Main: &object.CompiledFunction{
+			Instructions: c.currentInstructions(),
+			SourceMap:    c.scopes[c.scopeIndex].sourceMap,
+			NumLocals:    len(c.mainSymbols.localNames),
+			LocalNames:   c.mainSymbols.localNames,
+		},
+		Constants:   c.constants,
+		GlobalNames: c.symbolTable.GlobalNames(),
+	}
+}
+
+// compileStatements leaves the value of the last statement on the stack, or
+// nothing (OpEmpty) when there are no statements, like evalBlockStatement.
+// Every statement pushes exactly one value.
+func (c *Compiler) compileStatements(statements []ast.Statement) error {
+	if len(statements) == 0 {
+		c.emit(code.OpEmpty)
+		return nil
+	}
+
+	for _, statement := range statements {
+		if let, ok := statement.(*ast.LetStatement); ok {
+			for _, name := range ast.PatternNames(let.Name) {
+				c.symbolTable.declare(name.Value)
+			}
+		}
+	}
+
+	if err := c.hoistFunctions(statements); err != nil {
+		return err
+	}
+
+	for i, statement := range statements {
+		if err := c.Compile(statement); err != nil {
+			return err
+		}
+
+		if i < len(statements)-1 {
+			c.discardValue()
+		}
+	}
+
+	return nil
+}
+
+// hoistFunctions defines the functions declared among statements before any of
+// them run, as the evaluator does. Every name is defined before any of the
+// functions is compiled so that they can refer to each other.
+func (c *Compiler) hoistFunctions(statements []ast.Statement) error {
+	declarations := []*ast.FunctionStatement{}
+	symbols := []Symbol{}
+
+	for _, statement := range statements {
+		if declaration, ok := statement.(*ast.FunctionStatement); ok {
+			declarations = append(declarations, declaration)
+			symbols = append(symbols, c.symbolTable.Define(declaration.Name.Value))
+		}
+	}
+
+	previousSpan := c.span
+	defer func() { c.span = previousSpan }()
+
+	for i, declaration := range declarations {
+		c.span = declaration.Span()
+
+		if err := c.Compile(declaration.Function); err != nil {
+			return err
+		}
+
+		c.defineSymbol(symbols[i])
+	}
+
+	return nil
+}
+
+func (c *Compiler) compileBlock(block *ast.BlockStatement) error {
+	scope := c.enterBlockScope()
+
+	if err := c.compileStatements(block.Statements); err != nil {
+		return err
+	}
+
+	c.leaveBlockScope(scope)
+	return nil
+}
+
+func (c *Compiler) compileLetStatement(node *ast.LetStatement) error {
+	var symbol Symbol
+
+	// Functions can refer to themselves, so the name must exist before the
+	// body is compiled
+	name, isName := node.Name.(*ast.Identifier)
+	_, isFunction := node.Value.(*ast.FunctionLiteral)
+	isFunction = isFunction && isName
+
+	if isFunction {
+		symbol = c.symbolTable.Define(name.Value)
+	}
+
+	if err := c.Compile(node.Value); err != nil {
+		return err
+	}
+
+	if isName && mayBeUnnamedFunction(node.Value) {
+		c.emit(code.OpNameFunction, c.addConstant(&object.String{Value: name.Value}))
+	}
+
+	if isFunction {
+		c.defineSymbol(symbol)
+	} else if err := c.compileBinding(node.Name, true); err != nil {
+		return err
+	}
+
+	c.emit(code.OpEmpty)
+	return nil
+}
+
+// mayBeUnnamedFunction reports whether value can give a function without a
+// name, which a let statement names at run time. Function literals are named
+// by the parser, and other literals and operators never give functions.
+func mayBeUnnamedFunction(value ast.Expression) bool {
+	switch value := value.(type) {
+	case *ast.IntegerLiteral, *ast.FloatLiteral, *ast.StringLiteral, *ast.Boolean,
+		*ast.ArrayLiteral, *ast.HashLiteral, *ast.FunctionLiteral, *ast.PrefixExpression:
+		return false
+	case *ast.InfixExpression:
+		return value.Operator == "&&" || value.Operator == "||" || value.Operator == "="
+	default:
+		return true
+	}
+}
+
+// compileBinding binds the value on top of the stack to pattern and pops it.
+// With define set the names are new variables, as for let, otherwise they are
+// existing variables being assigned to.
+func (c *Compiler) compileBinding(pattern ast.Pattern, define bool) error {
+	if name, ok := pattern.(*ast.Identifier); ok {
+		if define {
+			c.defineSymbol(c.symbolTable.Define(name.Value))
+		} else {
+			c.storeSymbol(c.resolve(name.Value))
+			c.emit(code.OpPop)
+		}
+
+		return nil
+	}
+
+	// Values that can't be taken apart are reported at the pattern, as in
+	// the evaluator
+	previousSpan := c.span
+	c.span = pattern.Span()
+	defer func() { c.span = previousSpan }()
+
+	switch pattern := pattern.(type) {
+	case *ast.ArrayPattern:
+		rest := 0
+		if pattern.Rest != nil {
+			rest = 1
+		}
+
+		c.emit(code.OpArrayPattern, pattern.Required(), len(pattern.Elements), rest)
+
+		for i, element := range pattern.Elements {
+			c.emit(code.OpArrayElement, i)
+			if err := c.compilePatternElement(element, define); err != nil {
+				return err
+			}
+		}
+
+		if pattern.Rest != nil {
+			c.emit(code.OpArrayRest, len(pattern.Elements))
+			if err := c.compileBinding(pattern.Rest, define); err != nil {
+				return err
+			}
+		}
+
+	case *ast.HashPattern:
+		c.emit(code.OpHashPattern)
+
+		for _, pair := range pattern.Pairs {
+			optional := 0
+			if pair.Value.Default != nil {
+				optional = 1
+			}
+
+			key := c.addConstant(&object.String{Value: pair.Key.Value})
+			c.emit(code.OpHashElement, key, optional)
+
+			if err := c.compilePatternElement(pair.Value, define); err != nil {
+				return err
+			}
+		}
+	}
+
+	c.emit(code.OpPop)
+	return nil
+}
+
+// compilePatternElement binds the item on top of the stack, which is empty
+// when it was missing and the element's default should be used instead.
+func (c *Compiler) compilePatternElement(element *ast.PatternElement, define bool) error {
+	if element.Default != nil {
+		present := c.emit(code.OpJumpNotEmpty, 9999)
+		c.emit(code.OpPop)
+
+		if err := c.Compile(element.Default); err != nil {
+			return err
+		}
+
+		c.changeOperand(present, c.markJumpTarget())
+	}
+
+	return c.compileBinding(element.Target, define)
+}
+
+func (c *Compiler) compileReturnStatement(node *ast.ReturnStatement) error {
+	scope := c.scopes[c.scopeIndex]
+	depth := scope.stackDepth
+
+	if err := c.Compile(node.ReturnValue); err != nil {
+		return err
+	}
+
+	// As in the evaluator, a return inside a C style loop ends the loop
+	// rather than the function. For-in loops pass it on.
+	var loop *loopContext
+	for i := len(scope.loops) - 1; i >= 0; i-- {
+		if scope.loops[i].endsOnReturn {
+			loop = scope.loops[i]
+			break
+		}
+	}
+
+	if loop != nil {
+		for scope.stackDepth > loop.stackDepth {
+			c.emit(code.OpPop)
+		}
+
+		if err := c.leaveTries(loop.tries); err != nil {
+			return err
+		}
+
+		loop.exitJumps = append(loop.exitJumps, c.emit(code.OpJump, 9999))
+	} else {
+		if err := c.leaveTries(0); err != nil {
+			return err
+		}
+
+		c.emit(code.OpReturnValue)
+	}
+
+	// Nothing after this runs, but the statement counts as pushing its value
+	scope.stackDepth = depth + 1
+	return nil
+}
+
+func (c *Compiler) compileThrowStatement(node *ast.ThrowStatement) error {
+	depth := c.scopes[c.scopeIndex].stackDepth
+
+	if err := c.Compile(node.Value); err != nil {
+		return err
+	}
+
+	c.emit(code.OpThrow)
+
+	// Nothing after this runs, but the statement counts as pushing a value
+	c.scopes[c.scopeIndex].stackDepth = depth + 1
+	return nil
+}
+
+func (c *Compiler) compileForLoopStatement(node *ast.ForLoopStatement) error {
+	// Like the evaluator, the initializer and the body share one scope for
+	// the whole loop
+	block := c.enterBlockScope()
+	scope := c.scopes[c.scopeIndex]
+
+	if node.InitializeStatement != nil {
+		if err := c.Compile(node.InitializeStatement); err != nil {
+			return err
+		}
+
+		c.discardValue()
+	}
+
+	loop := c.enterLoop(node.Label, scope.stackDepth)
+	loop.endsOnReturn = true
+
+	loopStart := c.markJumpTarget()
+
+	if node.ContinueExpression != nil {
+		if err := c.Compile(node.ContinueExpression); err != nil {
+			return err
+		}
+
+		loop.exitJumps = append(loop.exitJumps, c.emit(code.OpJumpNotTruthy, 9999))
+	}
+
+	if err := c.compileStatements(node.Body.Statements); err != nil {
+		return err
+	}
+
+	c.discardValue()
+
+	next := c.markJumpTarget()
+	for _, pos := range loop.continueJumps {
+		c.changeOperand(pos, next)
+	}
+
+	if node.StepExpression != nil {
+		if err := c.Compile(node.StepExpression); err != nil {
+			return err
+		}
+
+		c.discardValue()
+	}
+
+	c.emit(code.OpJump, loopStart)
+
+	c.leaveLoop(loop)
+	c.leaveBlockScope(block)
+	return nil
+}
+
+// compileForInStatement keeps the iterator on the stack for the whole loop.
+// The loop variables and the body's lets get fresh slots on every iteration,
+// like the environment the evaluator creates for each one.
+func (c *Compiler) compileForInStatement(node *ast.ForInStatement) error {
+	scope := c.scopes[c.scopeIndex]
+
+	if err := c.Compile(node.Iterable); err != nil {
+		return err
+	}
+
+	// Values that can't be iterated are reported at the iterable
+	previousSpan := c.span
+	c.span = node.Iterable.Span()
+	c.emit(code.OpIterator)
+	c.span = previousSpan
+
+	// The loop's value replaces the iterator
+	loop := c.enterLoop(node.Label, scope.stackDepth-1)
+
+	loopStart := c.markJumpTarget()
+	next := c.emit(code.OpIterNext, len(node.Variables), 9999)
+
+	block := c.enterBlockScope()
+
+	if err := c.compileLoopVariables(node.Variables); err != nil {
+		return err
+	}
+
+	if err := c.compileStatements(node.Body.Statements); err != nil {
+		return err
+	}
+
+	c.discardValue()
+	c.leaveBlockScope(block)
+
+	for _, pos := range loop.continueJumps {
+		c.changeOperand(pos, loopStart)
+	}
+
+	c.emit(code.OpJump, loopStart)
+
+	exit := c.leaveLoop(loop)
+	c.replaceInstruction(next, code.Make(code.OpIterNext, len(node.Variables), exit))
+	return nil
+}
+
+// compileLoopVariables binds the values pushed by OpIterNext. They were
+// pushed in order, so the last variable is on top and plain names are bound
+// last to first. The defaults of a pattern may refer to the variable before
+// it though, so with patterns the values are swapped round first.
+func (c *Compiler) compileLoopVariables(variables []ast.Pattern) error {
+	for _, variable := range variables {
+		if _, ok := variable.(*ast.Identifier); !ok && len(variables) == 2 {
+			c.emit(code.OpPushBelow, 1)
+
+			for _, variable := range variables {
+				if err := c.compileBinding(variable, true); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+	}
+
+	for i := len(variables) - 1; i >= 0; i-- {
+		if err := c.compileBinding(variables[i], true); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Compiler) compileWhileStatement(node *ast.WhileStatement) error {
+	scope := c.scopes[c.scopeIndex]
+	loop := c.enterLoop(node.Label, scope.stackDepth)
+
+	loopStart := c.markJumpTarget()
+
+	if !node.IsDoWhile {
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+
+		loop.exitJumps = append(loop.exitJumps, c.emit(code.OpJumpNotTruthy, 9999))
+	}
+
+	if err := c.compileBlock(node.Body); err != nil {
+		return err
+	}
+
+	c.discardValue()
+
+	next := c.markJumpTarget()
+	for _, pos := range loop.continueJumps {
+		c.changeOperand(pos, next)
+	}
+
+	if node.IsDoWhile {
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+
+		loop.exitJumps = append(loop.exitJumps, c.emit(code.OpJumpNotTruthy, 9999))
+	}
+
+	c.emit(code.OpJump, loopStart)
+
+	c.leaveLoop(loop)
+	return nil
+}
+
+// enterLoop starts compiling a loop whose value will be left at resultDepth.
+func (c *Compiler) enterLoop(label *ast.Identifier, resultDepth int) *loopContext {
+	scope := c.scopes[c.scopeIndex]
+
+	loop := &loopContext{stackDepth: scope.stackDepth, resultDepth: resultDepth, tries: len(scope.tries)}
+	if label != nil {
+		loop.label = label.Value
+	}
+
+	scope.loops = append(scope.loops, loop)
+	return loop
+}
+
+// leaveLoop emits the end of a loop, after the jump back to its start. A
+// loop that ends without a break has the value null. It returns the position
+// loops ending that way jump to.
+func (c *Compiler) leaveLoop(loop *loopContext) int {
+	scope := c.scopes[c.scopeIndex]
+
+	exit := c.markJumpTarget()
+	for _, pos := range loop.exitJumps {
+		c.changeOperand(pos, exit)
+	}
+
+	for scope.stackDepth > loop.resultDepth {
+		c.emit(code.OpPop)
+	}
+	c.emit(code.OpNull)
+
+	end := c.markJumpTarget()
+	for _, pos := range loop.breakJumps {
+		c.changeOperand(pos, end)
+	}
+
+	scope.loops = scope.loops[:len(scope.loops)-1]
+	return exit
+}
+
+// compileBreakStatement leaves the break's value in place of whatever the
+// loop has on the stack, then jumps to the end of the loop.
+func (c *Compiler) compileBreakStatement(node *ast.BreakStatement) error {
+	scope := c.scopes[c.scopeIndex]
+	depth := scope.stackDepth
+
+	loop, err := c.findLoop("break", node.Label)
+	if err != nil {
+		return err
+	}
+
+	if node.Value != nil {
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+	} else {
+		c.emit(code.OpNull)
+	}
+
+	if below := scope.stackDepth - 1 - loop.resultDepth; below > 0 {
+		c.emit(code.OpPopBelow, below)
+	}
+
+	if err := c.leaveTries(loop.tries); err != nil {
+		return err
+	}
+
+	loop.breakJumps = append(loop.breakJumps, c.emit(code.OpJump, 9999))
+
+	// Nothing after this runs, but the statement counts as pushing a value
+	scope.stackDepth = depth + 1
+	return nil
+}
+
+// compileContinueStatement drops the values the loop body left on the stack
+// and jumps to the next iteration.
+func (c *Compiler) compileContinueStatement(node *ast.ContinueStatement) error {
+	scope := c.scopes[c.scopeIndex]
+	depth := scope.stackDepth
+
+	loop, err := c.findLoop("continue", node.Label)
+	if err != nil {
+		return err
+	}
+
+	for scope.stackDepth > loop.stackDepth {
+		c.emit(code.OpPop)
+	}
+
+	if err := c.leaveTries(loop.tries); err != nil {
+		return err
+	}
+
+	loop.continueJumps = append(loop.continueJumps, c.emit(code.OpJump, 9999))
+
+	// Nothing after this runs, but the statement counts as pushing a value
+	scope.stackDepth = depth + 1
+	return nil
+}
+
+// findLoop returns the loop a break or continue applies to.
+func (c *Compiler) findLoop(keyword string, label *ast.Identifier) (*loopContext, error) {
+	loops := c.scopes[c.scopeIndex].loops
+
+	for i := len(loops) - 1; i >= 0; i-- {
+		if label == nil || loops[i].label == label.Value {
+			return loops[i], nil
+		}
+	}
+
+	if label != nil {
+		return nil, c.errorf("%s to undefined label %s", keyword, label.Value)
+	}
+	return nil, c.errorf("%s outside loop", keyword)
+}
+
+func (c *Compiler) compileIfExpression(node *ast.IfExpression) error {
+	endJumps := []int{}
+
+	for _, clause := range node.Clauses {
+		if err := c.Compile(clause.Condition); err != nil {
+			return err
+		}
+
+		nextJump := c.emit(code.OpJumpNotTruthy, 9999)
+		depth := c.scopes[c.scopeIndex].stackDepth
+
+		if err := c.compileBlock(clause.Consequence); err != nil {
+			return err
+		}
+
+		endJumps = append(endJumps, c.emit(code.OpJump, 9999))
+
+		// The next clause starts from the state before this one's value
+		c.scopes[c.scopeIndex].stackDepth = depth
+		c.changeOperand(nextJump, c.markJumpTarget())
+	}
+
+	if node.Alternative != nil {
+		if err := c.compileBlock(node.Alternative); err != nil {
+			return err
+		}
+	} else {
+		c.emit(code.OpNull)
+	}
+
+	end := c.markJumpTarget()
+	for _, pos := range endJumps {
+		c.changeOperand(pos, end)
+	}
+
+	return nil
+}
+
+// compileMatchExpression keeps the subject on the stack while the arms try
+// it in turn, and removes it from under the value of the arm that matched.
+// Each arm has a block scope of its own for the names its pattern binds.
+func (c *Compiler) compileMatchExpression(node *ast.MatchExpression) error {
+	if err := c.Compile(node.Subject); err != nil {
+		return err
+	}
+
+	depth := c.scopes[c.scopeIndex].stackDepth
+	endJumps := []int{}
+
+	for _, arm := range node.Arms {
+		block := c.enterBlockScope()
+
+		pattern := c.addConstant(&object.MatchPattern{Pattern: arm.Pattern})
+		names := ast.PatternNames(arm.Pattern)
+		match := c.emit(code.OpMatch, pattern, len(names), 9999)
+
+		// The values were pushed in order, so the last is on top
+		for i := len(names) - 1; i >= 0; i-- {
+			c.defineSymbol(c.symbolTable.Define(names[i].Value))
+		}
+
+		guardJump := -1
+		if arm.Guard != nil {
+			if err := c.Compile(arm.Guard); err != nil {
+				return err
+			}
+
+			guardJump = c.emit(code.OpJumpNotTruthy, 9999)
+		}
+
+		if err := c.compileStatements(arm.Body.Statements); err != nil {
+			return err
+		}
+
+		c.leaveBlockScope(block)
+		endJumps = append(endJumps, c.emit(code.OpJump, 9999))
+
+		// The next arm starts from the state before this one's value
+		c.scopes[c.scopeIndex].stackDepth = depth
+		next := c.markJumpTarget()
+
+		c.replaceInstruction(match, code.Make(code.OpMatch, pattern, len(names), next))
+		if guardJump != -1 {
+			c.changeOperand(guardJump, next)
+		}
+	}
+
+	c.emit(code.OpNoMatch)
+
+	// OpNoMatch doesn't return, the arms that jump here leave a value
+	c.scopes[c.scopeIndex].stackDepth = depth + 1
+
+	end := c.markJumpTarget()
+	for _, pos := range endJumps {
+		c.changeOperand(pos, end)
+	}
+
+	c.emit(code.OpPopBelow, 1)
+	return nil
+}
+
+// compileTryExpression protects the try block with a handler that sends
+// errors to the catch block, which is protected in turn when there is a
+// finally block. Code leaving a try normally runs the finally block where it
+// leaves, and an error caught by the last handler runs it before being raised
+// again:
+//
+//	OpTry catch; block; OpEndTry; OpJump done
+//	catch: OpTry rethrow; catch block; OpEndTry
+//	done: finally; OpJump end
+//	rethrow: finally; OpThrow
+//	end:
+func (c *Compiler) compileTryExpression(node *ast.TryExpression) error {
+	scope := c.scopes[c.scopeIndex]
+	try := &tryContext{finally: node.Finally}
+
+	handler := c.emit(code.OpTry, 9999)
+	scope.tries = append(scope.tries, try)
+
+	if err := c.compileBlock(node.Block); err != nil {
+		return err
+	}
+
+	scope.tries = scope.tries[:len(scope.tries)-1]
+	c.emit(code.OpEndTry)
+
+	// The error value takes the place of the block's value
+	depth := scope.stackDepth
+
+	if node.Catch != nil {
+		done := c.emit(code.OpJump, 9999)
+		c.changeOperand(handler, c.markJumpTarget())
+
+		if node.Finally != nil {
+			handler = c.emit(code.OpTry, 9999)
+			scope.tries = append(scope.tries, try)
+		}
+
+		block := c.enterBlockScope()
+
+		if node.CatchParameter != nil {
+			c.defineSymbol(c.symbolTable.Define(node.CatchParameter.Value))
+		} else {
+			c.emit(code.OpPop)
+		}
+
+		if err := c.compileStatements(node.Catch.Statements); err != nil {
+			return err
+		}
+
+		c.leaveBlockScope(block)
+
+		if node.Finally != nil {
+			scope.tries = scope.tries[:len(scope.tries)-1]
+			c.emit(code.OpEndTry)
+		}
+
+		c.changeOperand(done, c.markJumpTarget())
+	}
+
+	if node.Finally == nil {
+		return nil
+	}
+
+	if err := c.compileFinally(node.Finally); err != nil {
+		return err
+	}
+
+	end := c.emit(code.OpJump, 9999)
+
+	scope.stackDepth = depth
+	c.changeOperand(handler, c.markJumpTarget())
+
+	if err := c.compileFinally(node.Finally); err != nil {
+		return err
+	}
+
+	c.emit(code.OpThrow)
+
+	// OpThrow doesn't return, the code that jumps to the end leaves a value
+	scope.stackDepth = depth
+	c.changeOperand(end, c.markJumpTarget())
+	return nil
+}
+
+// compileFinally runs a finally block for its effects, dropping its value.
+func (c *Compiler) compileFinally(finally *ast.BlockStatement) error {
+	if err := c.compileBlock(finally); err != nil {
+		return err
+	}
+
+	c.discardValue()
+	return nil
+}
+
+// leaveTries removes the handlers of the tries after the first count, for
+// code about to jump out of them, running their finally blocks innermost
+// first. A finally block that jumps out itself only leaves the tries around
+// it.
+func (c *Compiler) leaveTries(count int) error {
+	scope := c.scopes[c.scopeIndex]
+	tries := scope.tries
+	defer func() { scope.tries = tries }()
+
+	for i := len(tries) - 1; i >= count; i-- {
+		scope.tries = tries[:i]
+		c.emit(code.OpEndTry)
+
+		if tries[i].finally != nil {
+			if err := c.compileFinally(tries[i].finally); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+var infixOpcodes = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	"%":  code.OpMod,
+	"**": code.OpPow,
+	"&":  code.OpBitAnd,
+	"|":  code.OpBitOr,
+	"^":  code.OpBitXor,
+	"<<": code.OpShiftLeft,
+	">>": code.OpShiftRight,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+	"<":  code.OpLessThan,
+	">":  code.OpGreaterThan,
+	"<=": code.OpLessEqual,
+	">=": code.OpGreaterEqual,
+}
+
+func (c *Compiler) compileInfixExpression(node *ast.InfixExpression) error {
+	if node.Operator == "=" {
+		return c.compileAssignment(node)
+	}
+
+	if _, ok := updateOpcodes[node.Operator]; ok {
+		return c.compileUpdate(node.Operator, node.Left, node.Right, false)
+	}
+
+	if node.Operator == "&&" || node.Operator == "||" {
+		return c.compileLogicalExpression(node)
+	}
+
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+
+	op, ok := infixOpcodes[node.Operator]
+	if !ok {
+		return c.errorf("unknown operator: %s", node.Operator)
+	}
+
+	c.emit(op)
+	return nil
+}
+
+// compileLogicalExpression leaves the deciding operand on the stack, as the
+// evaluator does. The left value is kept if it decides the result, otherwise
+// it is popped and replaced by the right.
+func (c *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+
+	c.emit(code.OpDup)
+	jumpNotTruthy := c.emit(code.OpJumpNotTruthy, 9999)
+
+	var endJump int
+	if node.Operator == "||" {
+		endJump = c.emit(code.OpJump, 9999)
+		c.changeOperand(jumpNotTruthy, c.markJumpTarget())
+	}
+
+	c.emit(code.OpPop)
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+
+	end := c.markJumpTarget()
+	if node.Operator == "||" {
+		c.changeOperand(endJump, end)
+	} else {
+		c.changeOperand(jumpNotTruthy, end)
+	}
+
+	return nil
+}
+
+func (c *Compiler) compileAssignment(node *ast.InfixExpression) error {
+	switch target := node.Left.(type) {
+	case *ast.IndexExpression:
+		return c.compileIndexAssignment(target, node.Right)
+
+	// The pattern is assigned from a copy, leaving the value as the result
+	case *ast.ArrayPattern, *ast.HashPattern:
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+
+		c.emit(code.OpDup)
+		return c.compileBinding(target.(ast.Pattern), false)
+	}
+
+	ident, ok := node.Left.(*ast.Identifier)
+	if !ok {
+		c.compileRuntimeError("Left side of assign expression must be a variable")
+		return nil
+	}
+
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+
+	c.storeSymbol(c.resolve(ident.Value))
+	return nil
+}
+
+// The opcode applied by each compound assignment, and by ++ and --
+var updateOpcodes = map[string]code.Opcode{
+	"+=": code.OpAdd,
+	"-=": code.OpSub,
+	"*=": code.OpMul,
+	"/=": code.OpDiv,
+	"%=": code.OpMod,
+	"++": code.OpAdd,
+	"--": code.OpSub,
+}
+
+// compileUpdate compiles a compound assignment such as `x += 2`, or ++ and
+// -- when right is nil. An index target's container and index are evaluated
+// once and kept on the stack for the OpSetIndex. For postfix ++ and -- the
+// old value is copied under them, and is what remains once the new value is
+// popped.
+func (c *Compiler) compileUpdate(operator string, target, right ast.Expression, postfix bool) error {
+	var symbol Symbol
+
+	switch target := target.(type) {
+	case *ast.Identifier:
+		symbol = c.resolve(target.Value)
+		c.loadSymbol(symbol)
+
+		if postfix {
+			c.emit(code.OpDup)
+		}
+
+	case *ast.IndexExpression:
+		if err := c.Compile(target.Left); err != nil {
+			return err
+		}
+
+		if err := c.Compile(target.Index); err != nil {
+			return err
+		}
+
+		c.emit(code.OpDupTwo)
+		c.emit(code.OpIndex)
+
+		if postfix {
+			c.emit(code.OpDup)
+			c.emit(code.OpPushBelow, 3)
+		}
+
+	default:
+		if right == nil {
+			c.compileRuntimeError("operand of %s must be a variable", operator)
+		} else {
+			c.compileRuntimeError("Left side of assign expression must be a variable")
+		}
+		return nil
+	}
+
+	if right == nil {
+		c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: 1}))
+	} else if err := c.Compile(right); err != nil {
+		return err
+	}
+
+	c.emit(updateOpcodes[operator])
+
+	if _, ok := target.(*ast.Identifier); ok {
+		c.storeSymbol(symbol)
+	} else {
+		c.emit(code.OpSetIndex)
+	}
+
+	if postfix {
+		c.emit(code.OpPop)
+	}
+
+	return nil
+}
+
+// compileRuntimeError compiles an expression that fails with the given message
+// when it runs, as it does in the evaluator, rather than failing to compile.
+func (c *Compiler) compileRuntimeError(format string, args ...interface{}) {
+	c.emit(code.OpError, c.addConstant(&object.String{Value: fmt.Sprintf(format, args...)}))
+
+	// OpError doesn't return, the code after it expects the expression's value
+	c.scopes[c.scopeIndex].stackDepth++
+}
+
+func (c *Compiler) compileIndexAssignment(target *ast.IndexExpression, value ast.Expression) error {
+	if err := c.Compile(target.Left); err != nil {
+		return err
+	}
+
+	if err := c.Compile(target.Index); err != nil {
+		return err
+	}
+
+	if err := c.Compile(value); err != nil {
+		return err
+	}
+
+	c.emit(code.OpSetIndex)
+	return nil
+}
+
+// compileElements leaves an array of the values of elements on the stack.
+// Elements between spreads are gathered into arrays of their own, which are
+// then spread into the first.
+func (c *Compiler) compileElements(elements []ast.Expression) error {
+	pending := 0      // values on the stack that are not in an array yet
+	building := false // whether the array being built is below them
+
+	collect := func() {
+		if building && pending == 0 {
+			return
+		}
+
+		c.emit(code.OpArray, pending)
+		if building {
+			c.emit(code.OpSpreadArray)
+		}
+
+		pending, building = 0, true
+	}
+
+	for _, el := range elements {
+		if spread, ok := el.(*ast.SpreadExpression); ok {
+			collect()
+			if err := c.compileSpread(spread, code.OpSpreadArray); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := c.Compile(el); err != nil {
+			return err
+		}
+		pending++
+	}
+
+	collect()
+	return nil
+}
+
+// compileHashLiteral builds the hash in the order its entries were written,
+// as compileElements builds arrays, so that later keys win.
+func (c *Compiler) compileHashLiteral(node *ast.HashLiteral) error {
+	pending := 0
+	building := false
+
+	collect := func() {
+		if building && pending == 0 {
+			return
+		}
+
+		c.emit(code.OpHash, pending*2)
+		if building {
+			c.emit(code.OpSpreadHash)
+		}
+
+		pending, building = 0, true
+	}
+
+	for _, k := range node.Order {
+		if spread, ok := k.(*ast.SpreadExpression); ok {
+			collect()
+			if err := c.compileSpread(spread, code.OpSpreadHash); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := c.Compile(k); err != nil {
+			return err
+		}
+
+		if err := c.Compile(node.Pairs[k]); err != nil {
+			return err
+		}
+		pending++
+	}
+
+	collect()
+	return nil
+}
+
+// compileSpread adds the items of the spread value to the array or hash below
+// it using op. Values that can't be spread are reported at the spread, as in
+// the evaluator.
+func (c *Compiler) compileSpread(spread *ast.SpreadExpression, op code.Opcode) error {
+	if err := c.Compile(spread.Value); err != nil {
+		return err
+	}
+
+	previousSpan := c.span
+	c.span = spread.Span()
+	c.emit(op)
+	c.span = previousSpan
+
+	return nil
+}
+
+func hasSpread(elements []ast.Expression) bool {
+	for _, el := range elements {
+		if _, ok := el.(*ast.SpreadExpression); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Compiler) compileFunctionLiteral(node *ast.FunctionLiteral) error {
+	paramLen := len(node.Parameters)
+	for idx, param := range node.Parameters {
+		if param.IsVariodic && idx != paramLen-1 {
+			return c.errorf("variodic parameter must be the last parameter of a function")
+		}
+	}
+
+	c.enterScope()
+
+	// Arguments for patterns get a slot of their own, and are taken apart
+	// once all the arguments are in place, after any default is filled in
+	slots := make([]Symbol, paramLen)
+	for i, param := range node.Parameters {
+		if name, ok := param.Name.(*ast.Identifier); ok {
+			slots[i] = c.symbolTable.Define(name.Value)
+		} else {
+			slots[i] = c.symbolTable.defineHidden()
+		}
+	}
+
+	for i, param := range node.Parameters {
+		if param.Default != nil {
+			given := c.emit(code.OpJumpHasArgument, slots[i].Index, 9999)
+
+			if err := c.Compile(param.Default); err != nil {
+				return err
+			}
+			c.emit(code.OpDefineLocal, slots[i].Index)
+
+			c.replaceInstruction(given, code.Make(code.OpJumpHasArgument, slots[i].Index, c.markJumpTarget()))
+		}
+
+		if _, ok := param.Name.(*ast.Identifier); ok {
+			continue
+		}
+
+		c.emit(code.OpGetLocal, slots[i].Index)
+		if err := c.compileBinding(param.Name, true); err != nil {
+			return err
+		}
+	}
+
+	if err := c.compileStatements(node.Body.Statements); err != nil {
+		return err
+	}
+
+	c.emit(code.OpReturnValue)
+
+	function := c.symbolTable.function
+	scope := c.leaveScope()
+
+	captures := make([]object.Capture, len(function.freeSymbols))
+	for i, symbol := range function.freeSymbols {
+		captures[i] = object.Capture{
+			Local: symbol.Scope == LocalScope,
+			Index: symbol.Index,
+			Name:  symbol.Name,
+		}
+	}
+
+	compiled := &object.CompiledFunction{
+		Instructions: scope.instructions,
+		SourceMap:    scope.sourceMap,
+		NumLocals:    len(function.localNames),
+		LocalNames:   function.localNames,
+		Captures:     captures,
+		Name:         node.Name,
+		Parameters:   node.Parameters,
+		Body:         node.Body,
+	}
+
+	c.emit(code.OpClosure, c.addConstant(compiled))
+	return nil
+}
+
+// resolve looks up name, giving names that are not declared anywhere a global
+// slot. They are looked up again when the code runs: the global may have been
+// defined by then, or the name may refer to a builtin.
+func (c *Compiler) resolve(name string) Symbol {
+	if symbol, ok := c.symbolTable.Resolve(name); ok {
+		return symbol
+	}
+
+	return c.symbolTable.Global().Define(name)
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	}
+}
+
+// defineSymbol binds the value on top of the stack to s, popping it.
+func (c *Compiler) defineSymbol(s Symbol) {
+	if s.Scope == GlobalScope {
+		c.emit(code.OpDefineGlobal, s.Index)
+	} else {
+		c.emit(code.OpDefineLocal, s.Index)
+	}
+}
+
+// storeSymbol assigns the value on top of the stack to s, leaving it there.
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
+	}
+}
+
+type blockScope struct {
+	clearPosition int // position of the OpClearLocals, or -1
+	firstSlot     int
+}
+
+// enterBlockScope starts a scope for variables declared in a block. A block
+// inside a loop runs more than once in the same frame, so its slots are
+// cleared on entry to give it fresh variables each time, like the
+// environment the evaluator creates for it.
+func (c *Compiler) enterBlockScope() blockScope {
+	function := c.symbolTable.function
+	if function == nil {
+		function = c.mainSymbols
+	}
+
+	c.symbolTable = newBlockSymbolTable(c.symbolTable, function)
+
+	scope := blockScope{clearPosition: -1, firstSlot: len(function.localNames)}
+	if len(c.scopes[c.scopeIndex].loops) > 0 {
+		scope.clearPosition = c.emit(code.OpClearLocals, scope.firstSlot, 0)
+	}
+
+	return scope
+}
+
+func (c *Compiler) leaveBlockScope(scope blockScope) {
+	count := len(c.symbolTable.function.localNames) - scope.firstSlot
+	if scope.clearPosition != -1 {
+		c.replaceInstruction(scope.clearPosition, code.Make(code.OpClearLocals, scope.firstSlot, count))
+	}
+
+	c.symbolTable = c.symbolTable.Outer
+}
+
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, &CompilationScope{})
+	c.scopeIndex++
+
+	c.symbolTable = NewFunctionSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() *CompilationScope {
+	scope := c.scopes[c.scopeIndex]
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+
+	c.symbolTable = c.symbolTable.Outer
+
+	return scope
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	c.checkOperands(op, operands)
+
+	ins := code.Make(op, operands...)
+	pos := c.addInstruction(ins)
+
+	c.setLastInstruction(op, pos)
+
+	return pos
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	scope := c.scopes[c.scopeIndex]
+	pos := len(scope.instructions)
+
+	if n := len(scope.sourceMap); n == 0 || scope.sourceMap[n-1].Span != c.span {
+		scope.sourceMap = append(scope.sourceMap, code.SourceMapEntry{Offset: pos, Span: c.span})
+	}
+
+	scope.instructions = append(scope.instructions, ins...)
+	return pos
+}
+
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	scope := c.scopes[c.scopeIndex]
+	scope.stackDepth += stackEffect(op, code.Instructions(scope.instructions[pos+1:]))
+
+	scope.previousInstruction = scope.lastInstruction
+	scope.lastInstruction = EmittedInstruction{Opcode: op, Position: pos}
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+// discardValue pops the value of a statement whose result is unused. A
+// statement that produced no value at all is simply not pushed.
+func (c *Compiler) discardValue() {
+	scope := c.scopes[c.scopeIndex]
+
+	if scope.lastInstruction.Opcode == code.OpEmpty && len(scope.instructions) > 0 {
+		pos := scope.lastInstruction.Position
+		scope.instructions = scope.instructions[:pos]
+
+		for n := len(scope.sourceMap); n > 0 && scope.sourceMap[n-1].Offset >= pos; n-- {
+			scope.sourceMap = scope.sourceMap[:n-1]
+		}
+
+		scope.lastInstruction = scope.previousInstruction
+		scope.stackDepth--
+		return
+	}
+
+	c.emit(code.OpPop)
+}
+
+// markJumpTarget returns the position of the next instruction and makes sure
+// it is not removed by discardValue, since something is going to jump to it.
+func (c *Compiler) markJumpTarget() int {
+	scope := c.scopes[c.scopeIndex]
+
+	scope.lastInstruction = EmittedInstruction{Opcode: code.OpJump, Position: -1}
+	scope.previousInstruction = scope.lastInstruction
+
+	return len(scope.instructions)
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+
+	for i := 0; i < len(newInstruction); i++ {
+		ins[pos+i] = newInstruction[i]
+	}
+}
+
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	op := code.Opcode(c.currentInstructions()[opPos])
+	c.checkOperands(op, []int{operand})
+
+	newInstruction := code.Make(op, operand)
+
+	c.replaceInstruction(opPos, newInstruction)
+}
+
+// checkOperands records an error if any of operands is too large to fit in
+// the bytes op has for it, which would otherwise be silently cut short.
+func (c *Compiler) checkOperands(op code.Opcode, operands []int) {
+	if c.err != nil {
+		return
+	}
+
+	def, err := code.Lookup(byte(op))
+	if err != nil {
+		return
+	}
+
+	for i, operand := range operands {
+		if operand < 1<<(8*def.OperandWidths[i]) {
+			continue
+		}
+
+		c.err = &Error{Message: fmt.Sprintf("%s: %d", operandLimitMessage(op, i), operand), Span: c.span}
+		return
+	}
+}
+
+// operandLimitMessage explains what ran out when operand i of op is too large.
+func operandLimitMessage(op code.Opcode, i int) string {
+	switch {
+	case op == code.OpConstant, op == code.OpClosure, op == code.OpNameFunction, op == code.OpHashElement && i == 0,
+		op == code.OpMatch && i == 0, i == 1 && (op == code.OpCallNamed || op == code.OpCallSpread ||
+			op == code.OpTailCallNamed || op == code.OpTailCallSpread):
+		return "too many constants"
+
+	case op == code.OpGetGlobal, op == code.OpDefineGlobal, op == code.OpSetGlobal:
+		return "too many global variables"
+
+	case op == code.OpGetLocal, op == code.OpDefineLocal, op == code.OpSetLocal, op == code.OpClearLocals,
+		op == code.OpJumpHasArgument && i == 0:
+		return "too many local variables"
+
+	case op == code.OpGetFree, op == code.OpSetFree:
+		return "too many free variables"
+
+	case op == code.OpJump, op == code.OpJumpNotTruthy, op == code.OpJumpNotEmpty, op == code.OpTry,
+		op == code.OpIterNext && i == 1, op == code.OpMatch && i == 2, op == code.OpJumpHasArgument && i == 1:
+		return "function too long, jump target out of range"
+
+	case op == code.OpArray, op == code.OpHash:
+		return "too many elements in literal"
+
+	default:
+		def, _ := code.Lookup(byte(op))
+		return "operand too large for " + def.Name
+	}
+}
+
+func (c *Compiler) errorf(format string, args ...interface{}) error {
+	return &Error{Message: fmt.Sprintf(format, args...), Span: c.span}
+}
+
+// stackEffect is the change in stack depth caused by executing op.
+func stackEffect(op code.Opcode, operands code.Instructions) int {
+	switch op {
+	case code.OpConstant, code.OpDup, code.OpEmpty, code.OpNull, code.OpTrue, code.OpFalse,
+		code.OpGetGlobal, code.OpGetLocal, code.OpGetFree, code.OpClosure:
+		return 1
+
+	case code.OpPop, code.OpJumpNotTruthy, code.OpDefineGlobal, code.OpDefineLocal,
+		code.OpReturnValue, code.OpIndex, code.OpThrow,
+		code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod, code.OpPow,
+		code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShiftLeft, code.OpShiftRight,
+		code.OpEqual, code.OpNotEqual, code.OpLessThan, code.OpGreaterThan,
+		code.OpLessEqual, code.OpGreaterEqual:
+		return -1
+
+	case code.OpSetIndex:
+		return -2
+
+	case code.OpSpreadArray, code.OpSpreadHash:
+		return -1
+
+	case code.OpDupTwo:
+		return 2
+
+	case code.OpArrayElement, code.OpArrayRest, code.OpHashElement:
+		return 1
+
+	// Only counting the path where the value matched
+	case code.OpMatch:
+		return int(code.ReadUint8(operands[2:]))
+
+	case code.OpArray, code.OpHash:
+		return 1 - int(code.ReadUint16(operands))
+
+	case code.OpPopBelow:
+		return -int(code.ReadUint16(operands))
+
+	case code.OpCall, code.OpCallNamed, code.OpTailCall, code.OpTailCallNamed:
+		return -int(code.ReadUint8(operands))
+
+	case code.OpCallSpread, code.OpTailCallSpread:
+		return -1 - int(code.ReadUint8(operands))
+
+	// Only counting the path that continues the loop
+	case code.OpIterNext:
+		return int(code.ReadUint8(operands))
+
+	default:
+		return 0
+	}
+}
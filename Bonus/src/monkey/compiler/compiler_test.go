@@ -0,0 +1,993 @@
+package compiler
+
+import (
+	"fmt"
+	"monkey/code"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"strings"
+	"testing"
+)
+
+type compilerTestCase struct {
+	input                string
+	expectedConstants    []interface{}
+	expectedInstructions []code.Instructions
+}
+
+func TestExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             "1; 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             "2 > 1",
+			expectedConstants: []interface{}{2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterThan),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             `[1, "two"][0]`,
+			expectedConstants: []interface{}{1, "two", 0},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpArray, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             `[1][0] = 2`,
+			expectedConstants: []interface{}{1, 0, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             "let x = 1; x *= 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpDefineGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMul),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             "let x = 1; x--",
+			expectedConstants: []interface{}{1, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpDefineGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpDup),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSub),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             "[1][0]++",
+			expectedConstants: []interface{}{1, 0, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDupTwo),
+				code.Make(code.OpIndex),
+				code.Make(code.OpDup),
+				code.Make(code.OpPushBelow, 3),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			// Only failing once it runs, as in the evaluator
+			input:             "1; 5++; 2",
+			expectedConstants: []interface{}{1, "operand of ++ must be a variable", 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpError, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "if (true) { 10 }; 3333;",
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 10),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpJump, 11),
+				// 0010
+				code.Make(code.OpNull),
+				// 0011
+				code.Make(code.OpPop),
+				// 0012
+				code.Make(code.OpConstant, 1),
+				// 0015
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 && 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpDup),
+				// 0004
+				code.Make(code.OpJumpNotTruthy, 11),
+				// 0007
+				code.Make(code.OpPop),
+				// 0008
+				code.Make(code.OpConstant, 1),
+				// 0011
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             "1 || 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpDup),
+				// 0004
+				code.Make(code.OpJumpNotTruthy, 10),
+				// 0007
+				code.Make(code.OpJump, 14),
+				// 0010
+				code.Make(code.OpPop),
+				// 0011
+				code.Make(code.OpConstant, 1),
+				// 0014
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLetStatementScopes(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let one = 1; one;",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpDefineGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             "let one = 1;",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpDefineGlobal, 0),
+				code.Make(code.OpEmpty),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             "if (true) { let one = 1; one }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 16),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpDefineLocal, 0),
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpJump, 17),
+				code.Make(code.OpNull),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input: "fn(a) { fn(b) { a + b } }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpClosure, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLoopBlocksClearTheirLocals(t *testing.T) {
+	input := "for (;;) { if (true) { let x = 1; } }"
+
+	program := parser.New(lexer.New(input)).ParseProgram()
+
+	compiler := New()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	expected := concatInstructions([]code.Instructions{
+		code.Make(code.OpTrue),
+		code.Make(code.OpJumpNotTruthy, 19),
+		code.Make(code.OpClearLocals, 0, 1),
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpDefineLocal, 0),
+		code.Make(code.OpEmpty),
+		code.Make(code.OpJump, 20),
+		code.Make(code.OpNull),
+		code.Make(code.OpPop),
+		code.Make(code.OpJump, 0),
+		code.Make(code.OpNull),
+		code.Make(code.OpReturnValue),
+	})
+
+	if err := testInstructions([]code.Instructions{expected}, compiler.Bytecode().Main.Instructions); err != "" {
+		t.Fatal(err)
+	}
+}
+
+func TestForInLoop(t *testing.T) {
+	input := "for (i, x in [1]) { x }"
+
+	program := parser.New(lexer.New(input)).ParseProgram()
+
+	compiler := New()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	expected := concatInstructions([]code.Instructions{
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpArray, 1),
+		code.Make(code.OpIterator),
+		code.Make(code.OpIterNext, 2, 29),
+		code.Make(code.OpClearLocals, 0, 2),
+		code.Make(code.OpDefineLocal, 0),
+		code.Make(code.OpDefineLocal, 1),
+		code.Make(code.OpGetLocal, 0),
+		code.Make(code.OpPop),
+		code.Make(code.OpJump, 7),
+		code.Make(code.OpPop),
+		code.Make(code.OpNull),
+		code.Make(code.OpReturnValue),
+	})
+
+	if err := testInstructions([]code.Instructions{expected}, compiler.Bytecode().Main.Instructions); err != "" {
+		t.Fatal(err)
+	}
+}
+
+func TestBreakWithValue(t *testing.T) {
+	input := "for (x in [1]) { 2 + if (x) { break (x) } }"
+
+	program := parser.New(lexer.New(input)).ParseProgram()
+
+	compiler := New()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	expected := concatInstructions([]code.Instructions{
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpArray, 1),
+		code.Make(code.OpIterator),
+		code.Make(code.OpIterNext, 1, 51),
+		code.Make(code.OpClearLocals, 0, 1),
+		code.Make(code.OpDefineLocal, 0),
+		code.Make(code.OpConstant, 1),
+		code.Make(code.OpGetLocal, 0),
+		code.Make(code.OpJumpNotTruthy, 45),
+		code.Make(code.OpClearLocals, 1, 0),
+		code.Make(code.OpGetLocal, 0),
+		code.Make(code.OpPopBelow, 2),
+		code.Make(code.OpJump, 53),
+		code.Make(code.OpJump, 46),
+		code.Make(code.OpNull),
+		code.Make(code.OpAdd),
+		code.Make(code.OpPop),
+		code.Make(code.OpJump, 7),
+		code.Make(code.OpPop),
+		code.Make(code.OpNull),
+		code.Make(code.OpReturnValue),
+	})
+
+	if err := testInstructions([]code.Instructions{expected}, compiler.Bytecode().Main.Instructions); err != "" {
+		t.Fatal(err)
+	}
+}
+
+func TestPatternBinding(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let [a, b = 2, ...c] = [1]",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpArrayPattern, 1, 2, 1),
+				code.Make(code.OpArrayElement, 0),
+				code.Make(code.OpDefineGlobal, 0),
+				code.Make(code.OpArrayElement, 1),
+				code.Make(code.OpJumpNotEmpty, 28),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDefineGlobal, 1),
+				code.Make(code.OpArrayRest, 2),
+				code.Make(code.OpDefineGlobal, 2),
+				code.Make(code.OpPop),
+				code.Make(code.OpEmpty),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             `let a = 1; {a} = {"a": 2}`,
+			expectedConstants: []interface{}{1, "a", 2, "a"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpDefineGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpHash, 2),
+				code.Make(code.OpDup),
+				code.Make(code.OpHashPattern),
+				code.Make(code.OpHashElement, 3, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpPop),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input: "fn(a, [b]) { b }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpArrayPattern, 1, 1, 0),
+					code.Make(code.OpArrayElement, 0),
+					code.Make(code.OpDefineLocal, 2),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetLocal, 2),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestDefaultsAndNamedArguments(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn(a, b = a) { b }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpJumpHasArgument, 1, 11),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpDefineLocal, 1),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             "f(1, port: 2, tls: 3)",
+			expectedConstants: []interface{}{1, 2, 3, []string{"port", "tls"}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpCallNamed, 3, 3),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestFunctionDeclarations(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "f(); fn f() { g() } fn g() { 1 }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 1),
+					code.Make(code.OpTailCall, 0),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0),
+				code.Make(code.OpDefineGlobal, 0),
+				code.Make(code.OpClosure, 2),
+				code.Make(code.OpDefineGlobal, 1),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpEmpty),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input: "fn(a) { fn f() { a } f }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpClosure, 0),
+					code.Make(code.OpDefineLocal, 1),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input: "fn f() { 1 } let g = f();",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+				"g",
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1),
+				code.Make(code.OpDefineGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpNameFunction, 2),
+				code.Make(code.OpDefineGlobal, 1),
+				code.Make(code.OpEmpty),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestSpread(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "[1, ...[2], 3]",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSpreadArray),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSpreadArray),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             `{...{}, "a": 1}`,
+			expectedConstants: []interface{}{"a", 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpHash, 0),
+				code.Make(code.OpHash, 0),
+				code.Make(code.OpSpreadHash),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpHash, 2),
+				code.Make(code.OpSpreadHash),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             "f(...[], n: 1)",
+			expectedConstants: []interface{}{1, []string{"n"}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpSpreadArray),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpCallSpread, 1, 1),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "match (1) { [a, b] if a => b, _ => 2 }",
+			expectedConstants: []interface{}{1, matchPattern("[a, b]"), matchPattern("_"), 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpMatch, 1, 2, 27),
+				// 0009
+				code.Make(code.OpDefineLocal, 0),
+				// 0012
+				code.Make(code.OpDefineLocal, 1),
+				// 0015
+				code.Make(code.OpGetLocal, 1),
+				// 0018
+				code.Make(code.OpJumpNotTruthy, 27),
+				// 0021
+				code.Make(code.OpGetLocal, 0),
+				// 0024
+				code.Make(code.OpJump, 40),
+				// 0027
+				code.Make(code.OpMatch, 2, 0, 39),
+				// 0033
+				code.Make(code.OpConstant, 3),
+				// 0036
+				code.Make(code.OpJump, 40),
+				// 0039
+				code.Make(code.OpNoMatch),
+				// 0040
+				code.Make(code.OpPopBelow, 1),
+				// 0043
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "try { 1 } catch (e) { e } finally { 2 }",
+			expectedConstants: []interface{}{1, 2, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTry, 10),
+				// 0003
+				code.Make(code.OpConstant, 0),
+				// 0006
+				code.Make(code.OpEndTry),
+				// 0007
+				code.Make(code.OpJump, 20),
+				// 0010
+				code.Make(code.OpTry, 27),
+				// 0013
+				code.Make(code.OpDefineLocal, 0),
+				// 0016
+				code.Make(code.OpGetLocal, 0),
+				// 0019
+				code.Make(code.OpEndTry),
+				// 0020
+				code.Make(code.OpConstant, 1),
+				// 0023
+				code.Make(code.OpPop),
+				// 0024
+				code.Make(code.OpJump, 32),
+				// 0027
+				code.Make(code.OpConstant, 2),
+				// 0030
+				code.Make(code.OpPop),
+				// 0031
+				code.Make(code.OpThrow),
+				// 0032
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			// The return runs the finally block itself
+			input: "fn() { try { return 1 } finally { 2 } }",
+			expectedConstants: []interface{}{
+				1, 2, 2, 2,
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpTry, 20),
+					// 0003
+					code.Make(code.OpConstant, 0),
+					// 0006
+					code.Make(code.OpEndTry),
+					// 0007
+					code.Make(code.OpConstant, 1),
+					// 0010
+					code.Make(code.OpPop),
+					// 0011
+					code.Make(code.OpReturnValue),
+					// 0012
+					code.Make(code.OpEndTry),
+					// 0013
+					code.Make(code.OpConstant, 2),
+					// 0016
+					code.Make(code.OpPop),
+					// 0017
+					code.Make(code.OpJump, 25),
+					// 0020
+					code.Make(code.OpConstant, 3),
+					// 0023
+					code.Make(code.OpPop),
+					// 0024
+					code.Make(code.OpThrow),
+					// 0025
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 4),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             `throw "bad"`,
+			expectedConstants: []interface{}{"bad"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpThrow),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestCompilerErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn(...x, y) { }", "1:1: variodic parameter must be the last parameter of a function"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+
+		err := New().Compile(program)
+		if err == nil {
+			t.Fatalf("expected compiler error for %q", tt.input)
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expected, err.Error())
+		}
+	}
+}
+
+func TestOperandLimits(t *testing.T) {
+	var constants, globals, locals, free, jump strings.Builder
+
+	for i := 0; i <= 1<<16; i++ {
+		fmt.Fprintf(&constants, "%d;\n", i)
+		fmt.Fprintf(&globals, "let %s = true;\n", letterName(i))
+	}
+
+	locals.WriteString("fn() {\n")
+	for i := 0; i <= 1<<16; i++ {
+		fmt.Fprintf(&locals, "let %s = true;\n", letterName(i))
+	}
+	locals.WriteString("}")
+
+	names := make([]string, 1<<8+1)
+	free.WriteString("fn() {\n")
+	for i := range names {
+		names[i] = letterName(i)
+		fmt.Fprintf(&free, "let %s = true;\n", names[i])
+	}
+	fmt.Fprintf(&free, "fn() { [%s] } }", strings.Join(names, ", "))
+
+	jump.WriteString("let x = 1; if (x) {\n")
+	for i := 0; i < 30000; i++ {
+		jump.WriteString("x;\n")
+	}
+	jump.WriteString("}")
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{constants.String(), "65537:1: too many constants: 65536"},
+		{globals.String(), "65537:1: too many global variables: 65536"},
+		{locals.String(), "65538:1: too many local variables: 65536"},
+		{free.String(), "too many free variables: 256"},
+		{jump.String(), "function too long, jump target out of range"},
+		{"[" + strings.Repeat("true, ", 1<<16) + "true]", "1:1: too many elements in literal: 65537"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("parser errors for %.40q: %v", tt.input, p.Errors())
+		}
+
+		err := New().Compile(program)
+		if err == nil {
+			t.Fatalf("expected compiler error for %.40q", tt.input)
+		}
+
+		if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expected, err.Error())
+		}
+	}
+}
+
+// letterName returns a distinct variable name for each i, since names can't
+// contain digits. The prefix keeps them clear of keywords.
+func letterName(i int) string {
+	name := ""
+	for {
+		name = string(rune('a'+i%26)) + name
+		i = i/26 - 1
+		if i < 0 {
+			return "v" + name
+		}
+	}
+}
+
+func TestResolveFree(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	first := NewFunctionSymbolTable(global)
+	first.Define("b")
+
+	block := newBlockSymbolTable(first, first.function)
+	block.Define("c")
+
+	second := NewFunctionSymbolTable(block)
+	second.Define("d")
+
+	expected := []Symbol{
+		{Name: "a", Scope: GlobalScope, Index: 0},
+		{Name: "b", Scope: FreeScope, Index: 0},
+		{Name: "c", Scope: FreeScope, Index: 1},
+		{Name: "d", Scope: LocalScope, Index: 0},
+	}
+
+	for _, sym := range expected {
+		result, ok := second.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", sym.Name)
+			continue
+		}
+
+		if result != sym {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", sym.Name, sym, result)
+		}
+	}
+
+	captured := []Symbol{
+		{Name: "b", Scope: LocalScope, Index: 0},
+		{Name: "c", Scope: LocalScope, Index: 1},
+	}
+
+	if len(second.function.freeSymbols) != len(captured) {
+		t.Fatalf("wrong number of free symbols. got=%d", len(second.function.freeSymbols))
+	}
+
+	for i, sym := range captured {
+		if second.function.freeSymbols[i] != sym {
+			t.Errorf("wrong free symbol. expected=%+v, got=%+v", sym, second.function.freeSymbols[i])
+		}
+	}
+}
+
+func TestResolveDeclaredLater(t *testing.T) {
+	global := NewSymbolTable()
+
+	outer := NewFunctionSymbolTable(global)
+	outer.declare("later")
+
+	if _, ok := outer.Resolve("later"); ok {
+		t.Errorf("later should not resolve before its definition in the same function")
+	}
+
+	inner := NewFunctionSymbolTable(outer)
+
+	result, ok := inner.Resolve("later")
+	if !ok {
+		t.Fatalf("later should resolve from a nested function")
+	}
+
+	expected := Symbol{Name: "later", Scope: FreeScope, Index: 0}
+	if result != expected {
+		t.Errorf("expected %+v, got=%+v", expected, result)
+	}
+
+	if defined := outer.Define("later"); defined.Index != 0 || defined.Scope != LocalScope {
+		t.Errorf("definition should reuse the slot given out earlier. got=%+v", defined)
+	}
+}
+
+func runCompilerTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+
+		compiler := New()
+		if err := compiler.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := compiler.Bytecode()
+
+		if err := testInstructions(tt.expectedInstructions, bytecode.Main.Instructions); err != "" {
+			t.Fatalf("%s: %s", tt.input, err)
+		}
+
+		if err := testConstants(tt.expectedConstants, bytecode.Constants); err != "" {
+			t.Fatalf("%s: %s", tt.input, err)
+		}
+	}
+}
+
+func testInstructions(expected []code.Instructions, actual code.Instructions) string {
+	concatted := concatInstructions(expected)
+
+	if concatted.String() != actual.String() {
+		return "wrong instructions.\nwant=\n" + concatted.String() + "got=\n" + actual.String()
+	}
+
+	return ""
+}
+
+// matchPattern is the expected source of an *object.MatchPattern constant.
+type matchPattern string
+
+func testConstants(expected []interface{}, actual []object.Object) string {
+	if len(expected) != len(actual) {
+		return "wrong number of constants"
+	}
+
+	for i, constant := range expected {
+		switch constant := constant.(type) {
+		case int:
+			integer, ok := actual[i].(*object.Integer)
+			if !ok || integer.Value != int64(constant) {
+				return "wrong integer constant: " + actual[i].Inspect()
+			}
+
+		case string:
+			str, ok := actual[i].(*object.String)
+			if !ok || str.Value != constant {
+				return "wrong string constant: " + actual[i].Inspect()
+			}
+
+		case matchPattern:
+			pattern, ok := actual[i].(*object.MatchPattern)
+			if !ok || pattern.Inspect() != string(constant) {
+				return "wrong pattern constant: " + actual[i].Inspect()
+			}
+
+		case []string:
+			array, ok := actual[i].(*object.Array)
+			if !ok || len(array.Elements) != len(constant) {
+				return "wrong array constant: " + actual[i].Inspect()
+			}
+
+			for j, name := range constant {
+				str, ok := array.Elements[j].(*object.String)
+				if !ok || str.Value != name {
+					return "wrong array constant: " + actual[i].Inspect()
+				}
+			}
+
+		case []code.Instructions:
+			fn, ok := actual[i].(*object.CompiledFunction)
+			if !ok {
+				return "constant is not a function: " + actual[i].Inspect()
+			}
+
+			if err := testInstructions(constant, fn.Instructions); err != "" {
+				return err
+			}
+		}
+	}
+
+	return ""
+}
+
+func concatInstructions(s []code.Instructions) code.Instructions {
+	out := code.Instructions{}
+
+	for _, ins := range s {
+		out = append(out, ins...)
+	}
+
+	return out
+}
@@ -0,0 +1,138 @@
+package compiler
+
+type SymbolScope string
+
+const (
+	GlobalScope SymbolScope = "GLOBAL"
+	LocalScope  SymbolScope = "LOCAL"
+	FreeScope   SymbolScope = "FREE"
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+}
+
+// SymbolTable resolves names for one scope. The global table holds program
+// level lets. Every function body, and every block inside one (or inside the
+// main program), gets its own table so that shadowing works as it does with
+// enclosed environments. Tables belonging to the same function share one set
+// of local slots.
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	store    map[string]Symbol
+	declared map[string]bool  // names let further on in this scope
+	function *functionSymbols // nil for the global table
+
+	globalNames []string // global table only, by index
+}
+
+type functionSymbols struct {
+	localNames  []string
+	freeSymbols []Symbol // symbols of the enclosing function, in capture order
+}
+
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{store: make(map[string]Symbol), declared: make(map[string]bool)}
+}
+
+// NewFunctionSymbolTable creates the table for the body of a function
+// literal compiled inside outer.
+func NewFunctionSymbolTable(outer *SymbolTable) *SymbolTable {
+	return newBlockSymbolTable(outer, &functionSymbols{})
+}
+
+func newBlockSymbolTable(outer *SymbolTable, function *functionSymbols) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	s.function = function
+	return s
+}
+
+// Define binds name in this scope. Defining a name twice in the same scope
+// returns the same symbol, so that redefinition is reported at runtime just
+// like the evaluator does.
+func (s *SymbolTable) Define(name string) Symbol {
+	if existing, ok := s.store[name]; ok && existing.Scope != FreeScope {
+		return existing
+	}
+
+	var symbol Symbol
+	if s.function == nil {
+		symbol = Symbol{Name: name, Scope: GlobalScope, Index: len(s.globalNames)}
+		s.globalNames = append(s.globalNames, name)
+	} else {
+		symbol = Symbol{Name: name, Scope: LocalScope, Index: len(s.function.localNames)}
+		s.function.localNames = append(s.function.localNames, name)
+	}
+
+	s.store[name] = symbol
+	return symbol
+}
+
+// defineHidden gives a local slot to a value that the compiled code keeps
+// track of itself, which no name refers to.
+func (s *SymbolTable) defineHidden() Symbol {
+	symbol := Symbol{Scope: LocalScope, Index: len(s.function.localNames)}
+	s.function.localNames = append(s.function.localNames, "")
+	return symbol
+}
+
+// declare notes that name is defined later in this scope. Functions created
+// before the definition can still refer to it, since it will exist by the
+// time they are called.
+func (s *SymbolTable) declare(name string) {
+	s.declared[name] = true
+}
+
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	return s.resolve(name, false)
+}
+
+func (s *SymbolTable) resolve(name string, fromFunction bool) (Symbol, bool) {
+	if symbol, ok := s.store[name]; ok {
+		return symbol, true
+	}
+
+	if fromFunction && s.declared[name] && s.function != nil {
+		return s.Define(name), true
+	}
+
+	if s.Outer == nil {
+		return Symbol{}, false
+	}
+
+	crossing := s.Outer.function != s.function
+
+	symbol, ok := s.Outer.resolve(name, fromFunction || crossing)
+	if !ok || symbol.Scope == GlobalScope || !crossing {
+		return symbol, ok
+	}
+
+	// Crossing into an enclosing function, the variable has to be captured
+	return s.defineFree(symbol), true
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.function.freeSymbols = append(s.function.freeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Scope: FreeScope, Index: len(s.function.freeSymbols) - 1}
+	s.store[original.Name] = symbol
+	return symbol
+}
+
+// Global returns the outermost table of the chain.
+func (s *SymbolTable) Global() *SymbolTable {
+	for s.Outer != nil {
+		s = s.Outer
+	}
+
+	return s
+}
+
+// GlobalNames returns the names of all global slots, by index.
+func (s *SymbolTable) GlobalNames() []string {
+	return s.Global().globalNames
+}
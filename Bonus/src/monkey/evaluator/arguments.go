@@ -0,0 +1,91 @@
+package evaluator
+
+import (
+	"monkey/ast"
+	"monkey/object"
+)
+
+// MatchArguments lines the arguments of a call up with the parameters of the
+// function being called. args holds the positional arguments followed by the
+// named ones, whose names are listed in order in names. The result has a value
+// for each parameter, nil where the parameter is left to its default. A
+// variodic parameter collects the positional arguments left over. The name of
+// the function, if it has one, is given in errors.
+func MatchArguments(function string, params []*ast.FunctionParameter, args []object.Object, names []string) ([]object.Object, *object.Error) {
+	paramLen := len(params)
+	argLen := len(args)
+
+	fixed := paramLen
+	variadic := paramLen > 0 && params[paramLen-1].IsVariodic
+	if variadic {
+		fixed--
+	}
+
+	positional := args[:argLen-len(names)]
+	if len(positional) > fixed && !variadic {
+		return nil, wrongNumberOfArgumentsToError(function, paramLen, argLen)
+	}
+
+	values := make([]object.Object, paramLen)
+	given := make([]bool, paramLen)
+
+	for i := 0; i < fixed && i < len(positional); i++ {
+		values[i] = positional[i]
+		given[i] = true
+	}
+
+	if variadic {
+		rest := []object.Object{}
+		if len(positional) > fixed {
+			rest = append(rest, positional[fixed:]...)
+		}
+
+		values[fixed] = &object.Array{Elements: rest}
+		given[fixed] = true
+	}
+
+	for i, name := range names {
+		idx := parameterIndex(params[:fixed], name)
+		if idx < 0 {
+			if variadic && parameterIndex(params[fixed:], name) == 0 {
+				return nil, newError("variodic parameter cannot be passed by name: %s", name)
+			}
+
+			return nil, newError("unknown parameter name: %s", name)
+		}
+
+		if given[idx] {
+			return nil, newError("duplicate argument: %s", name)
+		}
+
+		values[idx] = args[len(positional)+i]
+		given[idx] = true
+	}
+
+	for i, param := range params {
+		if given[i] || param.Default != nil {
+			continue
+		}
+
+		// Without names the arguments can only have been miscounted
+		if len(names) == 0 {
+			return nil, wrongNumberOfArgumentsToError(function, paramLen, argLen)
+		}
+
+		return nil, newError("missing argument: %s", param.Name.String())
+	}
+
+	return values, nil
+}
+
+// parameterIndex returns the index of the parameter called name, or -1 if
+// there is none. Patterns have no name of their own.
+func parameterIndex(params []*ast.FunctionParameter, name string) int {
+	for i, param := range params {
+		if ident, ok := param.Name.(*ast.Identifier); ok && ident.Value == name {
+			return i
+		}
+	}
+
+	return -1
+}
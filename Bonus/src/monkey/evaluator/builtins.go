@@ -2,7 +2,10 @@ package evaluator
 
 import (
 	"fmt"
+	"math"
 	"monkey/object"
+	"strconv"
+	"unicode/utf8"
 )
 
 var builtins = map[string]*object.Builtin{
@@ -12,7 +15,29 @@ var builtins = map[string]*object.Builtin{
 	"rest":  {Fn: restBuiltin},
 	"push":  {Fn: pushBuiltin},
 	"pop":   {Fn: popBuiltin},
+	"slice": {Fn: sliceBuiltin},
+	"range": {Fn: rangeBuiltin},
 	"puts":  {Fn: putsBuiltin},
+	"exit":  {Fn: exitBuiltin},
+	"int":   {Fn: intBuiltin},
+	"float": {Fn: floatBuiltin},
+	"round": {Fn: roundBuiltin},
+	"floor": {Fn: floorBuiltin},
+	"ceil":  {Fn: ceilBuiltin},
+	"error": {Fn: errorBuiltin},
+}
+
+// Builtins know their own names so stack traces can show them
+func init() {
+	for name, builtin := range builtins {
+		builtin.Name = name
+	}
+}
+
+// LookupBuiltin returns the builtin function bound to name, if any.
+func LookupBuiltin(name string) (*object.Builtin, bool) {
+	builtin, ok := builtins[name]
+	return builtin, ok
 }
 
 func lenBuiltin(args ...object.Object) object.Object {
@@ -22,11 +47,14 @@ func lenBuiltin(args ...object.Object) object.Object {
 
 	switch arg := args[0].(type) {
 	case *object.String:
-		return &object.Integer{Value: int64(len(arg.Value))}
+		return &object.Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
 
 	case *object.Array:
 		return &object.Integer{Value: int64(len(arg.Elements))}
 
+	case *object.Range:
+		return &object.Integer{Value: arg.Len()}
+
 	default:
 		return unsupportedArgumentType("len", args[0])
 	}
@@ -129,6 +157,96 @@ func popBuiltin(args ...object.Object) object.Object {
 	}
 }
 
+// sliceBuiltin returns the elements of an array, or the characters of a
+// string, from start up to but not including end. end defaults to the length.
+func sliceBuiltin(args ...object.Object) object.Object {
+	if len(args) < 2 {
+		return wrongNumberOfArgumentsError(2, len(args))
+	}
+	if len(args) > 3 {
+		return wrongNumberOfArgumentsError(3, len(args))
+	}
+
+	var length int64
+	switch arg := args[0].(type) {
+	case *object.Array:
+		length = int64(len(arg.Elements))
+	case *object.String:
+		length = int64(utf8.RuneCountInString(arg.Value))
+	default:
+		return unsupportedArgumentType("slice", args[0])
+	}
+
+	start, ok := args[1].(*object.Integer)
+	if !ok {
+		return unsupportedArgumentType("slice", args[1])
+	}
+
+	end := &object.Integer{Value: length}
+	if len(args) == 3 {
+		if end, ok = args[2].(*object.Integer); !ok {
+			return unsupportedArgumentType("slice", args[2])
+		}
+	}
+
+	if start.Value < 0 || start.Value > end.Value || end.Value > length {
+		return newError("slice bounds out of range: [%d:%d] with length %d", start.Value, end.Value, length)
+	}
+
+	switch arg := args[0].(type) {
+	case *object.Array:
+		newElements := make([]object.Object, end.Value-start.Value)
+		copy(newElements, arg.Elements[start.Value:end.Value])
+		return &object.Array{Elements: newElements}
+
+	case *object.String:
+		runes := []rune(arg.Value)
+		return &object.String{Value: string(runes[start.Value:end.Value])}
+	}
+
+	return NULL
+}
+
+// rangeBuiltin takes (end), (start, end) or (start, end, step) and returns a
+// range, which produces its integers only as they are iterated.
+func rangeBuiltin(args ...object.Object) object.Object {
+	if len(args) < 1 {
+		return wrongNumberOfArgumentsError(1, len(args))
+	}
+	if len(args) > 3 {
+		return wrongNumberOfArgumentsError(3, len(args))
+	}
+
+	bounds := make([]int64, len(args))
+	for i, arg := range args {
+		integer, ok := arg.(*object.Integer)
+		if !ok {
+			return unsupportedArgumentType("range", arg)
+		}
+		bounds[i] = integer.Value
+	}
+
+	r := &object.Range{Step: 1}
+	switch len(bounds) {
+	case 1:
+		r.End = bounds[0]
+	case 2:
+		r.Start, r.End = bounds[0], bounds[1]
+	case 3:
+		r.Start, r.End, r.Step = bounds[0], bounds[1], bounds[2]
+	}
+
+	if r.Step == 0 {
+		return newError("range step must not be zero")
+	}
+
+	if _, ok := r.Count(); !ok {
+		return newError("range too long: %s", r.Inspect())
+	}
+
+	return r
+}
+
 func putsBuiltin(args ...object.Object) object.Object {
 	for _, arg := range args {
 		fmt.Println(arg.Inspect())
@@ -136,3 +254,174 @@ func putsBuiltin(args ...object.Object) object.Object {
 
 	return NULL
 }
+
+func exitBuiltin(args ...object.Object) object.Object {
+	if len(args) > 1 {
+		return wrongNumberOfArgumentsError(1, len(args))
+	}
+
+	if len(args) == 0 {
+		return &object.Exit{Code: 0}
+	}
+
+	switch arg := args[0].(type) {
+	case *object.Integer:
+		// Operating systems only keep the low byte of an exit status
+		if arg.Value < 0 || arg.Value > 255 {
+			return newError("exit code out of range: %d", arg.Value)
+		}
+
+		return &object.Exit{Code: arg.Value}
+
+	default:
+		return unsupportedArgumentType("exit", args[0])
+	}
+}
+
+func intBuiltin(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return wrongNumberOfArgumentsError(1, len(args))
+	}
+
+	switch arg := args[0].(type) {
+	case *object.Integer:
+		return arg
+
+	case *object.Float:
+		// Truncates towards zero
+		if math.IsNaN(arg.Value) || arg.Value < math.MinInt64 || arg.Value >= math.MaxInt64 {
+			return newError("cannot convert %s to integer", arg.Inspect())
+		}
+
+		return &object.Integer{Value: int64(arg.Value)}
+
+	case *object.String:
+		value, err := strconv.ParseInt(arg.Value, 0, 64)
+		if err != nil {
+			return newError("could not parse %q as integer", arg.Value)
+		}
+
+		return &object.Integer{Value: value}
+
+	default:
+		return unsupportedArgumentType("int", args[0])
+	}
+}
+
+func floatBuiltin(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return wrongNumberOfArgumentsError(1, len(args))
+	}
+
+	switch arg := args[0].(type) {
+	case *object.Integer:
+		return &object.Float{Value: float64(arg.Value)}
+
+	case *object.Float:
+		return arg
+
+	case *object.String:
+		value, err := strconv.ParseFloat(arg.Value, 64)
+		if err != nil {
+			return newError("could not parse %q as float", arg.Value)
+		}
+
+		return &object.Float{Value: value}
+
+	default:
+		return unsupportedArgumentType("float", args[0])
+	}
+}
+
+// round rounds half away from zero, optionally to a number of decimal places.
+func roundBuiltin(args ...object.Object) object.Object {
+	if len(args) < 1 {
+		return wrongNumberOfArgumentsError(1, len(args))
+	}
+
+	if len(args) > 2 {
+		return wrongNumberOfArgumentsError(2, len(args))
+	}
+
+	places := int64(0)
+	if len(args) == 2 {
+		placesArg, ok := args[1].(*object.Integer)
+		if !ok {
+			return unsupportedArgumentType("round", args[1])
+		}
+
+		places = placesArg.Value
+	}
+
+	switch arg := args[0].(type) {
+	case *object.Integer:
+		return arg
+
+	case *object.Float:
+		if places == 0 {
+			return &object.Float{Value: math.Round(arg.Value)}
+		}
+
+		scale := math.Pow(10, float64(places))
+		return &object.Float{Value: math.Round(arg.Value*scale) / scale}
+
+	default:
+		return unsupportedArgumentType("round", args[0])
+	}
+}
+
+func floorBuiltin(args ...object.Object) object.Object {
+	return floatFunctionBuiltin("floor", math.Floor, args)
+}
+
+func ceilBuiltin(args ...object.Object) object.Object {
+	return floatFunctionBuiltin("ceil", math.Ceil, args)
+}
+
+// floatFunctionBuiltin applies fn to a float argument. Integers are already
+// whole numbers and are returned unchanged.
+func floatFunctionBuiltin(name string, fn func(float64) float64, args []object.Object) object.Object {
+	if len(args) != 1 {
+		return wrongNumberOfArgumentsError(1, len(args))
+	}
+
+	switch arg := args[0].(type) {
+	case *object.Integer:
+		return arg
+
+	case *object.Float:
+		return &object.Float{Value: fn(arg.Value)}
+
+	default:
+		return unsupportedArgumentType(name, args[0])
+	}
+}
+
+// errorBuiltin makes an error value from a message and, optionally, a kind,
+// for a script to throw.
+func errorBuiltin(args ...object.Object) object.Object {
+	if len(args) < 1 {
+		return wrongNumberOfArgumentsError(1, len(args))
+	}
+
+	if len(args) > 2 {
+		return wrongNumberOfArgumentsError(2, len(args))
+	}
+
+	message, ok := args[0].(*object.String)
+	if !ok {
+		return unsupportedArgumentType("error", args[0])
+	}
+
+	kind := DefaultErrorKind
+	if len(args) == 2 {
+		kindArg, ok := args[1].(*object.String)
+		if !ok {
+			return unsupportedArgumentType("error", args[1])
+		}
+
+		kind = kindArg.Value
+	}
+
+	return &object.ErrorValue{Err: &object.Error{Message: message.Value, Kind: kind}}
+}
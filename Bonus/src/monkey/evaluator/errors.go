@@ -13,6 +13,16 @@ func wrongNumberOfArgumentsError(expected, actual int) *object.Error {
 	return newError("wrong number of arguments: expected=%d, got=%d", expected, actual)
 }
 
+// wrongNumberOfArgumentsToError is wrongNumberOfArgumentsError for a call to
+// the function called name, or to an anonymous one when name is empty.
+func wrongNumberOfArgumentsToError(name string, expected, actual int) *object.Error {
+	if name == "" {
+		return wrongNumberOfArgumentsError(expected, actual)
+	}
+
+	return newError("wrong number of arguments to `%s`: expected=%d, got=%d", name, expected, actual)
+}
+
 func unsupportedArgumentType(name string, arg object.Object) *object.Error {
 	return newError("argument to `%s` not supported: %s", name, arg.Type())
 }
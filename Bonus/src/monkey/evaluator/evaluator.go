@@ -1,8 +1,11 @@
 package evaluator
 
 import (
+	"context"
+	"math"
 	"monkey/ast"
 	"monkey/object"
+	"monkey/token"
 	"strings"
 )
 
@@ -13,70 +16,186 @@ var (
 )
 
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	return EvalContext(context.Background(), node, env, Limits{})
+}
+
+// EvalContext is Eval for programs that can't be trusted to finish. It stops
+// when ctx is done or limits are exceeded, and returns an *object.Halt saying
+// why.
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment, limits Limits) object.Object {
+	return EvalBudget(node, env, NewBudget(ctx, limits))
+}
+
+// EvalBudget is EvalContext with a budget made by the caller, who can then
+// see from its Stats what the evaluation used.
+func EvalBudget(node ast.Node, env *object.Environment, budget *Budget) object.Object {
+	in := &interpreter{budget: budget}
+	return in.eval(node, env)
+}
+
+// interpreter holds the state of a single call to Eval.
+type interpreter struct {
+	calls  []call // the functions being called, innermost last
+	budget *Budget
+}
+
+func (in *interpreter) eval(node ast.Node, env *object.Environment) object.Object {
+	if err := in.budget.Step(); err != nil {
+		return &object.Halt{Err: err, Span: node.Span()}
+	}
+
+	result := in.evalNode(node, env)
+
+	// Memory is checked once the node has made what it needed to, so that
+	// the last thing a program allocates is counted too
+	if _, ok := result.(*object.Halt); !ok {
+		if err := in.budget.CheckMemory(); err != nil {
+			return &object.Halt{Err: err, Span: node.Span()}
+		}
+	}
+
+	// Errors are positioned at the innermost node that produced them, and
+	// remember the calls that were active there
+	if err, ok := result.(*object.Error); ok {
+		if !err.Span.IsValid() {
+			err.Span = node.Span()
+		}
+
+		if err.Stack == nil {
+			err.Stack = in.stackTrace(err.Span)
+		}
+	}
+
+	return result
+}
+
+func (in *interpreter) evalNode(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	case *ast.Program:
-		return evalProgram(node, env)
+		return in.evalProgram(node, env)
 
 	case *ast.BlockStatement:
-		return evalBlockStatement(node, env)
+		return in.evalBlockStatement(node, env)
 
 	case *ast.IfExpression:
-		return evalIfExpression(node, env)
+		return in.evalIfExpression(node, env)
+
+	case *ast.MatchExpression:
+		return in.evalMatchExpression(node, env)
+
+	case *ast.TryExpression:
+		return in.evalTryExpression(node, env)
 
 	case *ast.LetStatement:
-		value := Eval(node.Value, env)
+		value := in.eval(node.Value, env)
 		if isError(value) {
 			return value
 		}
 
-		res := env.Add(node.Name.Value, value)
+		if name, ok := node.Name.(*ast.Identifier); ok {
+			NameFunction(value, name.Value)
+		}
+
+		res := in.bindPattern(node.Name, value, env, in.bind(env))
 		if isError(res) {
 			return res
 		}
 
+	case *ast.FunctionStatement:
+		// Already defined by hoistFunctions when the block started
+
 	case *ast.ReturnStatement:
-		value := Eval(node.ReturnValue, env)
+		value := in.eval(node.ReturnValue, env)
 		if isError(value) {
 			return value
 		}
 
 		return &object.ReturnValue{Value: value}
 
-	case *ast.ForLoopStatement:
-		value := evalForLoopStatement(node, env)
+	case *ast.ThrowStatement:
+		value := in.eval(node.Value, env)
 		if isError(value) {
 			return value
 		}
 
+		return ThrowError(value)
+
+	case *ast.ForLoopStatement:
+		return in.evalForLoopStatement(node, env)
+
+	case *ast.ForInStatement:
+		return in.evalForInStatement(node, env)
+
+	case *ast.WhileStatement:
+		return in.evalWhileStatement(node, env)
+
+	case *ast.BreakStatement:
+		var value object.Object = NULL
+		if node.Value != nil {
+			value = in.eval(node.Value, env)
+			if isError(value) {
+				return value
+			}
+		}
+
+		return &object.Break{Label: labelName(node.Label), Value: value}
+
+	case *ast.ContinueStatement:
+		return &object.Continue{Label: labelName(node.Label)}
+
 	case *ast.ExpressionStatement:
-		return Eval(node.Expression, env)
+		return in.eval(node.Expression, env)
 
 	case *ast.PrefixExpression:
-		right := Eval(node.Right, env)
+		if node.Operator == "++" || node.Operator == "--" {
+			return in.evalUpdateExpression(node.Operator, node.Right, nil, false, env)
+		}
+
+		right := in.eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
 
 		return evalPrefixExpression(node.Operator, right)
 
+	case *ast.PostfixExpression:
+		return in.evalUpdateExpression(node.Operator, node.Left, nil, true, env)
+
 	case *ast.InfixExpression:
-		return evalInfixExpression(node, env)
+		return in.evalInfixExpression(node, env)
 
 	case *ast.CallExpression:
-		function := Eval(node.Function, env)
+		function := in.eval(node.Function, env)
 		if isError(function) {
 			return function
 		}
 
-		args := evalExpressions(node.Arguments, env)
+		args := in.evalExpressions(node.Arguments, env)
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
 
-		return applyFunction(function, args)
+		names := make([]string, len(node.NamedArguments))
+		for i, arg := range node.NamedArguments {
+			value := in.eval(arg.Value, env)
+			if isError(value) {
+				return value
+			}
+
+			names[i] = arg.Name.Value
+			args = append(args, value)
+		}
+
+		// A call in tail position is made by the function it ends, in place
+		// of itself, once that function has returned, see callFunction
+		if fn, ok := function.(*object.Function); ok && node.Tail {
+			return &tailCall{function: fn, args: args, names: names, span: node.Span()}
+		}
+
+		return in.applyFunction(function, args, names, node.Span())
 
 	case *ast.IndexExpression:
-		return evalIndexExpression(node.Left, node.Index, env)
+		return in.evalIndexExpression(node.Left, node.Index, env)
 
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
@@ -84,56 +203,68 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
 
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
+
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 
 	case *ast.StringLiteral:
-		return &object.String{Value: node.Value}
+		return in.allocate(&object.String{Value: node.Value})
 
 	case *ast.ArrayLiteral:
-		elements := evalExpressions(node.Elements, env)
+		elements := in.evalExpressions(node.Elements, env)
 		if len(elements) == 1 && isError(elements[0]) {
 			return elements[0]
 		}
 
-		return &object.Array{Elements: elements}
+		return in.allocate(&object.Array{Elements: elements})
 
 	case *ast.FunctionLiteral:
 		return evalFunctionLiteral(node, env)
 
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		return in.evalHashLiteral(node, env)
 	}
 
 	return nil
 }
 
-func evalProgram(prog *ast.Program, env *object.Environment) object.Object {
+func (in *interpreter) evalProgram(prog *ast.Program, env *object.Environment) object.Object {
+	if res := in.hoistFunctions(prog.Statements, env); res != nil {
+		return res
+	}
+
 	var result object.Object
 
 	for _, statement := range prog.Statements {
-		result = Eval(statement, env)
+		result = in.eval(statement, env)
 
 		switch result := result.(type) {
 		case *object.ReturnValue:
 			return result.Value
-		case *object.Error:
+		case *object.Error, *object.Exit, *object.Halt:
 			return result
+		case *object.Break, *object.Continue:
+			return loopControlError(result)
 		}
 	}
 
 	return result
 }
 
-func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
+func (in *interpreter) evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
+	if res := in.hoistFunctions(block.Statements, env); res != nil {
+		return res
+	}
+
 	var result object.Object
 
 	for _, statement := range block.Statements {
-		result = Eval(statement, env)
+		result = in.eval(statement, env)
 
 		if result != nil {
-			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+			if result.Type() == object.RETURN_VALUE_OBJ || isError(result) {
 				return result
 			}
 		}
@@ -142,11 +273,34 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 	return result
 }
 
-func evalForLoopStatement(stmt *ast.ForLoopStatement, env *object.Environment) object.Object {
-	loopEnv := object.NewEnclosedEnvironment(env)
+// hoistFunctions defines the functions declared among statements before any of
+// them run, so each can be called from anywhere in the block.
+func (in *interpreter) hoistFunctions(statements []ast.Statement, env *object.Environment) object.Object {
+	for _, statement := range statements {
+		declaration, ok := statement.(*ast.FunctionStatement)
+		if !ok {
+			continue
+		}
+
+		function := in.eval(declaration.Function, env)
+		if isError(function) {
+			return function
+		}
+
+		if err, ok := env.Add(declaration.Name.Value, function).(*object.Error); ok {
+			err.Span = declaration.Span()
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (in *interpreter) evalForLoopStatement(stmt *ast.ForLoopStatement, env *object.Environment) object.Object {
+	loopEnv := in.enclose(env)
 
 	if stmt.InitializeStatement != nil {
-		initializeResult := Eval(stmt.InitializeStatement, loopEnv)
+		initializeResult := in.eval(stmt.InitializeStatement, loopEnv)
 		if isError(initializeResult) {
 			return initializeResult
 		}
@@ -155,57 +309,151 @@ func evalForLoopStatement(stmt *ast.ForLoopStatement, env *object.Environment) o
 	var continueResult object.Object = TRUE
 
 	if stmt.ContinueExpression != nil {
-		continueResult = Eval(stmt.ContinueExpression, loopEnv)
+		continueResult = in.eval(stmt.ContinueExpression, loopEnv)
 		if isError(continueResult) {
 			return continueResult
 		}
 	}
 
-	for isTruthy(continueResult) {
-		result := Eval(stmt.Body, loopEnv)
+	label := labelName(stmt.Label)
 
-		if result != nil {
-			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
-				return result
+	for isTruthy(continueResult) {
+		if result, done := in.evalLoopBody(stmt.Body, loopEnv, label); done {
+			// A return ends only this kind of loop, not the function
+			if _, ok := result.(*object.ReturnValue); ok {
+				return NULL
 			}
+			return result
 		}
 
 		if stmt.StepExpression != nil {
-			stepResult := Eval(stmt.StepExpression, loopEnv)
+			stepResult := in.eval(stmt.StepExpression, loopEnv)
 			if isError(stepResult) {
 				return stepResult
 			}
 		}
 
 		if stmt.ContinueExpression != nil {
-			continueResult = Eval(stmt.ContinueExpression, loopEnv)
+			continueResult = in.eval(stmt.ContinueExpression, loopEnv)
 			if isError(continueResult) {
 				return continueResult
 			}
 		}
 	}
 
-	return nil
+	return NULL
+}
+
+func (in *interpreter) evalForInStatement(stmt *ast.ForInStatement, env *object.Environment) object.Object {
+	iterable := in.eval(stmt.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+
+	iterator, err := NewIterator(iterable)
+	if err != nil {
+		err.Span = stmt.Iterable.Span()
+		return err
+	}
+
+	label := labelName(stmt.Label)
+
+	for {
+		values, ok := iterator.Next(len(stmt.Variables))
+		if !ok {
+			return NULL
+		}
+
+		// Every iteration gets its own variables, so that functions created
+		// in the body keep the values of the iteration that created them
+		iterationEnv := in.enclose(env)
+		for i, variable := range stmt.Variables {
+			if res := in.bindPattern(variable, values[i], iterationEnv, iterationEnv.Add); isError(res) {
+				return res
+			}
+		}
+
+		if result, done := in.evalLoopBody(stmt.Body, iterationEnv, label); done {
+			return result
+		}
+	}
+}
+
+func (in *interpreter) evalWhileStatement(stmt *ast.WhileStatement, env *object.Environment) object.Object {
+	label := labelName(stmt.Label)
+
+	for {
+		if !stmt.IsDoWhile {
+			condition := in.eval(stmt.Condition, env)
+			if isError(condition) {
+				return condition
+			}
+			if !isTruthy(condition) {
+				return NULL
+			}
+		}
+
+		bodyEnv := in.enclose(env)
+		if result, done := in.evalLoopBody(stmt.Body, bodyEnv, label); done {
+			return result
+		}
+
+		if stmt.IsDoWhile {
+			condition := in.eval(stmt.Condition, env)
+			if isError(condition) {
+				return condition
+			}
+			if !isTruthy(condition) {
+				return NULL
+			}
+		}
+	}
+}
+
+// evalLoopBody runs one iteration of a loop. It reports whether the loop
+// should stop and, if so, the loop's result: the value given to break, or
+// whatever is unwinding past the loop.
+func (in *interpreter) evalLoopBody(body *ast.BlockStatement, env *object.Environment, label string) (object.Object, bool) {
+	result := in.eval(body, env)
+
+	switch result := result.(type) {
+	case *object.Break:
+		if appliesToLoop(result.Label, label) {
+			return result.Value, true
+		}
+		return result, true
+	case *object.Continue:
+		if !appliesToLoop(result.Label, label) {
+			return result, true
+		}
+	case *object.ReturnValue:
+		return result, true
+	default:
+		if isError(result) {
+			return result, true
+		}
+	}
+
+	return nil, false
 }
 
-func evalIfExpression(expr *ast.IfExpression, env *object.Environment) object.Object {
+func (in *interpreter) evalIfExpression(expr *ast.IfExpression, env *object.Environment) object.Object {
 	for _, clause := range expr.Clauses {
-		result := Eval(clause.Condition, env)
+		result := in.eval(clause.Condition, env)
 
 		if isError(result) {
 			return result
 		}
 
 		if isTruthy(result) {
-			blockEnv := object.NewEnclosedEnvironment(env)
-			return Eval(clause.Consequence, blockEnv)
+			blockEnv := in.enclose(env)
+			return in.eval(clause.Consequence, blockEnv)
 		}
 	}
 
 	if expr.Alternative != nil {
-		blockEnv := object.NewEnclosedEnvironment(env)
-		return Eval(expr.Alternative, blockEnv)
+		blockEnv := in.enclose(env)
+		return in.eval(expr.Alternative, blockEnv)
 	} else {
 		return NULL
 	}
@@ -217,6 +465,8 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 		return evalBangOperatorExpression(right)
 	case "-":
 		return evalMinusPrefixOperatorExpression(right)
+	case "~":
+		return evalTildePrefixOperatorExpression(right)
 	default:
 		return newError("unknown operator: %s%s", operator, right.Type())
 	}
@@ -236,36 +486,63 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
+	switch right := right.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: -right.Value}
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
+}
+
+func evalTildePrefixOperatorExpression(right object.Object) object.Object {
+	integer, ok := right.(*object.Integer)
+	if !ok {
+		return newError("unknown operator: ~%s", right.Type())
+	}
 
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
+	return &object.Integer{Value: ^integer.Value}
 }
 
-func evalInfixExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+func (in *interpreter) evalInfixExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
 	operator := node.Operator
 
 	// This needs to be tested first or left will resolve a value and not an identifier
 	if operator == "=" {
-		return evalInfixAssignOperator(node, env)
+		return in.evalInfixAssignOperator(node, env)
+	}
+
+	if _, ok := updateOperators[operator]; ok {
+		return in.evalUpdateExpression(operator, node.Left, node.Right, false, env)
 	}
 
-	left := Eval(node.Left, env)
+	if operator == "&&" || operator == "||" {
+		return in.evalLogicalExpression(node, env)
+	}
+
+	left := in.eval(node.Left, env)
 	if isError(left) {
 		return left
 	}
 
-	right := Eval(node.Right, env)
+	right := in.eval(node.Right, env)
 	if isError(right) {
 		return right
 	}
 
+	return in.allocate(evalInfixOperator(operator, left, right))
+}
+
+func evalInfixOperator(operator string, left, right object.Object) object.Object {
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
 
+	// Integers are promoted when mixed with floats
+	case isNumber(left) && isNumber(right):
+		return evalFloatInfixExpression(operator, left, right)
+
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
 
@@ -284,19 +561,186 @@ func evalInfixExpression(node *ast.InfixExpression, env *object.Environment) obj
 	}
 }
 
-func evalInfixAssignOperator(node *ast.InfixExpression, env *object.Environment) object.Object {
-	if ident, ok := node.Left.(*ast.Identifier); ok {
-		right := Eval(node.Right, env)
+// evalLogicalExpression short-circuits && and ||. The result is the operand
+// that decided the outcome rather than a boolean, so `a || b` gives a if it's
+// truthy and b otherwise, and `a && b` gives a if it's falsy and b otherwise.
+func (in *interpreter) evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := in.eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	if isTruthy(left) == (node.Operator == "||") {
+		return left
+	}
+
+	return in.eval(node.Right, env)
+}
+
+func (in *interpreter) evalInfixAssignOperator(node *ast.InfixExpression, env *object.Environment) object.Object {
+	switch target := node.Left.(type) {
+	case *ast.Identifier:
+		right := in.eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
 
-		return evalInfixAssignExpression(ident, right, env)
-	} else {
+		return evalInfixAssignExpression(target, right, env)
+
+	case *ast.IndexExpression:
+		left := in.eval(target.Left, env)
+		if isError(left) {
+			return left
+		}
+
+		index := in.eval(target.Index, env)
+		if isError(index) {
+			return index
+		}
+
+		right := in.eval(node.Right, env)
+		if isError(right) {
+			return right
+		}
+
+		return in.assignIndex(left, index, right)
+
+	case *ast.ArrayPattern, *ast.HashPattern:
+		right := in.eval(node.Right, env)
+		if isError(right) {
+			return right
+		}
+
+		if res := in.bindPattern(target.(ast.Pattern), right, env, env.Set); isError(res) {
+			return res
+		}
+
+		return right
+
+	default:
 		return newError("Left side of assign expression must be a variable")
 	}
 }
 
+// The operator applied by each compound assignment, and by ++ and --
+var updateOperators = map[string]string{
+	"+=": "+",
+	"-=": "-",
+	"*=": "*",
+	"/=": "/",
+	"%=": "%",
+	"++": "+",
+	"--": "-",
+}
+
+// evalUpdateExpression evaluates a compound assignment such as `x += 2`, or
+// ++ and -- when right is nil. The target is evaluated once, then its value
+// is combined with the right side and assigned back as `=` would. The result
+// is the new value, or the previous one for postfix ++ and --.
+func (in *interpreter) evalUpdateExpression(
+	operator string,
+	target ast.Expression,
+	right ast.Expression,
+	postfix bool,
+	env *object.Environment,
+) object.Object {
+	var left, index, current object.Object
+
+	switch target := target.(type) {
+	case *ast.Identifier:
+		current = evalIdentifier(target, env)
+
+	case *ast.IndexExpression:
+		left = in.eval(target.Left, env)
+		if isError(left) {
+			return left
+		}
+
+		index = in.eval(target.Index, env)
+		if isError(index) {
+			return index
+		}
+
+		current = evalIndex(left, index)
+
+	default:
+		if right == nil {
+			return newError("operand of %s must be a variable", operator)
+		}
+		return newError("Left side of assign expression must be a variable")
+	}
+
+	if isError(current) {
+		return current
+	}
+
+	var operand object.Object = &object.Integer{Value: 1}
+	if right != nil {
+		operand = in.eval(right, env)
+		if isError(operand) {
+			return operand
+		}
+	}
+
+	updated := in.allocate(evalInfixOperator(updateOperators[operator], current, operand))
+	if isError(updated) {
+		return updated
+	}
+
+	var result object.Object
+	if ident, ok := target.(*ast.Identifier); ok {
+		result = evalInfixAssignExpression(ident, updated, env)
+	} else {
+		result = in.assignIndex(left, index, updated)
+	}
+
+	if postfix && !isError(result) {
+		return current
+	}
+
+	return result
+}
+
+// evalIndexAssignment stores value in an array or hash in place, so the
+// change is seen through every reference to it. Arrays can't grow this way,
+// the index must already exist.
+func evalIndexAssignment(leftObj, indexObj, value object.Object) object.Object {
+	if value == nil {
+		return newError("cannot assign empty value to index")
+	}
+
+	switch left := leftObj.(type) {
+	case *object.Array:
+		index, ok := indexObj.(*object.Integer)
+		if !ok {
+			return newError("array does not support indexing from type: %s", indexObj.Type())
+		}
+
+		if index.Value < 0 {
+			return newError("array index must be non-negative: %d", index.Value)
+		}
+
+		if index.Value >= int64(len(left.Elements)) {
+			return newError("index outside array bounds: %d", index.Value)
+		}
+
+		left.Elements[index.Value] = value
+
+	case *object.Hash:
+		key, ok := indexObj.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", indexObj.Type())
+		}
+
+		left.Pairs[key.HashKey()] = object.HashPair{Key: indexObj, Value: value}
+
+	default:
+		return newError("type does not support index assignment: %s", leftObj.Type())
+	}
+
+	return value
+}
+
 func evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
 	leftInt, leftOk := left.(*object.Integer)
 	rightInt, rightOk := right.(*object.Integer)
@@ -318,13 +762,109 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	case "*":
 		return nativeIntToIntegerObject(leftVal * rightVal)
 	case "/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
 		return nativeIntToIntegerObject(leftVal / rightVal)
+	case "%":
+		// The result takes the sign of the dividend, as in Go
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return nativeIntToIntegerObject(leftVal % rightVal)
+	case "**":
+		return evalIntegerPower(leftVal, rightVal)
+
+	// Bitwise
+	case "&":
+		return nativeIntToIntegerObject(leftVal & rightVal)
+	case "|":
+		return nativeIntToIntegerObject(leftVal | rightVal)
+	case "^":
+		return nativeIntToIntegerObject(leftVal ^ rightVal)
+	case "<<":
+		if rightVal < 0 {
+			return newError("negative shift count: %d", rightVal)
+		}
+		return nativeIntToIntegerObject(leftVal << uint64(rightVal))
+	case ">>":
+		if rightVal < 0 {
+			return newError("negative shift count: %d", rightVal)
+		}
+		return nativeIntToIntegerObject(leftVal >> uint64(rightVal))
 
 	// Comparison
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+
+	default:
+		return newError("unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
+	}
+}
+
+// evalIntegerPower raises base to exp by repeated squaring. Like the other
+// integer operators it wraps on overflow. A negative exponent gives a float.
+func evalIntegerPower(base, exp int64) object.Object {
+	if exp < 0 {
+		return &object.Float{Value: math.Pow(float64(base), float64(exp))}
+	}
+
+	result := int64(1)
+	for exp > 0 {
+		if exp&1 == 1 {
+			result *= base
+		}
+		base *= base
+		exp >>= 1
+	}
+
+	return nativeIntToIntegerObject(result)
+}
+
+func evalFloatInfixExpression(operator string, left, right object.Object) object.Object {
+	leftVal, leftOk := toFloat(left)
+	rightVal, rightOk := toFloat(right)
+
+	if !leftOk || !rightOk {
+		return newError("type mismatch: %s %s %s",
+			left.Type(), operator, right.Type())
+	}
+
+	switch operator {
+	// Arithmetic
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "/":
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
+	case "**":
+		return &object.Float{Value: math.Pow(leftVal, rightVal)}
+
+	// Comparison
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
@@ -360,6 +900,10 @@ func evalStringInfixExpression(operator string, left, right object.Object) objec
 		return nativeBoolToBooleanObject(comp < 0)
 	case ">":
 		return nativeBoolToBooleanObject(comp > 0)
+	case "<=":
+		return nativeBoolToBooleanObject(comp <= 0)
+	case ">=":
+		return nativeBoolToBooleanObject(comp >= 0)
 	case "==":
 		return nativeBoolToBooleanObject(comp == 0)
 	case "!=":
@@ -375,11 +919,21 @@ func evalInfixAssignExpression(left *ast.Identifier, right object.Object, env *o
 	return env.Set(left.Value, right)
 }
 
-func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
+func (in *interpreter) evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
 	var result []object.Object
 
 	for _, e := range exps {
-		evaluated := Eval(e, env)
+		if spread, ok := e.(*ast.SpreadExpression); ok {
+			items := in.evalSpreadExpression(spread, env)
+			if len(items) == 1 && isError(items[0]) {
+				return items
+			}
+
+			result = append(result, items...)
+			continue
+		}
+
+		evaluated := in.eval(e, env)
 		if isError(evaluated) {
 			return []object.Object{evaluated}
 		}
@@ -390,52 +944,175 @@ func evalExpressions(exps []ast.Expression, env *object.Environment) []object.Ob
 	return result
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+func (in *interpreter) evalSpreadExpression(spread *ast.SpreadExpression, env *object.Environment) []object.Object {
+	value := in.eval(spread.Value, env)
+	if isError(value) {
+		return []object.Object{value}
+	}
+
+	items, res := SpreadItems(value, in.budget)
+	switch res := res.(type) {
+	case *object.Error:
+		res.Span = spread.Span()
+		return []object.Object{res}
+	case *object.Halt:
+		res.Span = spread.Span()
+		return []object.Object{res}
+	}
+
+	return items
+}
+
+// applyFunction calls fn with args, the last len(names) of which were given by
+// name.
+func (in *interpreter) applyFunction(fn object.Object, args []object.Object, names []string, span token.Span) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
-		extendedEnv, errObj := extendFunctionEnv(fn, args)
-		if errObj != nil {
-			return errObj
+		return in.callFunction(fn, args, names, span)
+
+	case *object.Builtin:
+		if len(names) > 0 {
+			return newError("builtin functions do not take named arguments")
+		}
+
+		if res := in.enterCall(fn, span); res != nil {
+			return res
 		}
+		defer in.leaveCall()
 
-		evaluated := Eval(fn.Body, extendedEnv)
-		return unwrapReturnValue(evaluated)
+		// The builtin's own frame is only on the stack while it runs
+		result := fn.Fn(args...)
+		in.budget.Allocate(BuiltinAllocation(result, args))
 
-	case *object.Builtin:
-		return fn.Fn(args...)
+		if err := in.budget.Spend(BuiltinSteps(result, args)); err != nil {
+			return &object.Halt{Err: err, Span: span}
+		}
+
+		if err, ok := result.(*object.Error); ok && err.Stack == nil {
+			if !err.Span.IsValid() {
+				err.Span = span
+			}
+
+			err.Stack = in.stackTrace(err.Span)
+		}
+
+		return result
 
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
 }
 
-func extendFunctionEnv(fn *object.Function, args []object.Object) (*object.Environment, *object.Error) {
-	env := object.NewEnclosedEnvironment(fn.Env)
+// callFunction calls fn, then the function it ends by calling, if any, and so
+// on. Each tail call takes over the frame of the function that made it, so
+// tail recursion runs in constant space.
+func (in *interpreter) callFunction(fn *object.Function, args []object.Object, names []string, span token.Span) object.Object {
+	// The validation that only the last parameter is variodic is done when
+	// building the function in evalFunctionLiteral. Arguments that don't
+	// fit are the caller's mistake, so are reported before the call starts.
+	values, errObj := MatchArguments(fn.Name, fn.Parameters, args, names)
+	if errObj != nil {
+		return errObj
+	}
+
+	if res := in.enterCall(fn, span); res != nil {
+		return res
+	}
+	defer in.leaveCall()
+
+	for {
+		extendedEnv, res := in.extendFunctionEnv(fn, values)
+		if res != nil {
+			return res
+		}
+
+		evaluated := in.eval(fn.Body, extendedEnv)
+		switch evaluated.(type) {
+		case *object.Break, *object.Continue:
+			return loopControlError(evaluated)
+		}
+
+		result := unwrapReturnValue(evaluated)
+
+		next, ok := result.(*tailCall)
+		if !ok {
+			return result
+		}
 
-	argLen := len(args)
-	paramLen := len(fn.Parameters)
+		// The function making the tail call is still the one running as far
+		// as errors in its arguments are concerned
+		values, errObj = MatchArguments(next.function.Name, next.function.Parameters, next.args, next.names)
+		if errObj != nil {
+			errObj.Span = next.span
+			errObj.Stack = in.stackTrace(next.span)
+			return errObj
+		}
 
-	if paramLen < argLen && !(paramLen > 0 && fn.Parameters[paramLen-1].IsVariodic) {
-		return nil, wrongNumberOfArgumentsError(paramLen, argLen)
+		fn = next.function
+		in.calls[len(in.calls)-1].function = fn
 	}
+}
 
-	for paramIdx, param := range fn.Parameters {
-		if param.IsVariodic {
-			// The validation that this was actually the last parameter is
-			// done when building the function in evalFunctionLiteral
-			arrayArg := &object.Array{Elements: args[paramIdx:]}
-			env.Add(param.Name.Value, arrayArg)
-			break
-		} else if paramIdx >= argLen {
-			return nil, wrongNumberOfArgumentsError(paramLen, argLen)
+// extendFunctionEnv binds the values MatchArguments lined up with the
+// parameters of fn in a new environment for its body.
+func (in *interpreter) extendFunctionEnv(fn *object.Function, values []object.Object) (*object.Environment, object.Object) {
+	in.budget.Allocate(ArgumentAllocation(fn.Parameters, values))
+
+	env := in.enclose(fn.Env)
+	bind := in.bind(env)
+
+	// Defaults are evaluated and patterns unpacked once every argument given
+	// has been bound, as the VM does, so they can refer to any other parameter
+	later := []int{}
+
+	for i, param := range fn.Parameters {
+		if name, ok := param.Name.(*ast.Identifier); ok && (values[i] != nil || param.Default == nil) {
+			bind(name.Value, values[i])
 		} else {
-			env.Add(param.Name.Value, args[paramIdx])
+			later = append(later, i)
+		}
+	}
+
+	for _, i := range later {
+		param := fn.Parameters[i]
+
+		value := values[i]
+		if value == nil {
+			value = in.eval(param.Default, env)
+			if isError(value) {
+				return nil, value
+			}
+		}
+
+		res := in.bindPattern(param.Name, value, env, bind)
+		if isError(res) {
+			return nil, res
 		}
 	}
 
 	return env, nil
 }
 
+func labelName(label *ast.Identifier) string {
+	if label == nil {
+		return ""
+	}
+
+	return label.Value
+}
+
+// appliesToLoop reports whether a break or continue with the given target
+// label is meant for a loop with the given label.
+func appliesToLoop(target, label string) bool {
+	return target == "" || target == label
+}
+
+// loopControlError reports a break or continue that found no loop to apply
+// to. The parser rejects these, so only hand built programs get here.
+func loopControlError(obj object.Object) *object.Error {
+	return newError("%s outside loop", obj.Inspect())
+}
+
 func unwrapReturnValue(obj object.Object) object.Object {
 	if returnValue, ok := obj.(*object.ReturnValue); ok {
 		return returnValue.Value
@@ -444,33 +1121,59 @@ func unwrapReturnValue(obj object.Object) object.Object {
 	return obj
 }
 
-func evalIndexExpression(leftExp, indexExp ast.Expression, env *object.Environment) object.Object {
-	leftObj := Eval(leftExp, env)
+func (in *interpreter) evalIndexExpression(leftExp, indexExp ast.Expression, env *object.Environment) object.Object {
+	leftObj := in.eval(leftExp, env)
 	if isError(leftObj) {
 		return leftObj
 	}
 
+	// Report unsupported types before evaluating the index expression
+	if !supportsIndexing(leftObj) {
+		return unsupportedIndexingError(leftObj)
+	}
+
+	indexObj := in.eval(indexExp, env)
+	if isError(indexObj) {
+		return indexObj
+	}
+
+	return evalIndex(leftObj, indexObj)
+}
+
+func evalIndex(leftObj, indexObj object.Object) object.Object {
 	switch leftObj.Type() {
 	case object.ARRAY_OBJ:
-		return evalArrayIndexExpression(leftObj, indexExp, env)
+		return evalArrayIndexExpression(leftObj, indexObj)
 	case object.HASH_OBJ:
-		return evalHashIndexExpression(leftObj, indexExp, env)
+		return evalHashIndexExpression(leftObj, indexObj)
+	case object.STRING_OBJ:
+		return evalStringIndexExpression(leftObj, indexObj)
+	case object.ERROR_VALUE_OBJ:
+		return evalErrorField(leftObj, indexObj)
+	default:
+		return unsupportedIndexingError(leftObj)
+	}
+}
+
+func supportsIndexing(obj object.Object) bool {
+	switch obj.Type() {
+	case object.ARRAY_OBJ, object.HASH_OBJ, object.STRING_OBJ, object.ERROR_VALUE_OBJ:
+		return true
 	default:
-		return newError("type does not support indexing: %s", leftObj.Type())
+		return false
 	}
 }
 
-func evalArrayIndexExpression(arrayObj object.Object, indexExp ast.Expression, env *object.Environment) object.Object {
+func unsupportedIndexingError(obj object.Object) *object.Error {
+	return newError("type does not support indexing: %s", obj.Type())
+}
+
+func evalArrayIndexExpression(arrayObj, indexObj object.Object) object.Object {
 	array, ok := arrayObj.(*object.Array)
 	if !ok {
 		panic("Array object was not an Array type")
 	}
 
-	indexObj := Eval(indexExp, env)
-	if isError(indexObj) {
-		return indexObj
-	}
-
 	if indexObj.Type() != object.INTEGER_OBJ {
 		return newError("array does not support indexing from type: %s", indexObj.Type())
 	}
@@ -491,15 +1194,38 @@ func evalArrayIndexExpression(arrayObj object.Object, indexExp ast.Expression, e
 	return array.Elements[index.Value]
 }
 
-func evalHashIndexExpression(hashObj object.Object, indexExp ast.Expression, env *object.Environment) object.Object {
-	hash, ok := hashObj.(*object.Hash)
+// evalStringIndexExpression indexes by character rather than by byte, the
+// result is a string holding the single character.
+func evalStringIndexExpression(stringObj, indexObj object.Object) object.Object {
+	str, ok := stringObj.(*object.String)
 	if !ok {
-		panic("Hash object was not an Hash type")
+		panic("String object was not a String type")
 	}
 
-	indexObj := Eval(indexExp, env)
-	if isError(indexObj) {
-		return indexObj
+	index, ok := indexObj.(*object.Integer)
+	if !ok {
+		return newError("string does not support indexing from type: %s", indexObj.Type())
+	}
+
+	if index.Value < 0 {
+		return newError("string index must be non-negative: %d", index.Value)
+	}
+
+	var i int64
+	for _, ch := range str.Value {
+		if i == index.Value {
+			return &object.String{Value: string(ch)}
+		}
+		i++
+	}
+
+	return newError("index outside string bounds: %d", index.Value)
+}
+
+func evalHashIndexExpression(hashObj, indexObj object.Object) object.Object {
+	hash, ok := hashObj.(*object.Hash)
+	if !ok {
+		panic("Hash object was not an Hash type")
 	}
 
 	hashIndex, ok := indexObj.(object.Hashable)
@@ -530,14 +1256,28 @@ func evalFunctionLiteral(function *ast.FunctionLiteral, env *object.Environment)
 
 	params := function.Parameters
 	body := function.Body
-	return &object.Function{Parameters: params, Body: body, Env: env}
+	return &object.Function{Name: function.Name, Parameters: params, Body: body, Env: env}
 }
 
-func evalHashLiteral(hash *ast.HashLiteral, env *object.Environment) object.Object {
+func (in *interpreter) evalHashLiteral(hash *ast.HashLiteral, env *object.Environment) object.Object {
 	pairs := make(map[object.HashKey]object.HashPair)
 
-	for keyExp, valueExp := range hash.Pairs {
-		key := Eval(keyExp, env)
+	for _, keyExp := range hash.Order {
+		if spread, ok := keyExp.(*ast.SpreadExpression); ok {
+			value := in.eval(spread.Value, env)
+			if isError(value) {
+				return value
+			}
+
+			if err := SpreadPairs(pairs, value); err != nil {
+				err.Span = spread.Span()
+				return err
+			}
+
+			continue
+		}
+
+		key := in.eval(keyExp, env)
 		if isError(key) {
 			return key
 		}
@@ -547,7 +1287,7 @@ func evalHashLiteral(hash *ast.HashLiteral, env *object.Environment) object.Obje
 			return newError("unusable as hash key: %s", key.Type())
 		}
 
-		value := Eval(valueExp, env)
+		value := in.eval(hash.Pairs[keyExp], env)
 		if isError(value) {
 			return value
 		}
@@ -556,7 +1296,7 @@ func evalHashLiteral(hash *ast.HashLiteral, env *object.Environment) object.Obje
 		pairs[hashed] = object.HashPair{Key: key, Value: value}
 	}
 
-	return &object.Hash{Pairs: pairs}
+	return in.allocate(&object.Hash{Pairs: pairs})
 }
 
 func evalIdentifier(
@@ -571,7 +1311,28 @@ func evalIdentifier(
 		return builtin
 	}
 
-	return newError("identifier not found: " + node.Value)
+	return newError("identifier not found: %s", node.Value)
+}
+
+// EvalPrefixOperator, EvalInfixOperator, EvalIndex and EvalIndexAssignment
+// apply an operator to operands that have already been evaluated. They are
+// shared with the bytecode VM so that both engines agree on semantics and
+// error messages.
+
+func EvalPrefixOperator(operator string, right object.Object) object.Object {
+	return evalPrefixExpression(operator, right)
+}
+
+func EvalInfixOperator(operator string, left, right object.Object) object.Object {
+	return evalInfixOperator(operator, left, right)
+}
+
+func EvalIndex(left, index object.Object) object.Object {
+	return evalIndex(left, index)
+}
+
+func EvalIndexAssignment(left, index, value object.Object) object.Object {
+	return evalIndexAssignment(left, index, value)
 }
 
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
@@ -585,6 +1346,27 @@ func nativeIntToIntegerObject(value int64) *object.Integer {
 	return &object.Integer{Value: value}
 }
 
+// IsTruthy reports whether obj counts as true in a condition.
+func IsTruthy(obj object.Object) bool {
+	return isTruthy(obj)
+}
+
+func isNumber(obj object.Object) bool {
+	rt := obj.Type()
+	return rt == object.INTEGER_OBJ || rt == object.FLOAT_OBJ
+}
+
+func toFloat(obj object.Object) (float64, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value), true
+	case *object.Float:
+		return obj.Value, true
+	default:
+		return 0, false
+	}
+}
+
 func isTruthy(obj object.Object) bool {
 	switch obj {
 	case NULL:
@@ -598,6 +1380,18 @@ func isTruthy(obj object.Object) bool {
 	}
 }
 
+// isError reports whether obj stops evaluation. Besides errors this is true
+// of exit, which unwinds all the way to the host, and of break and continue,
+// which unwind to their loop.
 func isError(obj object.Object) bool {
-	return obj != nil && obj.Type() == object.ERROR_OBJ
+	if obj == nil {
+		return false
+	}
+
+	switch obj.Type() {
+	case object.ERROR_OBJ, object.EXIT_OBJ, object.HALT_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+		return true
+	default:
+		return false
+	}
 }
@@ -1,10 +1,16 @@
 package evaluator
 
 import (
+	"context"
+	"errors"
+	"monkey/ast"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"monkey/token"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestEvalIntegerExpression(t *testing.T) {
@@ -27,6 +33,26 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"3 * 3 * 3 + 10", 37},
 		{"3 * (3 * 3) + 10", 37},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"0xFF + 0o17 + 0b1010", 280},
+		{"1_000_000 / 1_000", 1000},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"(-2) ** 3", -8},
+		{"3 ** 0", 1},
+		{"2 ** 64", 0},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"~5", -6},
+		{"1 << 10", 1024},
+		{"-16 >> 2", -4},
+		{"1 << 64", 0},
+		{"1 + 2 << 3", 24},
+		{"1 | 2 ^ 3 & 4", 3},
+		{"12345 % 16 & 15", 9},
 	}
 
 	for _, tt := range tests {
@@ -35,6 +61,32 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 }
 
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.5", 3.5},
+		{"-2.5", -2.5},
+		{".5 + .25", 0.75},
+		{"1.5 * 2", 3},
+		{"2 * 1.5", 3},
+		{"3 / 2.0", 1.5},
+		{"1 - 0.5", 0.5},
+		{"1e3 + 1", 1001},
+		{"10 * 5.0 / 100", 0.5},
+		{"7.5 % 2", 1.5},
+		{"2 ** 0.5 ** 2", 1.189207115002721},
+		{"2 ** -1", 0.5},
+		{"9.0 ** 0.5", 3},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testFloatObject(t, evaluated, tt.expected)
+	}
+}
+
 func TestEvalBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -44,6 +96,16 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"false", false},
 		{"1 < 2", true},
 		{"1 > 2", false},
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{"1.5 <= 1", false},
+		{"2 >= 1.5", true},
+		{`"a" <= "b"`, true},
+		{`"b" >= "b"`, true},
+		{"7 & 1 == 1", true},
 		{"1 < 1", false},
 		{"1 > 1", false},
 		{"1 == 1", true},
@@ -67,6 +129,11 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{`"a" < "a"`, false},
 		{`"a" > "b"`, false},
 		{`"a" > "a"`, false},
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"1 == 1.0", true},
+		{"1.0 != 1", false},
+		{"0.1 + 0.2 == 0.3", false},
 	}
 
 	for _, tt := range tests {
@@ -93,6 +160,50 @@ func TestBangOperator(t *testing.T) {
 	}
 }
 
+func TestLogicalOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 < 2 && 2 < 3", true},
+		{"1 < 2 && 2 > 3 || 3 == 3", true},
+		// The deciding operand is the result
+		{"1 && 2", 2},
+		{"1 || 2", 1},
+		{"false || 5", 5},
+		{"if (false) { 1 } || 7", 7},
+		{"if (false) { 1 } && 7", nil},
+		// The right side is only evaluated when needed
+		{"false && foobar", false},
+		{"true || foobar", true},
+		{"let x = 0; let f = fn() { x = x + 1; true }; false && f(); true || f(); x", 0},
+		{"let x = 0; let f = fn() { x = x + 1; true }; true && f(); false || f(); x", 2},
+		{"true && foobar", "identifier not found: foobar"},
+		{"(1 + true) || 1", "type mismatch: INTEGER + BOOLEAN"},
+		{"let i = 0; for (; i < 10 && i != 5; i = i + 1) { }; i", 5},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			testErrorObject(t, evaluated, expected)
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
 func TestIfElseExpressions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -152,6 +263,10 @@ func TestReturnStatements(t *testing.T) {
 			`,
 			5,
 		},
+		{"fn f() { for (let i = 0; i < 10; i++) { if (i == 5) { return i } }; 7 } f()", 7},
+		{"fn f() { for (i in range(10)) { if (i == 5) { return i } }; 7 } f()", 5},
+		{"fn f() { let i = 0; while (i < 10) { if (i == 5) { return i }; i++ }; 7 } f()", 5},
+		{"fn f() { let i = 0; do { if (i == 5) { return i }; i++ } while (i < 10); 7 } f()", 5},
 	}
 
 	for _, tt := range tests {
@@ -261,7 +376,7 @@ func TestErrorHandling(t *testing.T) {
 			"cannot assign empty value to variable",
 		},
 		{
-			"let x = for (;;) {}",
+			"let x = for (;;) { break if (true) { } }",
 			"cannot assign empty value to variable",
 		},
 		{
@@ -272,6 +387,50 @@ func TestErrorHandling(t *testing.T) {
 			`{"name": "Monkey"}[fn(x) { x }];`,
 			"unusable as hash key: FUNCTION",
 		},
+		{
+			"1 / 0",
+			"division by zero",
+		},
+		{
+			"let zero = 0; 5 % zero",
+			"division by zero",
+		},
+		{
+			"1 << -1",
+			"negative shift count: -1",
+		},
+		{
+			"1.5 & 1",
+			"unknown operator: FLOAT & INTEGER",
+		},
+		{
+			"~1.5",
+			"unknown operator: ~FLOAT",
+		},
+		{
+			`"a" % "b"`,
+			"unknown operator: STRING % STRING",
+		},
+		{
+			`"héllo"[5]`,
+			"index outside string bounds: 5",
+		},
+		{
+			`"héllo"[-1]`,
+			"string index must be non-negative: -1",
+		},
+		{
+			`"héllo"["h"]`,
+			"string does not support indexing from type: STRING",
+		},
+		{
+			"for (x in 5) { }",
+			"cannot iterate over INTEGER",
+		},
+		{
+			"for (x, x in [1]) { }",
+			"identifier already exists: x",
+		},
 	}
 
 	for _, tt := range tests {
@@ -281,6 +440,31 @@ func TestErrorHandling(t *testing.T) {
 	}
 }
 
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedInspect string
+	}{
+		{"foobar", "ERROR: 1:1: identifier not found: foobar"},
+		{"let x = 1;\n  x + true", "ERROR: 2:3: type mismatch: INTEGER + BOOLEAN"},
+		{"let f = fn(x) {\n  x + y\n};\nf(1)", "ERROR: 2:7: identifier not found: y"},
+		{"let x = 0;\nlet x = 1;", "ERROR: 2:1: identifier already exists: x"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+		}
+
+		if errObj.Inspect() != tt.expectedInspect {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expectedInspect, errObj.Inspect())
+		}
+	}
+}
+
 func TestLetStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -330,6 +514,769 @@ func TestAssignExpression(t *testing.T) {
 	}
 }
 
+func TestCompoundAssignment(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let a = 5; a += 2; a", 7},
+		{"let a = 5; a -= 2", 3},
+		{"let a = 5; a *= 2 + 1; a", 15},
+		{"let a = 7; a /= 2; a", 3},
+		{"let a = 7; a %= 4; a", 3},
+		{"let a = 1.5; a += 1; a", 2.5},
+		{`let s = "ab"; s += "cd"; s`, "abcd"},
+		{"let a = 5; a++", 5},
+		{"let a = 5; a++; a", 6},
+		{"let a = 5; ++a", 6},
+		{"let a = 5; a--", 5},
+		{"let a = 5; --a; a", 4},
+		{"let a = 5; a++ + a", 11},
+		{"let a = 5; fn() { a += 1; a++ }(); a", 7},
+		{"let a = 5; if (true) { let a = 1; a += 10; } a", 5},
+		{"let f = fn() { let n = 0; fn() { n++ } }; let g = f(); g(); g(); g()", 2},
+		{"let a = [1, 2]; a[1] += 5; a", []interface{}{1, 7}},
+		{"let a = [1, 2]; a[0]++", 1},
+		{"let a = [1, 2]; ++a[0]; a", []interface{}{2, 2}},
+		{"let a = [1, 2]; let i = 0; a[i++] += 10; [a[0], i]", []interface{}{11, 1}},
+		{`let h = {"n": 1}; h["n"] *= 3; h["n"]++; h["n"]`, 4},
+		{"let t = 0; for (let i = 0; i < 5; i++) { t += i }; t", 10},
+		{"let a = 5; a /= 0", "division by zero"},
+		{`let a = 5; a += "x"`, "type mismatch: INTEGER + STRING"},
+		{`let s = "x"; s++`, "type mismatch: STRING + INTEGER"},
+		{"let a = [1]; a[1] += 1", "index outside array bounds: 1"},
+		{`let h = {}; h["n"] += 1`, "type mismatch: NULL + INTEGER"},
+		{"b += 1", "identifier not found: b"},
+		{"b++", "identifier not found: b"},
+		{"5 += 1", "Left side of assign expression must be a variable"},
+		{"--5", "operand of -- must be a variable"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		case []interface{}:
+			testArrayObject(t, evaluated, expected)
+		case string:
+			if err, ok := evaluated.(*object.Error); ok {
+				testErrorObject(t, err, expected)
+			} else {
+				testStringObject(t, evaluated, expected)
+			}
+		}
+	}
+}
+
+func TestDefaultsAndNamedArguments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let f = fn(a, b = 10) { a + b }; f(1)", 11},
+		{"let f = fn(a, b = 10) { a + b }; f(1, 2)", 3},
+		{"let f = fn(a, b = a * 2, c = a + b) { [a, b, c] }; f(1)", []interface{}{1, 2, 3}},
+		{"let f = fn(a = 1, b) { [a, b] }; f(b: 2)", []interface{}{1, 2}},
+		{"let f = fn(host, port = 80) { [host, port] }; f(port: 8080, host: \"x\")", []interface{}{"x", 8080}},
+		{"let f = fn(host, port = 80) { [host, port] }; f(\"x\", port: 1)", []interface{}{"x", 1}},
+		{"let f = fn(a, ...rest) { [a, rest] }; f(a: 1)", []interface{}{1, []interface{}{}}},
+		{"let f = fn([a, b] = [1, 2]) { a + b }; f()", 3},
+		{"let f = fn({x = 1} = {}) { x }; [f(), f({\"x\": 2})]", []interface{}{1, 2}},
+		{"let n = 0; let f = fn(x = n) { x }; n = 5; f()", 5},
+		{"let count = 0; let f = fn(x = count += 1) { x }; f(); f(9); f(); count", 2},
+		{"let mk = fn(n) { fn(x = n) { x } }; mk(7)()", 7},
+		{"let f = fn(a, b = 10) { a + b }; f()", "wrong number of arguments to `f`: expected=2, got=0"},
+		{"let f = fn(a, b = 10) { a + b }; f(1, 2, 3)", "wrong number of arguments to `f`: expected=2, got=3"},
+		{"let f = fn(a, b = 10) { a + b }; f(b: 1)", "missing argument: a"},
+		{"let f = fn(a, b = 10) { a + b }; f(1, c: 2)", "unknown parameter name: c"},
+		{"let f = fn(a, b = 10) { a + b }; f(1, a: 2)", "duplicate argument: a"},
+		{"let f = fn(a, b = 10) { a + b }; f(b: 1, b: 2)", "duplicate argument: b"},
+		{"let f = fn(a, ...rest) { a }; f(rest: 1)", "variodic parameter cannot be passed by name: rest"},
+		{"fn f(...xs) { xs }; f(xs: 1)", "variodic parameter cannot be passed by name: xs"},
+		{"fn f(...xs) { xs }; f(ys: 1)", "unknown parameter name: ys"},
+		{"let f = fn([a]) { a }; f(a: 1)", "unknown parameter name: a"},
+		{"let f = fn(a = b, b = 1) { a }; f()", "identifier not found: b"},
+		{"let f = fn(a = 1 + true) { a }; f()", "type mismatch: INTEGER + BOOLEAN"},
+		{"len(x: \"a\")", "builtin functions do not take named arguments"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case []interface{}:
+			testArrayObject(t, evaluated, expected)
+		case string:
+			testErrorObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestMatchExpressions(t *testing.T) {
+	describe := `let describe = fn(msg) {
+		match (msg) {
+			0 => "zero",
+			-1 => "minus one",
+			1.5 => "one and a half",
+			"hi" => "greeting",
+			true => "yes",
+			[] => "empty",
+			[x] => ["one", x],
+			[x, y] if x == y => "same",
+			[first, ...rest] => [first, len(rest)],
+			{type: "ping", id} => ["ping", id],
+			{type: "data", body: {size: int n}} if n > 10 => { let s = n * 2; ["big", s] }
+			{type: "data"} => "data",
+			int n if n < 0 => "negative",
+			int => "int",
+			string s => s + "!",
+			fn => "function",
+			_ => "other"
+		}
+	};
+	`
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"describe(0)", "zero"},
+		{"describe(-1)", "minus one"},
+		{"describe(1.5)", "one and a half"},
+		{`describe("hi")`, "greeting"},
+		{"describe(true)", "yes"},
+		{"describe(false)", "other"},
+		{"describe([])", "empty"},
+		{"describe([7])", []interface{}{"one", 7}},
+		{"describe([2, 2])", "same"},
+		{"describe([2, 3])", []interface{}{2, 1}},
+		{"describe([1, 2, 3])", []interface{}{1, 2}},
+		{`describe({"type": "ping", "id": 4})`, []interface{}{"ping", 4}},
+		{`describe({"type": "ping"})`, "other"},
+		{`describe({"type": "data", "body": {"size": 20}})`, []interface{}{"big", 40}},
+		{`describe({"type": "data", "body": {"size": 2}})`, "data"},
+		{`describe({"type": "data", "body": {"size": "x"}})`, "data"},
+		{"describe(-5)", "negative"},
+		{"describe(42)", "int"},
+		{"describe(1.0)", "other"},
+		{`describe("x")`, "x!"},
+		{"describe(fn() { 1 })", "function"},
+		{"describe(len)", "function"},
+		{"describe(range(1, 3))", "other"},
+		{"match (5) { n => n + 1 }", 6},
+		{"match (1) { 1.0 => 1, 1 => 2 }", 2},
+		{"match (if (false) { 1 }) { null => 1, _ => 2 }", 1},
+		{"let n = 1; match (2) { n => n }; n", 1},
+		{"let t = 0; for (i in range(1, 6)) { t += match (i % 3) { 0 => { continue }, 1 => i, _ => 10 * i } }; t", 75},
+		{"let f = fn(m) { match (m) { [x] => { return x } _ => 0 }; 9 }; [f([1]), f(2)]", []interface{}{1, 9}},
+		{"match ([1, 2]) { [array, count] => array + count }", 3},
+		{`match ({"size": "x"}) { {size: int} => int }`, "x"},
+		{`match ([1, "a"]) { [int n, int m] => n, [int n, string s] => s }`, "a"},
+		{"match (3) { 1 => 2 }", "non-exhaustive match: no arm matches 3"},
+		{"match ([1]) { [x] if x > 1 => x }", "non-exhaustive match: no arm matches [1]"},
+		{"match (1) { }", "non-exhaustive match: no arm matches 1"},
+		{"match ([1, 2]) { [a, a] => a }", "identifier already exists: a"},
+		{"match (1) { x if y => 1 }", "identifier not found: y"},
+		{"match (1) { x => 1 }; x", "identifier not found: x"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(describe + tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case []interface{}:
+			testArrayObject(t, evaluated, expected)
+		case string:
+			if err, ok := evaluated.(*object.Error); ok {
+				testErrorObject(t, err, expected)
+			} else {
+				testStringObject(t, evaluated, expected)
+			}
+		}
+	}
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"try { 1 } catch (e) { 2 }", 1},
+		{"try { 1 / 0 } catch (e) { 2 }", 2},
+		{"try { 1 / 0 } catch { 2 }", 2},
+		{"try { 1 / 0; 3 } catch (e) { e[\"message\"] }", "division by zero"},
+		{"let f = fn() { throw \"bad\" }; try { f() } catch (e) { e[\"message\"] }", "bad"},
+		{"let f = fn(n) { if (n == 0) { throw \"bottom\" } f(n - 1) }; try { f(20) } catch (e) { e[\"message\"] }", "bottom"},
+		{"try { try { throw \"a\" } catch (e) { throw e[\"message\"] + \"b\" } } catch (e) { e[\"message\"] }", "ab"},
+		{"let log = []; try { log = push(log, 1) } finally { log = push(log, 2) }; log", []interface{}{1, 2}},
+		{"let log = []; try { try { throw \"x\" } finally { log = push(log, 1) } } catch (e) { log = push(log, e[\"message\"]) }; log", []interface{}{1, "x"}},
+		{"let log = []; try { 1 } catch (e) { log = push(log, 1) } finally { log = push(log, 2) }; log", []interface{}{2}},
+		{"let x = try { 5 } finally { 6 }; x", 5},
+		{"let f = fn() { try { return 1 } finally { return 2 } }; f()", 2},
+		{"let f = fn() { try { throw \"x\" } finally { return 3 } }; f()", 3},
+		{"let log = []; let f = fn() { try { return 1 } finally { log = push(log, 2) } }; [f(), log]", []interface{}{1, []interface{}{2}}},
+		{"let log = []; for (x in [1, 2, 3]) { try { if (x == 2) { continue } log = push(log, x) } finally { log = push(log, 0) } }; log", []interface{}{1, 0, 0, 3, 0}},
+		{"let log = []; let r = for (x in [1, 2]) { try { break x * 10 } finally { log = push(log, x) } }; [r, log]", []interface{}{10, []interface{}{1}}},
+		{"let n = 0; while (n < 3) { try { let v = n; if (v == 1) { throw \"one\" } } catch (e) { let v = 0 }; n++ }; n", 3},
+		{"try { let x = 1 } catch (e) { 0 }; x", "identifier not found: x"},
+		{"try { 1 / 0 } catch (e) { e + 1 }", "type mismatch: ERROR_VALUE + INTEGER"},
+		{"try { 1 / 0 } finally { 2 }", "division by zero"},
+		{"try { 1 } finally { throw \"late\" }", "late"},
+		{"try { 1 / 0 } catch (e) { throw e }", "division by zero"},
+		{"throw 1", "cannot throw INTEGER"},
+		{"try { throw [1] } catch (e) { e[\"message\"] }", "cannot throw ARRAY"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		if expected, ok := tt.expected.(string); ok {
+			if err, ok := evaluated.(*object.Error); ok {
+				testErrorObject(t, err, expected)
+				continue
+			}
+		}
+
+		testObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestErrorValues(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let e = error("bad"); [e["message"], e["kind"], e["line"], e["column"]]`, []interface{}{"bad", "Error", nil, nil}},
+		{`let e = error("bad", "ConfigError"); e["kind"]`, "ConfigError"},
+		{`try { throw error("bad", "ConfigError") } catch (e) { [e["kind"], e["line"], e["column"]] }`, []interface{}{"ConfigError", 1, 7}},
+		{"try {\n  [1][\"a\"]\n} catch (e) { [e[\"kind\"], e[\"line\"], e[\"column\"]] }", []interface{}{"RuntimeError", 2, 3}},
+		{`try { throw "s" } catch (e) { e["kind"] }`, "Error"},
+		{`try { throw "s" } catch (e) { e["other"] }`, nil},
+		{`match (error("x")) { error e => e["message"], _ => "no" }`, "x"},
+		{`match (try { 1 / 0 } catch (e) { e }) { error => 1, _ => 2 }`, 1},
+		{`error(1)`, "argument to `error` not supported: INTEGER"},
+		{`error("a", "b", "c")`, "wrong number of arguments: expected=2, got=3"},
+		{`error("a")[0]`, "unusable as error field: INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		if expected, ok := tt.expected.(string); ok {
+			if err, ok := evaluated.(*object.Error); ok {
+				testErrorObject(t, err, expected)
+				continue
+			}
+		}
+
+		testObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestThrownErrorPositions(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedInspect string
+	}{
+		{`throw "bad"`, "ERROR: 1:1: Error: bad"},
+		{"let f = fn() {\n  throw error(\"bad\", \"Custom\")\n};\nf()", "ERROR: 2:3: Custom: bad"},
+		{"let e = try {\n  1 / 0\n} catch (e) { e };\nthrow e", "ERROR: 2:3: division by zero"},
+		{"try { 1 } finally {\n  1 / 0 }", "ERROR: 2:3: division by zero"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+		}
+
+		if errObj.Inspect() != tt.expectedInspect {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expectedInspect, errObj.Inspect())
+		}
+	}
+}
+
+func TestErrorStacks(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 / 0", "<main> (1:1)"},
+		{"let f = fn(x) {\n  x / 0\n};\nf(1)", "f (2:3), <main> (4:1)"},
+		{"let f = fn() { 1 / 0 };\nlet g = fn() {\n  f() + 1\n};\ng()", "f (1:16), g (3:3), <main> (5:1)"},
+		{"let f = fn() {\n  len(1)\n};\nf()", "len, f (2:3), <main> (4:1)"},
+		{"let f = fn(x) { x };\nlet g = fn() {\n  f()\n};\ng()", "g (3:3), <main> (5:1)"},
+		{"let f = fn(x = 1 / 0) { x };\nf()", "f (1:16), <main> (2:1)"},
+		{"let f = fn() { throw \"bad\" };\nlet e = try { f() } catch (e) { e };\nthrow e", "f (1:16), <main> (2:15)"},
+		{"let g = fn() { throw \"bad\" };\nlet f = fn() {\n  try { g() } catch (e) { throw e }\n};\nf()", "g (1:16), f (3:9), <main> (5:1)"},
+		{"let f = fn(g) {\n  [g()]\n};\nf(fn() { 1 / 0 })", "<anonymous> (4:10), f (2:4), <main> (4:1)"},
+		{"let f = fn() { 1 / 0 };\nlet g = fn() {\n  f()\n};\ng()", "f (1:16), <main> (5:1)"},
+		{"let f = fn(n) {\n  if (n == 0) { return 1 / 0 }\n  return f(n - 1)\n};\nf(3)", "f (2:24), <main> (5:1)"},
+		{"let f = fn(x) { x };\nlet g = fn() {\n  f(1, 2)\n};\ng()", "g (3:3), <main> (5:1)"},
+		{"fn f() {\n  1 / 0\n}\nlet g = f;\ng()", "f (2:3), <main> (5:1)"},
+		{"let make = fn() {\n  fn() { 1 / 0 }\n};\nlet g = make();\ng()", "g (2:10), <main> (5:1)"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+		}
+
+		frames := make([]string, len(errObj.Stack))
+		for i, frame := range errObj.Stack {
+			frames[i] = frame.String()
+		}
+
+		if got := strings.Join(frames, ", "); got != tt.expected {
+			t.Errorf("wrong stack for %q. expected=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestSpread(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let a = [1, 2]; let b = [3]; [...a, ...b]", []interface{}{1, 2, 3}},
+		{"let a = [1, 2]; [0, ...a, 9, ...a]", []interface{}{0, 1, 2, 9, 1, 2}},
+		{"[...[]]", []interface{}{}},
+		{`[..."ab", ...range(1, 3)]`, []interface{}{"a", "b", 1, 2}},
+		{`[...{"y": 1, "x": 2}]`, []interface{}{"x", "y"}},
+		{"let a = [1]; let b = [...a]; b[0] = 2; a", []interface{}{1}},
+		{"let sum = fn(...xs) { let t = 0; for (x in xs) { t += x }; t }; sum(...[1, 2], 3, ...[4])", 10},
+		{"let f = fn(x, y, z = 0) { [x, y, z] }; f(...[1, 2])", []interface{}{1, 2, 0}},
+		{"let f = fn(x, y, z = 0) { [x, y, z] }; f(...[1], 2, z: 5)", []interface{}{1, 2, 5}},
+		{`len(...["abc"])`, 3},
+		{`let d = {"port": 80}; {...d, "port": 8080}["port"]`, 8080},
+		{`let d = {"port": 80}; {"port": 8080, ...d}["port"]`, 80},
+		{`let h = {...{"a": 1}, ...{"b": 2}, "a": 3}; [h["a"], h["b"]]`, []interface{}{3, 2}},
+		{`{"a": 1, "a": 2}["a"]`, 2},
+		{"[...1]", "cannot spread INTEGER"},
+		{"{...[1]}", "cannot spread ARRAY into a hash"},
+		{"let f = fn(x) { x }; f(...true)", "cannot spread BOOLEAN"},
+		{"let f = fn(x) { x }; f(...[1, 2])", "wrong number of arguments to `f`: expected=1, got=2"},
+		{"[...x]", "identifier not found: x"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case []interface{}:
+			testArrayObject(t, evaluated, expected)
+		case string:
+			testErrorObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestFunctionDeclarations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"fn add(x, y) { x + y }; add(1, 2)", 3},
+		{"let r = add(1, 2); fn add(x, y) { x + y }; r", 3},
+		{"fn fact(n) { if (n < 2) { 1 } else { n * fact(n - 1) } } fact(5)", 120},
+		{
+			"fn even(n) { if (n == 0) { true } else { odd(n - 1) } } fn odd(n) { if (n == 0) { false } else { even(n - 1) } } [even(10), odd(7), even(3)]",
+			[]interface{}{true, true, false},
+		},
+		{"let f = fn() { g() }; fn g() { 5 }; f()", 5},
+		{"fn outer() { let r = inner(); fn inner() { 7 }; r } outer()", 7},
+		{"let x = 1; fn f() { x }; let g = fn() { let x = 2; f() }; g()", 1},
+		{"fn f() { 1 }; let r = if (true) { fn f() { 2 }; f() }; [r, f()]", []interface{}{2, 1}},
+		{"let fs = []; for (i in [1, 2]) { fn f() { i * 10 } fs = push(fs, f) }; [fs[0](), fs[1]()]", []interface{}{10, 20}},
+		{"if (true) { fn f() { 1 } }; f()", "identifier not found: f"},
+		{"fn f() { 1 }; fn f() { 2 }", "identifier already exists: f"},
+		{"fn f() { 1 }; let f = 2", "identifier already exists: f"},
+		{"fn f(x) { x }; f(1, 2)", "wrong number of arguments to `f`: expected=1, got=2"},
+		{"fn connect(host, port) { [host, port] }; connect(\"localhost\")", "wrong number of arguments to `connect`: expected=2, got=1"},
+		{"fn f(x, ...xs) { x }; f()", "wrong number of arguments to `f`: expected=2, got=0"},
+		{"fn f(x) { x }; f(y: 1)", "unknown parameter name: y"},
+		{"let make = fn() { fn(x) { x } }; let g = make(); g()", "wrong number of arguments to `g`: expected=1, got=0"},
+		{"let make = fn() { fn(x) { x } }; let g = make(); let h = g; h()", "wrong number of arguments to `g`: expected=1, got=0"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case []interface{}:
+			testArrayObject(t, evaluated, expected)
+		case string:
+			testErrorObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"fn count(n) { if (n == 0) { 0 } else { count(n - 1) } } count(1000)", 0},
+		{"fn sum(n, acc) { if (n == 0) { return acc } return sum(n - 1, acc + n) } sum(1000, 0)", 500500},
+		{"fn even(n) { if (n == 0) { true } else { odd(n - 1) } } fn odd(n) { if (n == 0) { false } else { even(n - 1) } } even(1001)", false},
+		{"fn f(n) { match (n) { 0 => \"done\", _ => f(n - 1) } } f(1000)", "done"},
+		{"fn f(n) { for (x in [1, 2]) { if (n > 0) { return f(n - 1) } } \"done\" } f(1000)", "done"},
+		{"fn f(n, step = 1) { if (n <= 0) { n } else { f(n - step, step: 2) } } f(1002)", -1},
+		{"fn f(n) { if (n == 0) { len(\"abc\") } else { f(n - 1) } } f(1000)", 3},
+		{"fn f(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } } f(99)", 99},
+		{"fn f(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } } f(100)", "maximum recursion depth exceeded"},
+		{"fn f(n) { try { if (n == 0) { 0 } else { f(n - 1) } } catch (e) { e[\"message\"] } } f(1000)", "maximum recursion depth exceeded"},
+		{"fn f(n) { for (;;) { return g(n) } } fn g(n) { n * 2 } f(4)", nil},
+		{"fn f(n) { if (n == 0) { g(1) } else { f(n - 1) } } fn g() { 0 } f(1000)", "wrong number of arguments to `g`: expected=0, got=1"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := EvalContext(context.Background(), program, object.NewEnvironment(), Limits{MaxCallDepth: 100})
+
+		switch expected := tt.expected.(type) {
+		case string:
+			if errObj, ok := evaluated.(*object.Error); ok {
+				testErrorObject(t, errObj, expected)
+			} else {
+				testStringObject(t, evaluated, expected)
+			}
+		default:
+			testObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestEvalContextLimits(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	expired, cancelExpired := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelExpired()
+
+	tests := []struct {
+		input    string
+		ctx      context.Context
+		limits   Limits
+		expected interface{}
+	}{
+		{"1 + 2", context.Background(), Limits{MaxSteps: 100, MaxDuration: time.Second, MaxCallDepth: 10}, 3},
+		{"for (;;) {}", context.Background(), Limits{MaxSteps: 1000}, ErrStepLimit},
+		{"for (;;) {}", context.Background(), Limits{MaxDuration: 10 * time.Millisecond}, ErrTimeLimit},
+		{"for (;;) {}", expired, Limits{}, context.DeadlineExceeded},
+		{"exit(1)", cancelled, Limits{}, context.Canceled},
+		{"fn f(n) { 1 + f(n) } f(0)", context.Background(), Limits{MaxCallDepth: 10}, ErrCallDepthLimit},
+		{"fn f(n) { 1 + f(n) } try { f(0) } catch (e) { throw e }", context.Background(), Limits{MaxCallDepth: 10}, ErrCallDepthLimit},
+		{"throw \"maximum recursion depth exceeded\"", context.Background(), Limits{}, "maximum recursion depth exceeded"},
+		{"fn f(n) { if (n == 0) { 0 } else { f(n - 1) } } f(1000)", context.Background(), Limits{MaxCallDepth: 10}, 0},
+		{"try { for (;;) {} } catch (e) { 1 }", context.Background(), Limits{MaxSteps: 1000}, ErrStepLimit},
+		{"try { for (;;) {} } finally { exit(1) }", context.Background(), Limits{MaxSteps: 1000}, ErrStepLimit},
+		{"[...range(0, 100000)]", context.Background(), Limits{MaxSteps: 1000}, ErrStepLimit},
+		{"let a = [...range(0, 10000)]; push(a, 1); 1", context.Background(), Limits{MaxSteps: 15000}, ErrStepLimit},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := EvalContext(tt.ctx, program, object.NewEnvironment(), tt.limits)
+
+		switch expected := tt.expected.(type) {
+		case error:
+			// Running too deep raises an error the program could have caught
+			if errObj, ok := evaluated.(*object.Error); ok {
+				if !errors.Is(errObj.Cause, expected) {
+					t.Errorf("wrong error cause for %q. expected=%q, got=%v", tt.input, expected, errObj.Cause)
+				}
+				continue
+			}
+
+			halt, ok := evaluated.(*object.Halt)
+			if !ok {
+				t.Errorf("object is not Halt for %q. got=%T (%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+
+			if !errors.Is(halt.Err, expected) {
+				t.Errorf("wrong halt error for %q. expected=%q, got=%q", tt.input, expected, halt.Err)
+			}
+
+			if !halt.Span.IsValid() {
+				t.Errorf("halt has no position for %q", tt.input)
+			}
+		case string:
+			if testErrorObject(t, evaluated, expected) && evaluated.(*object.Error).Cause != nil {
+				t.Errorf("thrown error has a cause for %q", tt.input)
+			}
+		default:
+			testObject(t, evaluated, expected)
+		}
+	}
+}
+
+// A spread too long to finish is stopped part way through, not after it
+func TestCancelSpread(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	program := parser.New(lexer.New("[...range(0, 1000000000)]")).ParseProgram()
+
+	start := time.Now()
+	evaluated := EvalContext(ctx, program, object.NewEnvironment(), Limits{})
+
+	halt, ok := evaluated.(*object.Halt)
+	if !ok {
+		t.Fatalf("object is not Halt. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	if !errors.Is(halt.Err, context.DeadlineExceeded) {
+		t.Errorf("wrong halt error. expected=%q, got=%q", context.DeadlineExceeded, halt.Err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("spread took %s to stop", elapsed)
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	args := strings.Repeat("1, ", 200) + "1"
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let s = \"\"; for (;;) { s = s + \"x\" }", ErrMemoryLimit},
+		{"let s = \"\"; for (;;) { s += \"x\" }", ErrMemoryLimit},
+		{"let a = []; for (;;) { a = push(a, 1) }", ErrMemoryLimit},
+		{"let a = [1]; for (;;) { a = [...a, ...a] }", ErrMemoryLimit},
+		{"[...range(0, 20000000)]", ErrMemoryLimit},
+		{"fn f(...xs) { len(xs) } f(...range(0, 20000000))", ErrMemoryLimit},
+		{"let h = {}; let i = 0; for (;;) { h[i] = i; i++ }", ErrMemoryLimit},
+		{"fn f(n) { f(n + 1) } f(0)", ErrMemoryLimit},
+		{"fn f(...xs) { 0 }; for (i in range(0, 30)) { f(" + args + ") }; 1", ErrMemoryLimit},
+		{"let a = [...range(0, 1000)]; for (i in range(0, 10)) { let [x, ...r] = a }; 1", ErrMemoryLimit},
+		{"let a = [...range(0, 1000)]; fn f([x, ...r]) { 0 }; for (i in range(0, 10)) { f(a) }; 1", ErrMemoryLimit},
+		{"let a = [...range(0, 1000)]; for (i in range(0, 10)) { match (a) { [x, ...r] => 0 } }; 1", ErrMemoryLimit},
+		{"let a = [...range(0, 1000)]; fn f(...xs) { 0 }; for (i in range(0, 10)) { f(...a) }; 1", ErrMemoryLimit},
+		{"try { let a = []; for (;;) { a = push(a, 1) } } catch (e) { 1 }", ErrMemoryLimit},
+		{"let a = []; for (let i = 0; i < 10; i++) { a = push(a, i) }; len(a)", 10},
+		{"let s = \"x\"; let a = [s, s]; first(a) == s", true},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := EvalContext(context.Background(), program, object.NewEnvironment(), Limits{MaxMemory: 1 << 16})
+
+		switch expected := tt.expected.(type) {
+		case error:
+			halt, ok := evaluated.(*object.Halt)
+			if !ok {
+				t.Errorf("object is not Halt for %q. got=%T (%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+
+			if !errors.Is(halt.Err, expected) {
+				t.Errorf("wrong halt error for %q. expected=%q, got=%q", tt.input, expected, halt.Err)
+			}
+		default:
+			testObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestBudgetStats(t *testing.T) {
+	tests := []struct {
+		input    string
+		limits   Limits
+		expected Stats
+		halted   bool
+	}{
+		{`"abc"`, Limits{}, Stats{Steps: 3, Allocated: 35}, false},
+		{"[1, 2]", Limits{}, Stats{Steps: 5, Allocated: 64}, false},
+		{`fn f() { len("ab") } f()`, Limits{}, Stats{Steps: 11, Allocated: 130, MaxCallDepth: 2}, false},
+		{"[1, 2]", Limits{MaxMemory: 63}, Stats{Steps: 5, Allocated: 64}, true},
+		{"fn f(...xs) { 0 } f(1, 2)", Limits{}, Stats{Steps: 11, Allocated: 208, MaxCallDepth: 1}, false},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		budget := NewBudget(context.Background(), tt.limits)
+
+		evaluated := EvalBudget(program, object.NewEnvironment(), budget)
+
+		if _, ok := evaluated.(*object.Halt); ok != tt.halted {
+			t.Errorf("wrong result for %q. got=%T (%+v)", tt.input, evaluated, evaluated)
+		}
+
+		if budget.Stats() != tt.expected {
+			t.Errorf("wrong stats for %q. expected=%+v, got=%+v", tt.input, tt.expected, budget.Stats())
+		}
+	}
+}
+
+func TestFunctionInspect(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn add(x, y) { x + y }; add", "fn add(x, y)"},
+		{"let add = fn(x, ...ys) { x }; add", "fn add(x, ...ys)"},
+		{"let f = 0; f = fn(x = 1) { x }; f", "fn f(x = 1)"},
+		{"let f = fn() { 1 }; let g = f; g", "fn f()"},
+		{"let make = fn() { fn(x) { x } }; let g = make(); g", "fn g(x)"},
+		{"let g = if (true) { fn(x) { x } }; g", "fn g(x)"},
+		{"fn(x) { x }", "fn(x) {\n{ x; }\n}"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong Inspect for %q. expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestDestructuring(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let [a, b] = [1, 2]; a * 10 + b", 12},
+		{"let [a, [b, c]] = [1, [2, 3]]; [a, b, c]", []interface{}{1, 2, 3}},
+		{"let [a, ...rest] = [1, 2, 3]; rest", []interface{}{2, 3}},
+		{"let [a, ...rest] = [1]; rest", []interface{}{}},
+		{"let [...all] = []; len(all)", 0},
+		{"let [a, b = a + 1, c = 10] = [1]; [a, b, c]", []interface{}{1, 2, 10}},
+		{"let [a = 5] = [1]; a", 1},
+		{`let {name, port: p} = {"name": "db", "port": 5432}; p`, 5432},
+		{`let {name, port: p} = {"name": "db", "port": 5432}; name`, "db"},
+		{`let {port = 80, tls: t = false} = {}; [port, t]`, []interface{}{80, false}},
+		{`let {db: {ports: [first, ...more]}} = {"db": {"ports": [1, 2, 3]}}; [first, more]`, []interface{}{1, []interface{}{2, 3}}},
+		{`let {a} = {"a": 1, "b": 2}; a`, 1},
+		{"let f = fn([a, b]) { a + b }; f([3, 4])", 7},
+		{"let f = fn(n, {scale = n}) { n * scale }; [f(2, {}), f(2, {\"scale\": 5})]", []interface{}{4, 10}},
+		{"let f = fn({x = y}, y) { x }; f({}, 3)", 3},
+		{"let divmod = fn(a, b) { [a / b, a % b] }; let [q, r] = divmod(17, 5); [q, r]", []interface{}{3, 2}},
+		{"let t = 0; for ([k, v] in [[1, 2], [3, 4]]) { t += k * v }; t", 14},
+		{`let t = 0; for (i, {n} in [{"n": 5}, {"n": 6}]) { t += i * n }; t`, 6},
+		{"let a = 1; let b = 2; [a, b] = [b, a]; [a, b]", []interface{}{2, 1}},
+		{"let a = 1; let b = 2; let c = [a, b] = [3, 4]; [a, b, c]", []interface{}{3, 4, []interface{}{3, 4}}},
+		{`let n = 0; {n} = {"n": 3}; n`, 3},
+		{"let a = 0; fn() { [a] = [5] }(); a", 5},
+		{"let [a, b] = [1]", "not enough values to unpack: expected 2, got 1"},
+		{"let [a, b = 2] = []", "not enough values to unpack: expected 1, got 0"},
+		{"let [a] = [1, 2]", "too many values to unpack: expected 1, got 2"},
+		{"let [a, [b]] = [1, 2]", "cannot destructure INTEGER as an array"},
+		{`let [a] = {"a": 1}`, "cannot destructure HASH as an array"},
+		{"let {a} = [1]", "cannot destructure ARRAY as a hash"},
+		{`let {a, b} = {"a": 1}`, "missing hash key: b"},
+		{"let [a, a] = [1, 2]", "identifier already exists: a"},
+		{"let [a = b] = []", "identifier not found: b"},
+		{"let [a] = if (true) { }", "cannot assign empty value to variable"},
+		{"[a] = [1]", "identifier not found: a"},
+		{"let f = fn([a]) { a }; f(1)", "cannot destructure INTEGER as an array"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case []interface{}:
+			testArrayObject(t, evaluated, expected)
+		case string:
+			if err, ok := evaluated.(*object.Error); ok {
+				testErrorObject(t, err, expected)
+			} else {
+				testStringObject(t, evaluated, expected)
+			}
+		}
+	}
+}
+
+func TestDestructuringErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let [a, b] = [1]", "1:5: not enough values to unpack: expected 2, got 1"},
+		{"let [a, {b}] = [1, 2]", "1:9: cannot destructure INTEGER as a hash"},
+		{"let x = 1;\nlet f = fn(y, [a]) { a };\nf(1, 2)", "2:15: cannot destructure INTEGER as an array"},
+		{"let [a = 1 + true] = []", "1:10: type mismatch: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		err, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error returned for %q. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+
+		if err.Inspect() != "ERROR: "+tt.expected {
+			t.Errorf("wrong error for %q. expected=%q, got=%q", tt.input, "ERROR: "+tt.expected, err.Inspect())
+		}
+	}
+}
+
+func TestIndexAssignment(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let a = [1, 2, 3]; a[1] = 5; a", []interface{}{1, 5, 3}},
+		{"let a = [1, 2, 3]; a[0] = a[2] + 1", 4},
+		{"let a = [1, 2]; let b = a; b[0] = 9; a", []interface{}{9, 2}},
+		{"let a = [1, 2]; let f = fn(xs) { xs[1] = 7 }; f(a); a", []interface{}{1, 7}},
+		{"let a = [[1, 2], [3, 4]]; a[1][0] = 0; a", []interface{}{[]interface{}{1, 2}, []interface{}{0, 4}}},
+		{"let a = [1, 2]; let b = push(a, 3); b[0] = 9; a", []interface{}{1, 2}},
+		{`let h = {"a": 1}; h["a"] = 2; h["a"]`, 2},
+		{`let h = {}; h["b"] = 3; h[true] = 4; h[1] = 5; h["b"] + h[true] + h[1]`, 12},
+		{`let cfg = {"db": {"port": 1}}; let db = cfg["db"]; cfg["db"]["port"] = 5432; db["port"]`, 5432},
+		{`let rows = {}; for (w in ["a", "b", "a"]) { if (rows[w]) { rows[w] = rows[w] + 1 } else { rows[w] = 1 } }; rows["a"] * 10 + rows["b"]`, 21},
+		{"let a = [1, 2, 3]; a[3] = 4", "index outside array bounds: 3"},
+		{"let a = [1, 2, 3]; a[-1] = 4", "array index must be non-negative: -1"},
+		{`let a = [1]; a["0"] = 4`, "array does not support indexing from type: STRING"},
+		{`let h = {}; h[[1]] = 4`, "unusable as hash key: ARRAY"},
+		{`let s = "abc"; s[0] = "x"`, "type does not support index assignment: STRING"},
+		{"let a = [1]; a[0] = if (true) { }", "cannot assign empty value to index"},
+		{"let a = [1]; a[0] = b", "identifier not found: b"},
+		{"b[0] = 1", "identifier not found: b"},
+		{"let a = [1]; a[0] = a; match (a) { 5 => 1 }", "non-exhaustive match: no arm matches [[...]]"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case []interface{}:
+			testArrayObject(t, evaluated, expected)
+		case string:
+			testErrorObject(t, evaluated, expected)
+		}
+	}
+}
+
 func TestFunctionObject(t *testing.T) {
 	input := "fn(x) { x + 2; };"
 	evaluated := testEval(input)
@@ -471,11 +1418,168 @@ func TestForLoopStatement(t *testing.T) {
 		integer, ok := tt.expected.(int)
 		if ok {
 			testIntegerObject(t, evaluated, int64(integer))
-		} else if evaluated != nil {
-			t.Fatalf("Expected nil but was %t (%+v)", evaluated, evaluated)
+		} else {
+			testNullObject(t, evaluated)
 		}
 	}
 }
+
+func TestBreakAndContinue(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let i = 0; for (; i < 10; i = i + 1) { if (i == 3) { break; } }; i", 3},
+		{"let i = 0; for (; i < 10; i = i + 1) break; i", 0},
+		{"let x = 0; for (let i = 0; i < 10; i = i + 1) { if (i % 2 == 0) { continue } x = x + i }; x", 25},
+		{"let x = 0; for (let i = 0; i < 5; i = i + 1) { if (i > 0) { if (i < 4) { continue } } x = x + 1 }; x", 2},
+		{"let n = 0; for (;;) { n = n + 1; if (n == 7) { break } }; n", 7},
+		{"let x = 0; for (let i = 0; i < 3; i = i + 1) { for (let j = 0; j < 3; j = j + 1) { if (j == 1) { break } x = x + 1 } }; x", 3},
+		{`
+			let found = 0;
+			outer: for (let i = 1; i < 10; i = i + 1) {
+				for (let j = 1; j < 10; j = j + 1) {
+					if (i * j == 42) {
+						found = i * 10 + j;
+						break outer;
+					}
+				}
+			}
+			found`, 67},
+		{`
+			let x = 0;
+			rows: for (let i = 0; i < 3; i = i + 1) {
+				for (let j = 0; j < 3; j = j + 1) {
+					if (j > i) { continue rows }
+					x = x + 1
+				}
+			}
+			x`, 6},
+		{"let f = fn() { for (;;) { break } 5 }; f()", 5},
+		{"let x = 0; for (let i = 0; i < 3; i = i + 1) { x = x + fn() { for (;;) { break } 1 }() }; x", 3},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestForInLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let t = 0; for (x in [1, 2, 3]) { t = t + x }; t", 6},
+		{"let t = 0; for (i, x in [5, 6, 7]) { t = t + i * x }; t", 20},
+		{"let t = 0; for (x in []) { t = t + 1 }; t", 0},
+		{`let t = 0; for (k in {"a": 1, "b": 2}) { t = t + len(k) }; t`, 2},
+		{`let t = 0; for (k, v in {1: 10, 2: 20}) { t = t + k * v }; t`, 50},
+		{`let n = 0; for (ch in "héllo") { if (ch == "é") { n = n + 1 } }; n`, 1},
+		{`let n = 0; for (i, ch in "héllo") { if (ch == "l") { n = n + i } }; n`, 5},
+		{"let t = 0; for (i in range(5)) { t = t + i }; t", 10},
+		{"let t = 0; for (i in range(2, 5)) { t = t + i }; t", 9},
+		{"let t = 0; for (i in range(10, 0, -3)) { t = t + i }; t", 22},
+		{"let t = 0; for (i, x in range(10, 13)) { t = t + i }; t", 3},
+		{"let t = 0; for (x in range(5, 5)) { t = t + 1 }; t", 0},
+		{"let t = 0; for (x in [1, 2, 3, 4]) { if (x == 2) { continue } if (x == 4) { break } t = t + x }; t", 4},
+		{`
+			let found = 0;
+			outer: for (i in range(1, 10)) {
+				for (j in range(1, 10)) {
+					if (i * j == 42) {
+						found = i * 10 + j;
+						break outer;
+					}
+				}
+			}
+			found`, 67},
+		{"let fs = []; for (x in [1, 2, 3]) { fs = push(fs, fn() { x }) }; fs[0]() * 100 + fs[1]() * 10 + fs[2]()", 123},
+		{"let fs = []; for (x in [1, 2]) { let y = x * 2; fs = push(fs, fn() { y }) }; fs[0]() + fs[1]()", 6},
+		{"let f = fn() { for (x in [1, 2, 3]) { if (x == 2) { return x * 10 } } 0 }; f()", 20},
+		{"let a = [1, 2]; let t = 0; for (x in a) { a = push(a, x); t = t + x }; t * 10 + len(a)", 34},
+		{"let x = 7; for (x in [1]) { }; x", 7},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestWhileLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let i = 0; while (i < 10) { i = i + 1 }; i", 10},
+		{"let i = 0; while (i > 0) { i = i + 1 }; i", 0},
+		{"let i = 5; do { i = i + 1 } while (i < 3); i", 6},
+		{"let i = 0; do { i = i + 1 } while (i < 3); i", 3},
+		{"let i = 0; let n = 0; while (i < 10) { i = i + 1; if (i % 3 != 0) { continue } n = n + i }; n", 18},
+		{"let i = 0; do { i = i + 1; if (i < 5) { continue } break } while (true); i", 5},
+		{"let i = 0; outer: while (true) { do { i = i + 1; if (i == 4) { break outer } } while (true) }; i", 4},
+		{"let fs = []; let i = 0; while (i < 3) { let j = i; fs = push(fs, fn() { j }); i = i + 1 }; fs[0]() + fs[2]()", 2},
+		{"let f = fn() { let i = 0; while (true) { i = i + 1; if (i == 3) { return i * 10 } } }; f()", 30},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestLoopValues(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let x = for (let i = 0; i < 10; i = i + 1) { if (i * i > 20) { break (i) } }; x", 5},
+		{"let x = for (let i = 0; i < 3; i = i + 1) { }; x", nil},
+		{"let x = for (n in [3, 8, 5]) { if (n > 4) { break n * 10 } }; x", 80},
+		{"let x = for (n in [1, 2]) { if (n > 4) { break (n) } }; x", nil},
+		{"for (n in [1, 2]) { break }", nil},
+		{"let i = 0; let x = while (true) { i = i + 1; if (i == 3) { break i + 1 } }; x", 4},
+		{"let x = do { break 7 } while (true); x", 7},
+		{"1 + for (x in range(10)) { if (x > 5) { break (x) } }", 7},
+		{"let found = for (i in range(5)) { let r = for (j in range(5)) { if (i * j == 6) { break i * 10 + j } }; if (r) { break (r) } }; found", 23},
+		{"let x = for (i in range(3)) { for (j in range(3)) { if (j == 1) { break (j) } } }; x", nil},
+		{"let f = fn(xs) { for (x in xs) { if (x < 0) { break (x) } } }; f([1, -2, 3])", -2},
+		{"let f = fn(xs) { for (x in xs) { if (x < 0) { break x } } }; f([1, -2, 3])", -2},
+		{"let f = fn() { outer: for (i in range(5)) { for (j in range(5)) { if (i * j == 6) { break outer i } } } }; f()", 2},
+		{"let x = for (;;) { return 5 }; x", nil},
+		{"outer: for (i in range(5)) { for (j in range(5)) { if (i * j == 6) { break outer i * 10 + j } } }", 23},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestBreakOutsideLoop(t *testing.T) {
+	// The parser rejects these, build them by hand to check the evaluator
+	tests := []struct {
+		statement       ast.Statement
+		expectedMessage string
+	}{
+		{&ast.BreakStatement{Token: token.Token{Type: token.BREAK, Literal: "break"}}, "break outside loop"},
+		{&ast.ContinueStatement{Token: token.Token{Type: token.CONTINUE, Literal: "continue"}}, "continue outside loop"},
+	}
+
+	for _, tt := range tests {
+		program := &ast.Program{Statements: []ast.Statement{tt.statement}}
+
+		evaluated := Eval(program, object.NewEnvironment())
+		testErrorObject(t, evaluated, tt.expectedMessage)
+	}
+}
 func TestStringLiteral(t *testing.T) {
 	input := `"Hello World!"`
 
@@ -491,6 +1595,35 @@ func TestStringLiteral(t *testing.T) {
 	}
 }
 
+func TestUnicodeStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"héllo"[1]`, "é"},
+		{`"日本語"[2]`, "語"},
+		{`"\u{1F600}!"[0]`, "\U0001F600"},
+		{`slice("héllo", 1, 3)`, "él"},
+		{`slice("héllo", 2)`, "llo"},
+		{`slice("héllo", 5)`, ""},
+		{`"caf\xE9"`, "café"},
+		{`let café = "crème"; café`, "crème"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		str, ok := evaluated.(*object.String)
+		if !ok {
+			t.Fatalf("object is not String for %q. got=%T (%+v)", tt.input, evaluated, evaluated)
+		}
+
+		if str.Value != tt.expected {
+			t.Errorf("String has wrong value for %q. expected=%q, got=%q", tt.input, tt.expected, str.Value)
+		}
+	}
+}
+
 func TestStringConcatenation(t *testing.T) {
 	input := `"Hello" + " " + "World!"`
 
@@ -514,6 +1647,8 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
+		{`len("héllo")`, 5},
+		{`len("\u{1F600}")`, 1},
 		{`len([])`, 0},
 		{`len([1])`, 1},
 		{`len([1, 2])`, 2},
@@ -555,6 +1690,51 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`pop([])`, "array has no elements"},
 		{`pop([1])`, []interface{}{}},
 		{`pop([1, 2])`, []interface{}{1}},
+		{`slice([1, 2, 3], 1)`, []interface{}{2, 3}},
+		{`slice([1, 2, 3], 0, 2)`, []interface{}{1, 2}},
+		{`slice([1, 2, 3], 1, 1)`, []interface{}{}},
+		{`slice([1, 2, 3], 2, 4)`, "slice bounds out of range: [2:4] with length 3"},
+		{`slice("abc", 2, 1)`, "slice bounds out of range: [2:1] with length 3"},
+		{`slice([1], "0")`, "argument to `slice` not supported: STRING"},
+		{`slice(1, 0)`, "argument to `slice` not supported: INTEGER"},
+		{`slice([1])`, "wrong number of arguments: expected=2, got=1"},
+		{`slice([1], 0, 1, 2)`, "wrong number of arguments: expected=3, got=4"},
+		{`len(range(10))`, 10},
+		{`len(range(3, 10, 3))`, 3},
+		{`len(range(10, 3, -2))`, 4},
+		{`len(range(10, 3))`, 0},
+		{`range(1, 2, 0)`, "range step must not be zero"},
+		{`len(range(-9223372036854775807, 9223372036854775807))`, "range too long: range(-9223372036854775807, 9223372036854775807, 1)"},
+		{`len(range(0, 9223372036854775807))`, 9223372036854775807},
+		{`range(1.5)`, "argument to `range` not supported: FLOAT"},
+		{`range()`, "wrong number of arguments: expected=1, got=0"},
+		{`range(1, 2, 3, 4)`, "wrong number of arguments: expected=3, got=4"},
+
+		{`int(3)`, 3},
+		{`int(3.9)`, 3},
+		{`int(-3.9)`, -3},
+		{`int("42")`, 42},
+		{`int("0x1f")`, 31},
+		{`int("1_000")`, 1000},
+		{`int("4.2")`, "could not parse \"4.2\" as integer"},
+		{`int(1e300)`, "cannot convert 1e+300 to integer"},
+		{`int(true)`, "argument to `int` not supported: BOOLEAN"},
+		{`float(2)`, 2.0},
+		{`float("2.5")`, 2.5},
+		{`float("abc")`, "could not parse \"abc\" as float"},
+		{`round(2.5)`, 3.0},
+		{`round(-2.5)`, -3.0},
+		{`round(2.345, 2)`, 2.35},
+		{`round(1250.0, -2)`, 1300.0},
+		{`round(7)`, 7},
+		{`round()`, "wrong number of arguments: expected=1, got=0"},
+		{`round(1.5, 1, 2)`, "wrong number of arguments: expected=2, got=3"},
+		{`round(1.5, 1.5)`, "argument to `round` not supported: FLOAT"},
+		{`floor(1.7)`, 1.0},
+		{`floor(-1.2)`, -2.0},
+		{`ceil(1.2)`, 2.0},
+		{`ceil(4)`, 4},
+		{`ceil("4")`, "argument to `ceil` not supported: STRING"},
 	}
 
 	for _, tt := range tests {
@@ -563,6 +1743,8 @@ func TestBuiltinFunctions(t *testing.T) {
 		switch expected := tt.expected.(type) {
 		case int:
 			testIntegerObject(t, evaluated, int64(expected))
+		case float64:
+			testFloatObject(t, evaluated, expected)
 		case []interface{}:
 			testArrayObject(t, evaluated, expected)
 		case string:
@@ -571,6 +1753,42 @@ func TestBuiltinFunctions(t *testing.T) {
 	}
 }
 
+func TestExit(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"exit(); 5", 0},
+		{"exit(3); 5", 3},
+		{"let f = fn() { exit(2); 1 }; f(); 5", 2},
+		{"for (let i = 0; i < 10; i = i + 1) { if (i == 4) { exit(i) } }; 5", 4},
+		{"let x = [1, exit(5)]; x", 5},
+		{`exit("1")`, "argument to `exit` not supported: STRING"},
+		{"exit(1, 2)", "wrong number of arguments: expected=1, got=2"},
+		{"exit(255)", 255},
+		{"exit(256)", "exit code out of range: 256"},
+		{"exit(-1)", "exit code out of range: -1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			exit, ok := evaluated.(*object.Exit)
+			if !ok {
+				t.Fatalf("object is not Exit. got=%T (%+v)", evaluated, evaluated)
+			}
+
+			if exit.Code != int64(expected) {
+				t.Errorf("wrong exit code. expected=%d, got=%d", expected, exit.Code)
+			}
+		case string:
+			testErrorObject(t, evaluated, expected)
+		}
+	}
+}
+
 func TestArrayLiterals(t *testing.T) {
 	input := "[1, 2 * 2, 3 + 3]"
 	evaluated := testEval(input)
@@ -779,6 +1997,22 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	return true
 }
 
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	float, ok := obj.(*object.Float)
+	if !ok {
+		t.Fatalf("object is not Float. got=%T (%+v)", obj, obj)
+		return false
+	}
+
+	if float.Value != expected {
+		t.Fatalf("object has wrong value. Expected=%g, got=%g",
+			expected, float.Value)
+		return false
+	}
+
+	return true
+}
+
 func testStringObject(t *testing.T, obj object.Object, expected string) bool {
 	str, ok := obj.(*object.String)
 	if !ok {
@@ -0,0 +1,97 @@
+package evaluator
+
+import (
+	"monkey/object"
+)
+
+// Iterator steps through the items of an array, hash, string or range for a
+// for-in loop. The VM keeps one on the stack while such a loop runs.
+type Iterator struct {
+	index  int64
+	length int64
+	isHash bool // a single variable is bound to the key rather than the value
+
+	// item returns the index (or key) and the value of the i'th item
+	item func(i int64) (object.Object, object.Object)
+}
+
+func (it *Iterator) Type() object.ObjectType { return object.ITERATOR_OBJ }
+func (it *Iterator) Inspect() string         { return "iterator" }
+
+// NewIterator starts iterating over obj. Arrays and hashes are iterated as
+// they were when the loop started, hashes in key order. Strings are iterated
+// by character.
+func NewIterator(obj object.Object) (*Iterator, *object.Error) {
+	switch obj := obj.(type) {
+	case *object.Array:
+		elements := make([]object.Object, len(obj.Elements))
+		copy(elements, obj.Elements)
+
+		return &Iterator{
+			length: int64(len(elements)),
+			item: func(i int64) (object.Object, object.Object) {
+				return nativeIntToIntegerObject(i), elements[i]
+			},
+		}, nil
+
+	case *object.Hash:
+		pairs := obj.SortedPairs()
+
+		return &Iterator{
+			length: int64(len(pairs)),
+			isHash: true,
+			item: func(i int64) (object.Object, object.Object) {
+				return pairs[i].Key, pairs[i].Value
+			},
+		}, nil
+
+	case *object.String:
+		runes := []rune(obj.Value)
+
+		return &Iterator{
+			length: int64(len(runes)),
+			item: func(i int64) (object.Object, object.Object) {
+				return nativeIntToIntegerObject(i), &object.String{Value: string(runes[i])}
+			},
+		}, nil
+
+	case *object.Range:
+		start, step := obj.Start, obj.Step
+
+		return &Iterator{
+			length: obj.Len(),
+			item: func(i int64) (object.Object, object.Object) {
+				return nativeIntToIntegerObject(i), nativeIntToIntegerObject(start + i*step)
+			},
+		}, nil
+
+	default:
+		return nil, newError("cannot iterate over %s", obj.Type())
+	}
+}
+
+// Remaining returns the number of items not yet seen.
+func (it *Iterator) Remaining() int64 {
+	return it.length - it.index
+}
+
+// Next returns the values to bind for the next item: the item alone when
+// count is 1, or the index (or key) followed by the item when it is 2. It
+// returns false once every item has been seen.
+func (it *Iterator) Next(count int) ([]object.Object, bool) {
+	if it.index >= it.length {
+		return nil, false
+	}
+
+	key, value := it.item(it.index)
+	it.index++
+
+	if count == 1 {
+		if it.isHash {
+			return []object.Object{key}, true
+		}
+		return []object.Object{value}, true
+	}
+
+	return []object.Object{key, value}, true
+}
@@ -0,0 +1,174 @@
+package evaluator
+
+import (
+	"context"
+	"errors"
+	"monkey/object"
+	"time"
+)
+
+// Limits bounds what a single evaluation may use. A zero field sets no limit,
+// except for MaxCallDepth, which is DefaultMaxCallDepth if it is zero.
+type Limits struct {
+	MaxSteps     int64         // nodes the evaluator evaluates, or instructions the VM executes
+	MaxDuration  time.Duration // wall time
+	MaxCallDepth int           // calls nested at once, builtins included
+	MaxMemory    int64         // approximate bytes allocated, see SizeOf
+}
+
+// DefaultMaxCallDepth is how deeply calls can nest when Limits doesn't say.
+const DefaultMaxCallDepth = 1 << 14
+
+// The reasons an evaluation is halted for exceeding its Limits. A halt because
+// the context is done gives the context's error instead.
+var (
+	ErrStepLimit   = errors.New("step limit exceeded")
+	ErrTimeLimit   = errors.New("time limit exceeded")
+	ErrMemoryLimit = errors.New("memory limit exceeded")
+)
+
+// ErrCallDepthLimit is why a call can't be made when calls are nested as
+// deeply as the Limits allow. It doesn't halt evaluation: returning from the
+// calls frees them, so it is raised as an *object.Error the program can catch,
+// see CallDepthError. The error's Cause is ErrCallDepthLimit, which a script
+// can't give the errors it throws, so a host can check for it with errors.Is.
+var ErrCallDepthLimit = errors.New("maximum recursion depth exceeded")
+
+// CallDepthError returns the error raised for a call EnterCall refused.
+func CallDepthError() *object.Error {
+	return &object.Error{Message: ErrCallDepthLimit.Error(), Cause: ErrCallDepthLimit}
+}
+
+// checkInterval is how many steps are taken between looks at the context and
+// the clock, which cost far more than a step does.
+const checkInterval = 1024
+
+// Budget keeps track of an evaluation against its Limits and its context. The
+// evaluator spends a step on each node it evaluates, the VM one on each
+// instruction it executes.
+type Budget struct {
+	ctx      context.Context
+	limits   Limits
+	deadline time.Time // zero if there is no time limit
+	stats    Stats
+}
+
+// Stats is what an evaluation has used so far. Memory is counted as it is
+// allocated and never given back, so Allocated is the total over the run
+// rather than what is still in use.
+type Stats struct {
+	Steps        int64
+	Allocated    int64 // approximate bytes, see SizeOf
+	MaxCallDepth int   // the most calls that were nested at once
+}
+
+func NewBudget(ctx context.Context, limits Limits) *Budget {
+	b := &Budget{ctx: ctx, limits: limits}
+	if limits.MaxDuration > 0 {
+		b.deadline = time.Now().Add(limits.MaxDuration)
+	}
+
+	return b
+}
+
+// Step spends a step. It returns why evaluation must stop, if it must: the
+// steps, time or memory have run out, or the context is done. The context and
+// clock are looked at on the first step, so an evaluation that is already
+// cancelled does nothing, and then every checkInterval steps.
+func (b *Budget) Step() error {
+	return b.Spend(1)
+}
+
+// Spend spends steps steps at once, for work such as copying the items of a
+// spread that takes as long as that many nodes or instructions would. It
+// stops evaluation as Step does, looking at the context and clock if any of
+// the steps is one Step would have looked at them on.
+func (b *Budget) Spend(steps int64) error {
+	if steps <= 0 {
+		return nil
+	}
+
+	before := b.stats.Steps
+	b.stats.Steps += steps
+
+	if b.limits.MaxSteps > 0 && b.stats.Steps > b.limits.MaxSteps {
+		return ErrStepLimit
+	}
+
+	if err := b.CheckMemory(); err != nil {
+		return err
+	}
+
+	if before != 0 && (before-1)/checkInterval == (b.stats.Steps-1)/checkInterval {
+		return nil
+	}
+
+	if err := b.ctx.Err(); err != nil {
+		return err
+	}
+
+	if !b.deadline.IsZero() && time.Now().After(b.deadline) {
+		return ErrTimeLimit
+	}
+
+	return nil
+}
+
+// BuiltinSteps is how many steps a builtin is charged for giving result from
+// args: one for each element of an array it made, which it had to copy.
+func BuiltinSteps(result object.Object, args []object.Object) int64 {
+	array, ok := result.(*object.Array)
+	if !ok || BuiltinAllocation(result, args) == 0 {
+		return 0
+	}
+
+	return int64(len(array.Elements))
+}
+
+// EnterCall returns ErrCallDepthLimit if a call can't be made with depth calls
+// already nested.
+func (b *Budget) EnterCall(depth int) error {
+	maxDepth := b.limits.MaxCallDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxCallDepth
+	}
+
+	if depth >= maxDepth {
+		return ErrCallDepthLimit
+	}
+
+	if depth+1 > b.stats.MaxCallDepth {
+		b.stats.MaxCallDepth = depth + 1
+	}
+
+	return nil
+}
+
+// Allocate records that bytes of memory have been allocated. Going over the
+// limit doesn't stop anything by itself, the next Step or CheckMemory does.
+func (b *Budget) Allocate(bytes int64) {
+	b.stats.Allocated += bytes
+}
+
+// CheckMemory returns ErrMemoryLimit if more memory has been allocated than
+// the limits allow.
+func (b *Budget) CheckMemory() error {
+	return b.CheckAllocation(0)
+}
+
+// CheckAllocation returns ErrMemoryLimit if allocating bytes more would take
+// the memory allocated past the limit. It is for allocations large enough to
+// be worth refusing before they are made. Nothing is recorded, the memory is
+// counted by Allocate once it has been allocated.
+func (b *Budget) CheckAllocation(bytes int64) error {
+	if b.limits.MaxMemory > 0 && b.stats.Allocated+bytes > b.limits.MaxMemory {
+		return ErrMemoryLimit
+	}
+
+	return nil
+}
+
+// Stats is what the evaluation has used so far.
+func (b *Budget) Stats() Stats {
+	return b.stats
+}
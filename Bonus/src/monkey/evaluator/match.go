@@ -0,0 +1,156 @@
+package evaluator
+
+import (
+	"monkey/ast"
+	"monkey/object"
+)
+
+// The types each type name in a match pattern stands for
+var matchTypes = map[string][]object.ObjectType{
+	"int":    {object.INTEGER_OBJ},
+	"float":  {object.FLOAT_OBJ},
+	"string": {object.STRING_OBJ},
+	"bool":   {object.BOOLEAN_OBJ},
+	"null":   {object.NULL_OBJ},
+	"array":  {object.ARRAY_OBJ},
+	"hash":   {object.HASH_OBJ},
+	"range":  {object.RANGE_OBJ},
+	"fn":     {object.FUNCTION_OBJ, object.BUILTIN_OBJ},
+	"error":  {object.ERROR_VALUE_OBJ},
+}
+
+func (in *interpreter) evalMatchExpression(node *ast.MatchExpression, env *object.Environment) object.Object {
+	subject := in.eval(node.Subject, env)
+	if isError(subject) {
+		return subject
+	}
+
+	for _, arm := range node.Arms {
+		values, ok := MatchPattern(arm.Pattern, subject, in.budget)
+		if !ok {
+			continue
+		}
+
+		armEnv := in.enclose(env)
+		for i, name := range ast.PatternNames(arm.Pattern) {
+			if res := armEnv.Add(name.Value, values[i]); isError(res) {
+				return res
+			}
+		}
+
+		if arm.Guard != nil {
+			guard := in.eval(arm.Guard, armEnv)
+			if isError(guard) {
+				return guard
+			}
+
+			if !isTruthy(guard) {
+				continue
+			}
+		}
+
+		return in.eval(arm.Body, armEnv)
+	}
+
+	return NoMatchError(subject)
+}
+
+// MatchPattern reports whether value matches the pattern of a match arm. If
+// it does, it also returns the values for the names the pattern binds, in the
+// order ast.PatternNames gives them. The arrays made for rest patterns are
+// recorded in budget.
+func MatchPattern(pattern ast.Pattern, value object.Object, budget *Budget) ([]object.Object, bool) {
+	values := []object.Object{}
+	if !matchPattern(pattern, value, &values, budget) {
+		return nil, false
+	}
+
+	return values, true
+}
+
+func matchPattern(pattern ast.Pattern, value object.Object, values *[]object.Object, budget *Budget) bool {
+	if _, ok := pattern.(*ast.WildcardPattern); ok {
+		return true
+	}
+
+	// Nothing else matches the lack of a value
+	if value == nil {
+		return false
+	}
+
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		*values = append(*values, value)
+		return true
+
+	case *ast.LiteralPattern:
+		literal := Eval(pattern.Value, nil)
+		return literal.Type() == value.Type() && evalInfixOperator("==", value, literal) == TRUE
+
+	case *ast.TypePattern:
+		for _, t := range matchTypes[pattern.Token.Literal] {
+			if value.Type() == t {
+				if pattern.Name != nil {
+					*values = append(*values, value)
+				}
+
+				return true
+			}
+		}
+
+		return false
+
+	case *ast.ArrayPattern:
+		array, ok := value.(*object.Array)
+		if !ok {
+			return false
+		}
+
+		length := len(pattern.Elements)
+		if len(array.Elements) < length || (pattern.Rest == nil && len(array.Elements) > length) {
+			return false
+		}
+
+		for i, element := range pattern.Elements {
+			if !matchPattern(element.Target, array.Elements[i], values, budget) {
+				return false
+			}
+		}
+
+		if pattern.Rest != nil {
+			rest := ArrayPatternRest(array, length)
+			budget.Allocate(SizeOf(rest))
+
+			*values = append(*values, rest)
+		}
+
+		return true
+
+	case *ast.HashPattern:
+		hash, ok := value.(*object.Hash)
+		if !ok {
+			return false
+		}
+
+		for _, pair := range pattern.Pairs {
+			item, _ := HashPatternElement(hash, pair.Key.Value, true)
+			if item == nil || !matchPattern(pair.Value.Target, item, values, budget) {
+				return false
+			}
+		}
+
+		return true
+
+	default:
+		return false
+	}
+}
+
+// NoMatchError reports a match expression none of whose arms matched value.
+func NoMatchError(value object.Object) *object.Error {
+	if value == nil {
+		return newError("non-exhaustive match: no arm matches empty value")
+	}
+
+	return newError("non-exhaustive match: no arm matches %s", value.Inspect())
+}
@@ -0,0 +1,107 @@
+package evaluator
+
+import (
+	"monkey/ast"
+	"monkey/object"
+)
+
+// Rough sizes, in bytes, of the values a script can make as many of as it
+// likes, including what Go needs to keep track of them. They only have to be
+// close enough for a limit on memory to stop a script long before the host
+// runs short.
+const (
+	stringSize      = 32 // before the bytes of the string itself
+	arraySize       = 32
+	elementSize     = 16
+	hashSize        = 48
+	pairSize        = 64
+	environmentSize = 96 // before its variables
+	bindingSize     = 48
+)
+
+// SizeOf is roughly how much memory obj takes up, not counting the values it
+// holds, which were allocated and counted when they were made. Only strings,
+// arrays and hashes are counted, everything else is small and fixed in size.
+func SizeOf(obj object.Object) int64 {
+	switch obj := obj.(type) {
+	case *object.String:
+		return stringSize + int64(len(obj.Value))
+	case *object.Array:
+		return arraySize + elementSize*int64(len(obj.Elements))
+	case *object.Hash:
+		return hashSize + pairSize*int64(len(obj.Pairs))
+	default:
+		return 0
+	}
+}
+
+// EnvironmentSize is roughly how much memory an environment holding the given
+// number of variables takes up.
+func EnvironmentSize(variables int) int64 {
+	return environmentSize + bindingSize*int64(variables)
+}
+
+// BuiltinAllocation is roughly how much memory a builtin allocated to give
+// result from args. A result that is one of the arguments, or an item of one,
+// was allocated before the builtin ran.
+func BuiltinAllocation(result object.Object, args []object.Object) int64 {
+	for _, arg := range args {
+		if result == arg {
+			return 0
+		}
+
+		if array, ok := arg.(*object.Array); ok {
+			for _, element := range array.Elements {
+				if result == element {
+					return 0
+				}
+			}
+		}
+	}
+
+	return SizeOf(result)
+}
+
+// ArgumentAllocation is roughly how much memory MatchArguments allocated to
+// give values for params, which is the array a variodic parameter collects
+// its arguments in.
+func ArgumentAllocation(params []*ast.FunctionParameter, values []object.Object) int64 {
+	if len(params) == 0 || !params[len(params)-1].IsVariodic {
+		return 0
+	}
+
+	return SizeOf(values[len(params)-1])
+}
+
+// allocate records the memory taken up by obj, which has just been made, and
+// returns it.
+func (in *interpreter) allocate(obj object.Object) object.Object {
+	in.budget.Allocate(SizeOf(obj))
+	return obj
+}
+
+// enclose returns a new environment inside outer, recording the memory it
+// takes up.
+func (in *interpreter) enclose(outer *object.Environment) *object.Environment {
+	in.budget.Allocate(EnvironmentSize(0))
+	return object.NewEnclosedEnvironment(outer)
+}
+
+// bind returns a function that adds variables to env as env.Add does,
+// recording the memory each one takes up.
+func (in *interpreter) bind(env *object.Environment) func(string, object.Object) object.Object {
+	return func(name string, value object.Object) object.Object {
+		in.budget.Allocate(bindingSize)
+		return env.Add(name, value)
+	}
+}
+
+// assignIndex is evalIndexAssignment, recording the memory taken up by any
+// pair it adds to a hash.
+func (in *interpreter) assignIndex(left, index, value object.Object) object.Object {
+	before := SizeOf(left)
+	result := evalIndexAssignment(left, index, value)
+
+	in.budget.Allocate(SizeOf(left) - before)
+	return result
+}
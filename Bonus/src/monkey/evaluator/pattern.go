@@ -0,0 +1,183 @@
+package evaluator
+
+import (
+	"monkey/ast"
+	"monkey/object"
+)
+
+// bindPattern binds value to the names in pattern using bind, which defines
+// new variables for let, parameters and loops, and sets existing ones for an
+// assignment. Problems taking the value apart are reported at the pattern.
+func (in *interpreter) bindPattern(
+	pattern ast.Pattern,
+	value object.Object,
+	env *object.Environment,
+	bind func(string, object.Object) object.Object,
+) object.Object {
+	var result object.Object
+
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		return bind(pattern.Value, value)
+
+	case *ast.ArrayPattern:
+		result = in.bindArrayPattern(pattern, value, env, bind)
+
+	case *ast.HashPattern:
+		result = in.bindHashPattern(pattern, value, env, bind)
+	}
+
+	if err, ok := result.(*object.Error); ok && !err.Span.IsValid() {
+		err.Span = pattern.Span()
+	}
+
+	return result
+}
+
+func (in *interpreter) bindArrayPattern(
+	pattern *ast.ArrayPattern,
+	value object.Object,
+	env *object.Environment,
+	bind func(string, object.Object) object.Object,
+) object.Object {
+	if err := CheckArrayPattern(value, pattern.Required(), len(pattern.Elements), pattern.Rest != nil); err != nil {
+		return err
+	}
+
+	array := value.(*object.Array)
+
+	for i, element := range pattern.Elements {
+		result := in.bindPatternElement(element, ArrayPatternElement(array, i), env, bind)
+		if isError(result) {
+			return result
+		}
+	}
+
+	if pattern.Rest != nil {
+		return bind(pattern.Rest.Value, in.allocate(ArrayPatternRest(array, len(pattern.Elements))))
+	}
+
+	return nil
+}
+
+func (in *interpreter) bindHashPattern(
+	pattern *ast.HashPattern,
+	value object.Object,
+	env *object.Environment,
+	bind func(string, object.Object) object.Object,
+) object.Object {
+	if err := CheckHashPattern(value); err != nil {
+		return err
+	}
+
+	hash := value.(*object.Hash)
+
+	for _, pair := range pattern.Pairs {
+		item, err := HashPatternElement(hash, pair.Key.Value, pair.Value.Default != nil)
+		if err != nil {
+			return err
+		}
+
+		result := in.bindPatternElement(pair.Value, item, env, bind)
+		if isError(result) {
+			return result
+		}
+	}
+
+	return nil
+}
+
+// bindPatternElement binds one item of an array or hash, using the element's
+// default when the item is missing (nil).
+func (in *interpreter) bindPatternElement(
+	element *ast.PatternElement,
+	value object.Object,
+	env *object.Environment,
+	bind func(string, object.Object) object.Object,
+) object.Object {
+	if value == nil {
+		value = in.eval(element.Default, env)
+		if isError(value) {
+			return value
+		}
+	}
+
+	return in.bindPattern(element.Target, value, env, bind)
+}
+
+// CheckArrayPattern reports whether value can be taken apart by an array
+// pattern with count elements. The array needs an item for each of the first
+// required elements and, unless the pattern collects the rest, can't have
+// more items than elements.
+func CheckArrayPattern(value object.Object, required, count int, rest bool) *object.Error {
+	if value == nil {
+		return newError("cannot assign empty value to variable")
+	}
+
+	array, ok := value.(*object.Array)
+	if !ok {
+		return newError("cannot destructure %s as an array", value.Type())
+	}
+
+	length := len(array.Elements)
+
+	if length < required {
+		return newError("not enough values to unpack: expected %d, got %d", required, length)
+	}
+
+	if !rest && length > count {
+		return newError("too many values to unpack: expected %d, got %d", count, length)
+	}
+
+	return nil
+}
+
+// ArrayPatternElement returns the i'th item of array, or nil if it has no
+// such item.
+func ArrayPatternElement(array *object.Array, i int) object.Object {
+	if i >= len(array.Elements) {
+		return nil
+	}
+
+	return array.Elements[i]
+}
+
+// ArrayPatternRest returns a new array of the items of array from the i'th on.
+func ArrayPatternRest(array *object.Array, i int) *object.Array {
+	rest := []object.Object{}
+	if i < len(array.Elements) {
+		rest = append(rest, array.Elements[i:]...)
+	}
+
+	return &object.Array{Elements: rest}
+}
+
+// CheckHashPattern reports whether value can be taken apart by a hash pattern.
+func CheckHashPattern(value object.Object) *object.Error {
+	if value == nil {
+		return newError("cannot assign empty value to variable")
+	}
+
+	if _, ok := value.(*object.Hash); !ok {
+		return newError("cannot destructure %s as a hash", value.Type())
+	}
+
+	return nil
+}
+
+// HashPatternElement returns the value under the string key in hash. A missing
+// key is an error unless optional is set, in which case it gives nil.
+func HashPatternElement(hash *object.Hash, key string, optional bool) (object.Object, *object.Error) {
+	keyObj := &object.String{Value: key}
+
+	pair, ok := hash.Pairs[keyObj.HashKey()]
+	if !ok {
+		if optional {
+			return nil, nil
+		}
+
+		return nil, newError("missing hash key: %s", key)
+	}
+
+	return pair.Value, nil
+}
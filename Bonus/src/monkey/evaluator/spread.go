@@ -0,0 +1,59 @@
+package evaluator
+
+import (
+	"monkey/object"
+)
+
+// SpreadItems returns the items a spread of value stands for in an array or
+// the arguments of a call. These are the items a for-in loop with a single
+// variable would see, so spreading a hash gives its keys. Nothing is copied if
+// the items wouldn't fit in the memory budget has left, and copying each item
+// costs a step, so a long spread stops as soon as budget runs out. The second
+// result is an *object.Error, or an *object.Halt without a position.
+func SpreadItems(value object.Object, budget *Budget) ([]object.Object, object.Object) {
+	if value == nil {
+		return nil, newError("cannot spread empty value")
+	}
+
+	it, err := NewIterator(value)
+	if err != nil {
+		return nil, newError("cannot spread %s", value.Type())
+	}
+
+	if err := budget.CheckAllocation(elementSize * it.Remaining()); err != nil {
+		return nil, &object.Halt{Err: err}
+	}
+
+	items := []object.Object{}
+	for {
+		values, ok := it.Next(1)
+		if !ok {
+			return items, nil
+		}
+
+		if err := budget.Step(); err != nil {
+			return nil, &object.Halt{Err: err}
+		}
+
+		items = append(items, values[0])
+	}
+}
+
+// SpreadPairs copies the pairs of value, which must be a hash, into pairs,
+// replacing any already there with the same key.
+func SpreadPairs(pairs map[object.HashKey]object.HashPair, value object.Object) *object.Error {
+	if value == nil {
+		return newError("cannot spread empty value")
+	}
+
+	hash, ok := value.(*object.Hash)
+	if !ok {
+		return newError("cannot spread %s into a hash", value.Type())
+	}
+
+	for key, pair := range hash.Pairs {
+		pairs[key] = pair
+	}
+
+	return nil
+}
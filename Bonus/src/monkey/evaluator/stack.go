@@ -0,0 +1,140 @@
+package evaluator
+
+import (
+	"monkey/object"
+	"monkey/token"
+)
+
+// The names given in stack traces to the program itself, outside any function,
+// and to functions that have no name of their own
+const (
+	MainFunctionName      = "<main>"
+	AnonymousFunctionName = "<anonymous>"
+)
+
+// call is a function being called, and where it was called from.
+type call struct {
+	function object.Object
+	span     token.Span
+}
+
+// enterCall records a call to fn made at span. It returns an error if calls
+// are already nested as deeply as they can be.
+func (in *interpreter) enterCall(fn object.Object, span token.Span) *object.Error {
+	if err := in.budget.EnterCall(len(in.calls)); err != nil {
+		return CallDepthError()
+	}
+
+	in.calls = append(in.calls, call{function: fn, span: span})
+	return nil
+}
+
+func (in *interpreter) leaveCall() {
+	in.calls = in.calls[:len(in.calls)-1]
+}
+
+// tailCall is a call in tail position, evaluated as far as the function and its
+// arguments so that the function it ends can make it, see callFunction.
+type tailCall struct {
+	function *object.Function
+	args     []object.Object
+	names    []string
+	span     token.Span
+}
+
+func (tc *tailCall) Type() object.ObjectType { return object.TAIL_CALL_OBJ }
+func (tc *tailCall) Inspect() string         { return "tail call" }
+
+// stackTrace lists the calls active when an error is raised at span,
+// innermost first and ending with the program itself. Each frame holds the
+// position its function had reached, which for a builtin is unknown.
+func (in *interpreter) stackTrace(span token.Span) []object.StackFrame {
+	stack := make([]object.StackFrame, 0, len(in.calls)+1)
+
+	for i := len(in.calls) - 1; i >= 0; i-- {
+		c := in.calls[i]
+
+		if _, ok := c.function.(*object.Builtin); ok {
+			span = token.Span{}
+		}
+
+		stack = append(stack, object.StackFrame{Function: FunctionName(c.function), Span: span})
+		span = c.span
+	}
+
+	return append(stack, object.StackFrame{Function: MainFunctionName, Span: span})
+}
+
+// FunctionName is the name fn is shown by in stack traces.
+func FunctionName(fn object.Object) string {
+	var name string
+
+	switch fn := fn.(type) {
+	case *object.Builtin:
+		name = fn.Name
+	case *object.Function:
+		name = fn.Name
+	case *object.Closure:
+		name = fn.FunctionName()
+	}
+
+	if name == "" {
+		return AnonymousFunctionName
+	}
+
+	return name
+}
+
+// NameFunction gives fn the name it is being bound to by a let statement,
+// unless it already has one. Literals are named as they are parsed, this
+// names the functions that are only bound later, such as those returned by
+// other functions.
+func NameFunction(fn object.Object, name string) {
+	switch fn := fn.(type) {
+	case *object.Function:
+		if fn.Name == "" {
+			fn.Name = name
+		}
+	case *object.Closure:
+		if fn.FunctionName() == "" {
+			fn.Name = name
+		}
+	}
+}
+
+// stackValue turns the stack of an error into an array a script can inspect,
+// with a hash for each frame giving the function and position it had reached.
+func stackValue(stack []object.StackFrame) *object.Array {
+	elements := make([]object.Object, len(stack))
+
+	for i, frame := range stack {
+		hash := &object.Hash{Pairs: map[object.HashKey]object.HashPair{}}
+
+		add := func(key string, value object.Object) {
+			keyObj := &object.String{Value: key}
+			hash.Pairs[keyObj.HashKey()] = object.HashPair{Key: keyObj, Value: value}
+		}
+
+		add("function", &object.String{Value: frame.Function})
+		add("line", spanField(frame.Span, "line"))
+		add("column", spanField(frame.Span, "column"))
+
+		elements[i] = hash
+	}
+
+	return &object.Array{Elements: elements}
+}
+
+// spanField returns the line or column where span starts, or null if it is
+// unset.
+func spanField(span token.Span, field string) object.Object {
+	if !span.IsValid() {
+		return NULL
+	}
+
+	if field == "line" {
+		return nativeIntToIntegerObject(int64(span.Start.Line))
+	}
+
+	return nativeIntToIntegerObject(int64(span.Start.Column))
+}
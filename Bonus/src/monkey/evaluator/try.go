@@ -0,0 +1,98 @@
+package evaluator
+
+import (
+	"monkey/ast"
+	"monkey/object"
+)
+
+// The kind reported for the errors the interpreter raises itself, and the one
+// given to errors made by scripts when they don't choose one
+const (
+	RuntimeErrorKind = "RuntimeError"
+	DefaultErrorKind = "Error"
+)
+
+func (in *interpreter) evalTryExpression(node *ast.TryExpression, env *object.Environment) object.Object {
+	result := in.eval(node.Block, in.enclose(env))
+
+	if err, ok := result.(*object.Error); ok && node.Catch != nil {
+		catchEnv := in.enclose(env)
+		if node.CatchParameter != nil {
+			catchEnv.Add(node.CatchParameter.Value, &object.ErrorValue{Err: err})
+		}
+
+		result = in.eval(node.Catch, catchEnv)
+	}
+
+	if node.Finally == nil {
+		return result
+	}
+
+	// Exit and Halt end the program at once, without running finally blocks
+	switch result.(type) {
+	case *object.Exit, *object.Halt:
+		return result
+	}
+
+	// Whatever unwinds out of the finally block replaces the result
+	finally := in.eval(node.Finally, in.enclose(env))
+	if _, ok := finally.(*object.ReturnValue); ok || isError(finally) {
+		return finally
+	}
+
+	return result
+}
+
+// ThrowError returns the error raised by throwing value. Throwing an error
+// value raises that error again, keeping where it was first raised, and a
+// string raises an error with that message.
+func ThrowError(value object.Object) *object.Error {
+	switch value := value.(type) {
+	case *object.ErrorValue:
+		err := *value.Err
+		return &err
+
+	case *object.String:
+		return &object.Error{Message: value.Value, Kind: DefaultErrorKind}
+
+	default:
+		if value == nil {
+			return newError("cannot throw empty value")
+		}
+
+		return newError("cannot throw %s", value.Type())
+	}
+}
+
+// evalErrorField looks up one of the fields of an error value by name. The
+// position fields are null, and the stack empty, for an error that hasn't been
+// raised yet.
+func evalErrorField(errorObj, indexObj object.Object) object.Object {
+	err := errorObj.(*object.ErrorValue).Err
+
+	field, ok := indexObj.(*object.String)
+	if !ok {
+		return newError("unusable as error field: %s", indexObj.Type())
+	}
+
+	switch field.Value {
+	case "message":
+		return &object.String{Value: err.Message}
+
+	case "kind":
+		if err.Kind == "" {
+			return &object.String{Value: RuntimeErrorKind}
+		}
+
+		return &object.String{Value: err.Kind}
+
+	case "line", "column":
+		return spanField(err.Span, field.Value)
+
+	case "stack":
+		return stackValue(err.Stack)
+
+	default:
+		return NULL
+	}
+}
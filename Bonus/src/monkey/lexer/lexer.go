@@ -2,34 +2,134 @@ package lexer
 
 import (
 	"monkey/token"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 type Lexer struct {
 	input        string
-	position     int  // current position in input, points to the current char
+	filename     string
+	position     int  // current byte position in input, points to the current char
 	readPosition int  // current reading position in input, after the current char
-	ch           byte // current character
+	ch           rune // current character
+	line         int  // line of the current char
+	column       int  // column of the current char, counted in characters
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewWithFilename(input, "")
+}
+
+// NewWithFilename creates a lexer whose token positions report the given filename.
+func NewWithFilename(input, filename string) *Lexer {
+	l := &Lexer{input: input, filename: filename, line: 1}
 	l.readChar()
 	return l
 }
 
 func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
+	comments, unterminated := l.readTrivia()
+	if unterminated != nil {
+		return token.Token{
+			Type:     token.ILLEGAL,
+			Literal:  unterminated.Text,
+			Start:    unterminated.Start,
+			End:      unterminated.End,
+			Comments: comments,
+		}
+	}
+
+	start := l.currentPosition()
+	tok := l.readToken()
+	tok.Start = start
+	tok.End = l.currentPosition()
+	tok.Comments = comments
+
+	return tok
+}
+
+// readTrivia skips whitespace and collects the comments before the next
+// token. A block comment that is never closed runs to the end of the input,
+// it is returned separately so that it can be reported.
+func (l *Lexer) readTrivia() ([]token.Comment, *token.Comment) {
+	var comments []token.Comment
+
+	for {
+		l.skipWhitespace()
+
+		if l.ch != '/' || (l.peekChar() != '/' && l.peekChar() != '*') {
+			return comments, nil
+		}
+
+		start := l.currentPosition()
+		closed := true
+		if l.peekChar() == '/' {
+			l.skipLineComment()
+		} else {
+			closed = l.skipBlockComment()
+		}
+
+		comment := token.Comment{
+			Text:  l.input[start.Offset:l.position],
+			Start: start,
+			End:   l.currentPosition(),
+		}
+
+		if !closed {
+			return comments, &comment
+		}
+
+		comments = append(comments, comment)
+	}
+}
+
+func (l *Lexer) skipLineComment() {
+	for l.ch != '\n' && l.ch != 0 {
+		l.readChar()
+	}
+}
+
+// skipBlockComment reads past a block comment, which may contain nested block
+// comments. It reports false if the input ends before the comment is closed.
+func (l *Lexer) skipBlockComment() bool {
+	depth := 0
 
-	l.skipWhitespace()
+	for l.ch != 0 {
+		switch {
+		case l.ch == '/' && l.peekChar() == '*':
+			depth++
+			l.readChar()
+		case l.ch == '*' && l.peekChar() == '/':
+			depth--
+			l.readChar()
+		}
+
+		l.readChar()
+
+		if depth == 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (l *Lexer) readToken() token.Token {
+	var tok token.Token
 
 	switch l.ch {
 	case '=':
-		if l.peekChar() == '=' {
+		switch l.peekChar() {
+		case '=':
 			ch := l.ch
 			l.readChar()
 			tok = token.Token{Type: token.EQ, Literal: string(ch) + string(l.ch)}
-		} else {
+		case '>':
+			l.readChar()
+			tok = token.Token{Type: token.ARROW, Literal: "=>"}
+		default:
 			tok = newToken(token.ASSIGN, l.ch)
 		}
 
@@ -43,24 +143,71 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.COLON, l.ch)
 
 	case '.':
-		if l.peekChar() == '.' {
+		if isDigit(l.peekChar()) {
+			tok.Type, tok.Literal = l.readNumber()
+			// Return early to avoid the l.readChar() at the end
+			return tok
+		} else if l.peekChar() == '.' {
 			ch1 := l.ch
 			l.readChar()
 			if l.peekChar() == '.' {
 				ch2 := l.ch
 				l.readChar()
 				tok = token.Token{Type: token.ELLIPSIS, Literal: string(ch1) + string(ch2) + string(l.ch)}
+			} else {
+				tok = token.Token{Type: token.ILLEGAL, Literal: string(ch1) + string(l.ch)}
 			}
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
 		}
 
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		switch l.peekChar() {
+		case '=':
+			l.readChar()
+			tok = token.Token{Type: token.PLUS_ASSIGN, Literal: "+="}
+		case '+':
+			l.readChar()
+			tok = token.Token{Type: token.INCREMENT, Literal: "++"}
+		default:
+			tok = newToken(token.PLUS, l.ch)
+		}
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		switch l.peekChar() {
+		case '=':
+			l.readChar()
+			tok = token.Token{Type: token.MINUS_ASSIGN, Literal: "-="}
+		case '-':
+			l.readChar()
+			tok = token.Token{Type: token.DECREMENT, Literal: "--"}
+		default:
+			tok = newToken(token.MINUS, l.ch)
+		}
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = token.Token{Type: token.SLASH_ASSIGN, Literal: "/="}
+		} else {
+			tok = newToken(token.SLASH, l.ch)
+		}
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		switch l.peekChar() {
+		case '*':
+			l.readChar()
+			tok = token.Token{Type: token.POWER, Literal: "**"}
+		case '=':
+			l.readChar()
+			tok = token.Token{Type: token.ASTERISK_ASSIGN, Literal: "*="}
+		default:
+			tok = newToken(token.ASTERISK, l.ch)
+		}
+	case '%':
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = token.Token{Type: token.PERCENT_ASSIGN, Literal: "%="}
+		} else {
+			tok = newToken(token.PERCENT, l.ch)
+		}
 
 	case '!':
 		if l.peekChar() == '=' {
@@ -71,10 +218,47 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.BANG, l.ch)
 		}
 
+	case '&':
+		if l.peekChar() == '&' {
+			l.readChar()
+			tok = token.Token{Type: token.AND, Literal: "&&"}
+		} else {
+			tok = newToken(token.AMPERSAND, l.ch)
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			l.readChar()
+			tok = token.Token{Type: token.OR, Literal: "||"}
+		} else {
+			tok = newToken(token.PIPE, l.ch)
+		}
+	case '^':
+		tok = newToken(token.CARET, l.ch)
+	case '~':
+		tok = newToken(token.TILDE, l.ch)
+
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		switch l.peekChar() {
+		case '=':
+			l.readChar()
+			tok = token.Token{Type: token.LT_EQ, Literal: "<="}
+		case '<':
+			l.readChar()
+			tok = token.Token{Type: token.SHIFT_LEFT, Literal: "<<"}
+		default:
+			tok = newToken(token.LT, l.ch)
+		}
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		switch l.peekChar() {
+		case '=':
+			l.readChar()
+			tok = token.Token{Type: token.GT_EQ, Literal: ">="}
+		case '>':
+			l.readChar()
+			tok = token.Token{Type: token.SHIFT_RIGHT, Literal: ">>"}
+		default:
+			tok = newToken(token.GT, l.ch)
+		}
 
 	case '{':
 		tok = newToken(token.LBRACE, l.ch)
@@ -98,7 +282,11 @@ func (l *Lexer) NextToken() token.Token {
 	case '"':
 		str, ok := l.readString()
 		if !ok {
-			return newToken(token.ILLEGAL, l.ch)
+			tok = token.Token{Type: token.ILLEGAL, Literal: str}
+			if l.ch == 0 {
+				return tok
+			}
+			break
 		}
 
 		tok.Type = token.STRING
@@ -111,8 +299,7 @@ func (l *Lexer) NextToken() token.Token {
 			// Return early to avoid the l.readChar() at the end
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Literal = l.readNumber()
-			tok.Type = token.INT
+			tok.Type, tok.Literal = l.readNumber()
 			// Return early to avoid the l.readChar() at the end
 			return tok
 		} else {
@@ -124,51 +311,128 @@ func (l *Lexer) NextToken() token.Token {
 	return tok
 }
 
+// readChar advances to the next UTF-8 encoded character. Invalid encodings
+// are read as utf8.RuneError, which the lexer reports as an illegal token.
 func (l *Lexer) readChar() {
-	// TODO: Unicode support
+	if l.readPosition > len(l.input) {
+		// Already at the end of the input
+		return
+	}
+
+	if l.ch == '\n' {
+		l.line += 1
+		l.column = 0
+	}
 
+	size := 1
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
-		l.ch = l.input[l.readPosition]
+		l.ch, size = utf8.DecodeRuneInString(l.input[l.readPosition:])
 	}
 
 	l.position = l.readPosition
+	l.column += 1
 
-	l.readPosition += 1
+	l.readPosition += size
 }
 
-func (l *Lexer) peekChar() byte {
+func (l *Lexer) currentPosition() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.position,
+		Line:     l.line,
+		Column:   l.column,
+	}
+}
+
+func (l *Lexer) peekChar() rune {
 	if l.readPosition >= len(l.input) {
 		return 0
 	} else {
-		return l.input[l.readPosition]
+		ch, _ := utf8.DecodeRuneInString(l.input[l.readPosition:])
+		return ch
 	}
 }
 
 func (l *Lexer) readIdentifer() string {
 	position := l.position
-	for isLetter(l.ch) {
+	for isLetter(l.ch) || (l.position > position && isIdentifierDigit(l.ch)) {
 		l.readChar()
 	}
 	return l.input[position:l.position]
 }
 
-func (l *Lexer) readNumber() string {
-	// TODO: Floats
-	// TODO: Exponents
-	// TODO: Hex notation
-	// TODO: Octal notation
+// readNumber reads an integer or a float such as 3.14, .5 or 1e-9. Integers
+// may use a 0x, 0o or 0b prefix and digits may be separated by underscores.
+// Malformed literals are still returned whole, with their best guess type, so
+// that the parser can report them.
+func (l *Lexer) readNumber() (token.TokenType, string) {
 	// TODO: Unsigned
 	// TODO: Long
 
+	tokenType := token.TokenType(token.INT)
+
 	position := l.position
-	for isDigit(l.ch) {
+
+	if l.ch == '0' && isBasePrefix(l.peekChar()) {
+		l.readChar()
+		l.readChar()
+
+		// Take any letters too, so that 0xFG is one bad literal rather than
+		// a number followed by an identifier
+		for isLetter(l.ch) || isDigit(l.ch) {
+			l.readChar()
+		}
+
+		return tokenType, l.input[position:l.position]
+	}
+
+	l.readDigits()
+
+	// A '.' that isn't followed by a digit is left alone, it may start a '...'
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
+
+		l.readChar()
+		l.readDigits()
+	}
+
+	if l.ch == 'e' || l.ch == 'E' {
+		tokenType = token.FLOAT
+
+		l.readChar()
+		if l.ch == '+' || l.ch == '-' {
+			l.readChar()
+		}
+
+		l.readDigits()
+	}
+
+	// Take the rest of a run such as 1.2.3 or 12abc too, so that it is one
+	// bad literal rather than a number followed by more tokens
+	for isLetter(l.ch) || isDigit(l.ch) || (l.ch == '.' && isDigit(l.peekChar())) {
+		if l.ch == '.' {
+			tokenType = token.FLOAT
+		}
+
+		l.readChar()
+	}
+
+	return tokenType, l.input[position:l.position]
+}
+
+// readDigits reads decimal digits and the underscores between them.
+func (l *Lexer) readDigits() {
+	for isDigit(l.ch) || l.ch == '_' {
 		l.readChar()
 	}
-	return l.input[position:l.position]
 }
 
+// readString reads a string literal and resolves its escape sequences. When
+// the string is unterminated or holds a malformed escape it returns false,
+// along with the offending text: the rest of the input starting at the
+// opening quote, or the escape sequence.
 func (l *Lexer) readString() (string, bool) {
 	position := l.position + 1
 
@@ -180,15 +444,11 @@ func (l *Lexer) readString() (string, bool) {
 		} else if l.ch == '"' {
 			break
 		} else if l.ch == 0 {
-			return "", false
+			return l.input[position-1 : l.position], false
 		}
 	}
 
-	str := l.input[position:l.position]
-
-	unescaped := unescapeString(str)
-
-	return unescaped, true
+	return unescapeString(l.input[position:l.position])
 }
 
 func (l *Lexer) skipWhitespace() {
@@ -197,28 +457,105 @@ func (l *Lexer) skipWhitespace() {
 	}
 }
 
-func newToken(tokenType token.TokenType, ch byte) token.Token {
+func newToken(tokenType token.TokenType, ch rune) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
 
-func isLetter(ch byte) bool {
-	return ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') || ch == '_'
+func isLetter(ch rune) bool {
+	if ch < utf8.RuneSelf {
+		return ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') || ch == '_'
+	}
+
+	return unicode.IsLetter(ch)
 }
 
-func isWhitespace(ch byte) bool {
+// isIdentifierDigit reports whether ch can continue, but not start, an
+// identifier: non-ASCII digits and marks such as combining accents. ASCII
+// digits are left out, they have never been part of identifiers.
+func isIdentifierDigit(ch rune) bool {
+	return ch >= utf8.RuneSelf && (unicode.IsDigit(ch) || unicode.IsMark(ch))
+}
+
+func isWhitespace(ch rune) bool {
 	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
 }
 
-func isDigit(ch byte) bool {
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
-func unescapeString(str string) string {
-	str = strings.ReplaceAll(str, "\\t", "\t")
-	str = strings.ReplaceAll(str, "\\n", "\n")
-	str = strings.ReplaceAll(str, "\\r", "\r")
-	str = strings.ReplaceAll(str, "\\\"", "\"")
-	str = strings.ReplaceAll(str, "\\\\", "\\")
+func isBasePrefix(ch rune) bool {
+	switch ch {
+	case 'x', 'X', 'o', 'O', 'b', 'B':
+		return true
+	}
+	return false
+}
+
+// unescapeString resolves the escape sequences of a string literal's body:
+// \t, \n, \r, \", \\, \xNN for the character U+00NN and \u{N...} for any
+// Unicode code point. Unknown escapes are kept as written. If an escape is
+// malformed it returns the escape and false.
+func unescapeString(str string) (string, bool) {
+	if !strings.ContainsRune(str, '\\') {
+		return str, true
+	}
+
+	var out strings.Builder
+
+	for i := 0; i < len(str); i++ {
+		if str[i] != '\\' || i+1 >= len(str) {
+			out.WriteByte(str[i])
+			continue
+		}
+
+		i++
+		switch str[i] {
+		case 't':
+			out.WriteByte('\t')
+		case 'n':
+			out.WriteByte('\n')
+		case 'r':
+			out.WriteByte('\r')
+		case '"':
+			out.WriteByte('"')
+		case '\\':
+			out.WriteByte('\\')
+
+		case 'x':
+			if i+3 > len(str) {
+				return str[i-1:], false
+			}
+
+			value, err := strconv.ParseUint(str[i+1:i+3], 16, 8)
+			if err != nil {
+				return str[i-1 : i+3], false
+			}
+
+			out.WriteRune(rune(value))
+			i += 2
+
+		case 'u':
+			end := strings.IndexByte(str[i:], '}')
+			if i+1 >= len(str) || str[i+1] != '{' || end < 0 {
+				return str[i-1 : i+1], false
+			}
+			end += i
+
+			digits := str[i+2 : end]
+			value, err := strconv.ParseUint(digits, 16, 32)
+			if err != nil || len(digits) > 6 || !utf8.ValidRune(rune(value)) {
+				return str[i-1 : end+1], false
+			}
+
+			out.WriteRune(rune(value))
+			i = end
+
+		default:
+			out.WriteByte('\\')
+			out.WriteByte(str[i])
+		}
+	}
 
-	return str
+	return out.String(), true
 }
@@ -1,21 +1,109 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io/ioutil"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
 	"monkey/repl"
 	"os"
 	"os/user"
 )
 
 func main() {
-	user, err := user.Current()
-	if err != nil {
-		panic(err)
+	engine := flag.String("engine", string(repl.EngineEval), "engine to run programs with: eval or vm")
+	expression := flag.String("e", "", "run the given program instead of a script file")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [script [args...]]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s [flags] -e program [args...]\n\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Without a script or -e, an interactive session is started.\n\n")
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if *engine != string(repl.EngineEval) && *engine != string(repl.EngineVM) {
+		fmt.Fprintf(os.Stderr, "unknown engine %q, expected eval or vm\n", *engine)
+		os.Exit(2)
+	}
+
+	// An empty program given with -e is still a program to run
+	expressionGiven := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "e" {
+			expressionGiven = true
+		}
+	})
+
+	switch {
+	case expressionGiven:
+		os.Exit(runScript("", *expression, flag.Args(), repl.Engine(*engine)))
+
+	case flag.NArg() > 0:
+		filename := flag.Arg(0)
+
+		source, err := ioutil.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		os.Exit(runScript(filename, string(source), flag.Args()[1:], repl.Engine(*engine)))
+
+	default:
+		user, err := user.Current()
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("Hello %s! This is the Monkey programming language!\n", user.Username)
+
+		fmt.Printf("Feel free to type in commands\n")
+
+		os.Exit(repl.Start(os.Stdin, os.Stdout, repl.Engine(*engine)))
+	}
+}
+
+// runScript runs a whole program and returns the status to exit with: the
+// code passed to exit, 1 if the program could not be parsed, failed with an
+// error or was halted, and 0 otherwise. The script's arguments are available to it as
+// the array args.
+func runScript(filename, source string, args []string, engine repl.Engine) int {
+	p := parser.New(lexer.NewWithFilename(source, filename))
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, msg := range p.Errors() {
+			fmt.Fprintln(os.Stderr, msg)
+		}
+
+		return 1
+	}
+
+	elements := make([]object.Object, len(args))
+	for i, arg := range args {
+		elements[i] = &object.String{Value: arg}
 	}
 
-	fmt.Printf("Hello %s! This is the Monkey programming language!\n", user.Username)
+	run := repl.NewRunner(engine, map[string]object.Object{
+		"args": &object.Array{Elements: elements},
+	})
 
-	fmt.Printf("Feel free to type in commands\n")
+	switch result := run(program).(type) {
+	case *object.Exit:
+		return int(result.Code)
+
+	case *object.Error:
+		fmt.Fprintln(os.Stderr, result.Trace())
+		return 1
+
+	case *object.Halt:
+		fmt.Fprintln(os.Stderr, result.Inspect())
+		return 1
+	}
 
-	repl.Start(os.Stdin, os.Stdout)
+	return 0
 }
@@ -31,7 +31,7 @@ func (e *Environment) Get(name string) (Object, bool) {
 // Creates a new variable, shadowing a variable in the outer scope if applicable.
 func (e *Environment) Add(name string, val Object) Object {
 	if _, ok := e.store[name]; ok {
-		return &Error{fmt.Sprintf("identifier already exists: %s", name)}
+		return &Error{Message: fmt.Sprintf("identifier already exists: %s", name)}
 	}
 
 	return e.AddOrSet(name, val)
@@ -45,7 +45,7 @@ func (e *Environment) Set(name string, val Object) Object {
 			return e.outer.Set(name, val)
 		}
 
-		return &Error{fmt.Sprintf("identifier not found: %s", name)}
+		return &Error{Message: fmt.Sprintf("identifier not found: %s", name)}
 	}
 
 	return e.AddOrSet(name, val)
@@ -54,7 +54,7 @@ func (e *Environment) Set(name string, val Object) Object {
 // Creates or updates a variable in the immediate scope, shadowing a variable in the outer scope if applicable.
 func (e *Environment) AddOrSet(name string, val Object) Object {
 	if val == nil {
-		return &Error{"cannot assign empty value to variable"}
+		return &Error{Message: "cannot assign empty value to variable"}
 	}
 
 	e.store[name] = val
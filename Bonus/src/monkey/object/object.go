@@ -4,7 +4,12 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math"
 	"monkey/ast"
+	"monkey/code"
+	"monkey/token"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -12,15 +17,27 @@ type ObjectType string
 
 const (
 	INTEGER_OBJ      = "INTEGER"
+	FLOAT_OBJ        = "FLOAT"
 	STRING_OBJ       = "STRING"
 	BOOLEAN_OBJ      = "BOOLEAN"
 	NULL_OBJ         = "NULL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
 	ERROR_OBJ        = "ERROR"
+	ERROR_VALUE_OBJ  = "ERROR_VALUE"
 	FUNCTION_OBJ     = "FUNCTION"
 	BUILTIN_OBJ      = "BUILTIN"
 	ARRAY_OBJ        = "ARRAY"
 	HASH_OBJ         = "HASH"
+	EXIT_OBJ         = "EXIT"
+	HALT_OBJ         = "HALT"
+	BREAK_OBJ        = "BREAK"
+	CONTINUE_OBJ     = "CONTINUE"
+	RANGE_OBJ        = "RANGE"
+	ITERATOR_OBJ     = "ITERATOR"
+	TAIL_CALL_OBJ    = "TAIL_CALL"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	MATCH_PATTERN_OBJ     = "MATCH_PATTERN"
 )
 
 type Object interface {
@@ -35,6 +52,29 @@ type Integer struct {
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
+// Inspect prints the shortest representation that reads back as the same
+// value, always marked as a float: 2.0 rather than 2. Very large and very
+// small magnitudes use exponent notation.
+func (f *Float) Inspect() string {
+	format := byte('f')
+	if abs := math.Abs(f.Value); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+
+	s := strconv.FormatFloat(f.Value, format, -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+
+	return s
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -61,14 +101,131 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// Error unwinds evaluation until a try expression catches it, or up to the
+// host. It is raised by the interpreter when something goes wrong, and by
+// scripts with throw.
 type Error struct {
 	Message string
+	Kind    string       // chosen by the script that threw it, empty for the interpreter's own errors
+	Cause   error        // set by the interpreter for errors a host may need to tell apart, never by scripts
+	Span    token.Span   // where the error was raised, if known
+	Stack   []StackFrame // the calls active where it was raised, innermost first, nil until it is raised
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
-func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
+func (e *Error) Inspect() string {
+	message := e.Message
+	if e.Kind != "" {
+		message = e.Kind + ": " + message
+	}
+
+	if e.Span.IsValid() {
+		return "ERROR: " + e.Span.String() + ": " + message
+	}
+
+	return "ERROR: " + message
+}
+
+// Trace is Inspect followed by the calls that were active when the error was
+// raised, innermost first. Frames repeated by recursion are counted rather
+// than printed again. An error raised outside any function has no trace.
+func (e *Error) Trace() string {
+	if len(e.Stack) < 2 {
+		return e.Inspect()
+	}
+
+	var out bytes.Buffer
+	out.WriteString(e.Inspect())
+
+	for i := 0; i < len(e.Stack); {
+		frame := e.Stack[i]
+
+		repeats := 0
+		for i++; i < len(e.Stack) && e.Stack[i] == frame; i++ {
+			repeats++
+		}
+
+		out.WriteString("\n    at " + frame.String())
+		if repeats > 0 {
+			out.WriteString(fmt.Sprintf("\n    ... repeated %d more times", repeats))
+		}
+	}
+
+	return out.String()
+}
+
+// StackFrame is one of the calls active when an error was raised: the name of
+// the function called and the position it had reached, which is unset for a
+// builtin.
+type StackFrame struct {
+	Function string
+	Span     token.Span
+}
+
+func (sf StackFrame) String() string {
+	if sf.Span.IsValid() {
+		return sf.Function + " (" + sf.Span.String() + ")"
+	}
+
+	return sf.Function
+}
+
+// ErrorValue is an error held as an ordinary value, so that it doesn't unwind
+// anything: one caught by a try expression, or one made by the error builtin.
+// Throwing it raises the error again.
+type ErrorValue struct {
+	Err *Error
+}
+
+func (ev *ErrorValue) Type() ObjectType { return ERROR_VALUE_OBJ }
+func (ev *ErrorValue) Inspect() string  { return ev.Err.Inspect() }
+
+// Exit is produced by the exit builtin. It unwinds evaluation like an error
+// does, and tells the host which status to exit with.
+type Exit struct {
+	Code int64
+}
+
+func (e *Exit) Type() ObjectType { return EXIT_OBJ }
+func (e *Exit) Inspect() string  { return fmt.Sprintf("exit(%d)", e.Code) }
+
+// Halt stops an evaluation that has run past the limits the host gave it, or
+// that the host has cancelled. Like Exit it unwinds evaluation at once: it
+// can't be caught, and finally blocks don't run.
+type Halt struct {
+	Err  error      // why evaluation stopped
+	Span token.Span // where it had got to
+}
+
+func (h *Halt) Type() ObjectType { return HALT_OBJ }
+func (h *Halt) Inspect() string {
+	if h.Span.IsValid() {
+		return "HALT: " + h.Span.String() + ": " + h.Err.Error()
+	}
+
+	return "HALT: " + h.Err.Error()
+}
+
+// Break and Continue carry a break or continue statement out to the loop it
+// applies to. Label is empty for the innermost loop. Value becomes the value
+// of the loop.
+type Break struct {
+	Label string
+	Value Object
+}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+func (b *Break) Inspect() string  { return "break" }
+
+type Continue struct {
+	Label string
+}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+func (c *Continue) Inspect() string  { return "continue" }
 
 type Function struct {
+	Name       string // the name it was declared or first bound with, empty if it has none
 	Parameters []*ast.FunctionParameter
 	Body       *ast.BlockStatement
 	Env        *Environment
@@ -76,27 +233,109 @@ type Function struct {
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
 func (f *Function) Inspect() string {
+	return inspectFunction(f.Name, f.Parameters, f.Body)
+}
+
+// inspectFunction shows a named function by its name and parameters, and an
+// anonymous one in full.
+func inspectFunction(name string, parameters []*ast.FunctionParameter, body *ast.BlockStatement) string {
 	var out bytes.Buffer
 
 	params := []string{}
-	for _, p := range f.Parameters {
+	for _, p := range parameters {
 		params = append(params, p.String())
 	}
 
 	out.WriteString("fn")
+	if name != "" {
+		out.WriteString(" " + name)
+	}
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
+
+	if name != "" {
+		out.WriteString(")")
+		return out.String()
+	}
+
 	out.WriteString(") {\n")
-	out.WriteString(f.Body.String())
+	out.WriteString(body.String())
 	out.WriteString("\n}")
 
 	return out.String()
 }
 
+// CompiledFunction is the bytecode for a function literal, or for a whole
+// program when Body is nil. Closures are created from it at runtime.
+type CompiledFunction struct {
+	Instructions code.Instructions
+	SourceMap    code.SourceMap
+	NumLocals    int
+	LocalNames   []string // by slot, for error messages
+	Captures     []Capture
+
+	Name       string
+	Parameters []*ast.FunctionParameter
+	Body       *ast.BlockStatement
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// MatchPattern carries the pattern of a match arm to the VM, which matches
+// values against it at run time as the evaluator does.
+type MatchPattern struct {
+	Pattern ast.Pattern
+}
+
+func (mp *MatchPattern) Type() ObjectType { return MATCH_PATTERN_OBJ }
+func (mp *MatchPattern) Inspect() string  { return mp.Pattern.String() }
+
+// Capture says where a new closure finds one of its free variables: a local
+// slot of the frame creating it, or a free variable of the enclosing closure.
+type Capture struct {
+	Local bool
+	Index int
+	Name  string
+}
+
+// Closure is a compiled function together with the variables it captured.
+// It reports itself as a FUNCTION so that programs behave the same whichever
+// engine runs them.
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []*Cell
+	Name string // the name the closure was first bound with, if Fn has none
+}
+
+func (c *Closure) Type() ObjectType { return FUNCTION_OBJ }
+func (c *Closure) Inspect() string {
+	return inspectFunction(c.FunctionName(), c.Fn.Parameters, c.Fn.Body)
+}
+
+// FunctionName is the name the closure's function was declared or first
+// bound with, or "" if it has none.
+func (c *Closure) FunctionName() string {
+	if c.Fn.Name != "" {
+		return c.Fn.Name
+	}
+
+	return c.Name
+}
+
+// Cell holds a variable that can be shared between a frame and the closures
+// created in it. A nil Value means the variable has not been defined yet.
+type Cell struct {
+	Value Object
+}
+
 type BuiltinFunction func(args ...Object) Object
 
 type Builtin struct {
-	Fn BuiltinFunction
+	Name string
+	Fn   BuiltinFunction
 }
 
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
@@ -107,19 +346,55 @@ type Array struct {
 }
 
 func (a *Array) Type() ObjectType { return ARRAY_OBJ }
-func (a *Array) Inspect() string {
-	var out bytes.Buffer
-	elements := []string{}
+func (a *Array) Inspect() string  { return inspectNested(a, map[Object]bool{}) }
+
+// Range is the lazy sequence of integers from Start up to, but not including,
+// End, going up (or down, for a negative step) by Step.
+type Range struct {
+	Start int64
+	End   int64
+	Step  int64
+}
 
-	for _, e := range a.Elements {
-		elements = append(elements, e.Inspect())
+func (r *Range) Type() ObjectType { return RANGE_OBJ }
+func (r *Range) Inspect() string {
+	return fmt.Sprintf("range(%d, %d, %d)", r.Start, r.End, r.Step)
+}
+
+// Len returns the number of integers in the range, or math.MaxInt64 for a
+// range too long to count, see Count.
+func (r *Range) Len() int64 {
+	n, ok := r.Count()
+	if !ok {
+		return math.MaxInt64
 	}
 
-	out.WriteString("[")
-	out.WriteString(strings.Join(elements, ", "))
-	out.WriteString("]")
+	return n
+}
 
-	return out.String()
+// Count returns the number of integers in the range, and false if there are
+// more than an int64 can hold, which can happen when the range spans most of
+// the integers with a step of 1 or -1.
+func (r *Range) Count() (int64, bool) {
+	var span, step uint64
+	if r.Step > 0 && r.End > r.Start {
+		span, step = uint64(r.End-r.Start), uint64(r.Step)
+	} else if r.Step < 0 && r.End < r.Start {
+		span, step = uint64(r.Start-r.End), uint64(-r.Step)
+	} else {
+		return 0, true
+	}
+
+	n := span / step
+	if span%step != 0 {
+		n++
+	}
+
+	if n > math.MaxInt64 {
+		return 0, false
+	}
+
+	return int64(n), true
 }
 
 type Hash struct {
@@ -127,20 +402,89 @@ type Hash struct {
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
-func (h *Hash) Inspect() string {
+func (h *Hash) Inspect() string  { return inspectNested(h, map[Object]bool{}) }
+
+// inspectNested is Inspect for arrays and hashes, which assigning to their
+// elements can make hold themselves. One already being inspected further out
+// is shown as [...] or {...} rather than inspected again without end.
+func inspectNested(obj Object, outer map[Object]bool) string {
 	var out bytes.Buffer
 
-	pairs := []string{}
+	switch obj := obj.(type) {
+	case *Array:
+		if outer[obj] {
+			return "[...]"
+		}
+
+		outer[obj] = true
+		defer delete(outer, obj)
+
+		elements := []string{}
+
+		for _, e := range obj.Elements {
+			elements = append(elements, inspectNested(e, outer))
+		}
+
+		out.WriteString("[")
+		out.WriteString(strings.Join(elements, ", "))
+		out.WriteString("]")
+
+	case *Hash:
+		if outer[obj] {
+			return "{...}"
+		}
+
+		outer[obj] = true
+		defer delete(outer, obj)
 
+		pairs := []string{}
+
+		for _, pair := range obj.SortedPairs() {
+			pairs = append(pairs, pair.Key.Inspect()+":"+inspectNested(pair.Value, outer))
+		}
+
+		out.WriteString("{")
+		out.WriteString(strings.Join(pairs, ", "))
+		out.WriteString("}")
+
+	default:
+		return obj.Inspect()
+	}
+
+	return out.String()
+}
+
+// SortedPairs returns the pairs ordered by key, so that printing and
+// iterating a hash always see the same order. Keys of different types are
+// grouped by type: booleans, then integers, then strings.
+func (h *Hash) SortedPairs() []HashPair {
+	pairs := make([]HashPair, 0, len(h.Pairs))
 	for _, pair := range h.Pairs {
-		pairs = append(pairs, pair.Key.Inspect()+":"+pair.Value.Inspect())
+		pairs = append(pairs, pair)
 	}
 
-	out.WriteString("{")
-	out.WriteString(strings.Join(pairs, ", "))
-	out.WriteString("}")
+	sort.Slice(pairs, func(i, j int) bool {
+		return lessHashKey(pairs[i].Key, pairs[j].Key)
+	})
 
-	return out.String()
+	return pairs
+}
+
+func lessHashKey(a, b Object) bool {
+	if a.Type() != b.Type() {
+		return a.Type() < b.Type()
+	}
+
+	switch a := a.(type) {
+	case *Boolean:
+		return !a.Value && b.(*Boolean).Value
+	case *Integer:
+		return a.Value < b.(*Integer).Value
+	case *String:
+		return a.Value < b.(*String).Value
+	default:
+		return false
+	}
 }
 
 type HashPair struct {
@@ -1,6 +1,171 @@
 package object
 
-import "testing"
+import (
+	"errors"
+	"math"
+	"monkey/token"
+	"testing"
+)
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{2, "2.0"},
+		{3.14, "3.14"},
+		{-0.5, "-0.5"},
+		{0.1, "0.1"},
+		{1e6, "1000000.0"},
+		{1e21, "1e+21"},
+		{1e-7, "1e-07"},
+		{math.Inf(1), "+Inf"},
+		{math.NaN(), "NaN"},
+	}
+
+	for _, tt := range tests {
+		f := &Float{Value: tt.value}
+		if f.Inspect() != tt.expected {
+			t.Errorf("wrong Inspect for %v. expected=%q, got=%q", tt.value, tt.expected, f.Inspect())
+		}
+	}
+}
+
+func TestHashInspectIsSorted(t *testing.T) {
+	hash := &Hash{Pairs: map[HashKey]HashPair{}}
+
+	keys := []Object{
+		&String{Value: "b"},
+		&Integer{Value: 10},
+		&Boolean{Value: true},
+		&String{Value: "a"},
+		&Integer{Value: -1},
+		&Boolean{Value: false},
+	}
+	for _, key := range keys {
+		hash.Pairs[key.(Hashable).HashKey()] = HashPair{Key: key, Value: &Integer{Value: 0}}
+	}
+
+	expected := "{false:0, true:0, -1:0, 10:0, a:0, b:0}"
+	if hash.Inspect() != expected {
+		t.Errorf("wrong Inspect. expected=%q, got=%q", expected, hash.Inspect())
+	}
+}
+
+func TestInspectSelfReference(t *testing.T) {
+	array := &Array{}
+	array.Elements = []Object{&Integer{Value: 1}, array}
+
+	hash := &Hash{Pairs: map[HashKey]HashPair{}}
+	key := &String{Value: "self"}
+	hash.Pairs[key.HashKey()] = HashPair{Key: key, Value: hash}
+
+	shared := &Array{Elements: []Object{&Integer{Value: 1}}}
+
+	tests := []struct {
+		obj      Object
+		expected string
+	}{
+		{array, "[1, [...]]"},
+		{hash, "{self:{...}}"},
+		{&Array{Elements: []Object{hash, array}}, "[{self:{...}}, [1, [...]]]"},
+		{&Array{Elements: []Object{shared, shared}}, "[[1], [1]]"},
+	}
+
+	for _, tt := range tests {
+		if tt.obj.Inspect() != tt.expected {
+			t.Errorf("wrong Inspect. expected=%q, got=%q", tt.expected, tt.obj.Inspect())
+		}
+	}
+}
+
+func TestErrorInspect(t *testing.T) {
+	span := token.Span{Start: token.Position{Line: 2, Column: 5}}
+
+	tests := []struct {
+		err      *Error
+		expected string
+	}{
+		{&Error{Message: "division by zero"}, "ERROR: division by zero"},
+		{&Error{Message: "division by zero", Span: span}, "ERROR: 2:5: division by zero"},
+		{&Error{Message: "no port", Kind: "ConfigError", Span: span}, "ERROR: 2:5: ConfigError: no port"},
+	}
+
+	for _, tt := range tests {
+		if tt.err.Inspect() != tt.expected {
+			t.Errorf("wrong Inspect. expected=%q, got=%q", tt.expected, tt.err.Inspect())
+		}
+	}
+}
+
+func TestHaltInspect(t *testing.T) {
+	span := token.Span{Start: token.Position{Line: 3, Column: 1}}
+
+	tests := []struct {
+		halt     *Halt
+		expected string
+	}{
+		{&Halt{Err: errors.New("step limit exceeded")}, "HALT: step limit exceeded"},
+		{&Halt{Err: errors.New("step limit exceeded"), Span: span}, "HALT: 3:1: step limit exceeded"},
+	}
+
+	for _, tt := range tests {
+		if tt.halt.Inspect() != tt.expected {
+			t.Errorf("wrong Inspect. expected=%q, got=%q", tt.expected, tt.halt.Inspect())
+		}
+	}
+}
+
+func TestErrorTrace(t *testing.T) {
+	at := func(line, column int) token.Span {
+		return token.Span{Start: token.Position{Line: line, Column: column}}
+	}
+
+	tests := []struct {
+		err      *Error
+		expected string
+	}{
+		{&Error{Message: "bad"}, "ERROR: bad"},
+		{&Error{Message: "bad", Span: at(1, 3), Stack: []StackFrame{{"<main>", at(1, 3)}}}, "ERROR: 1:3: bad"},
+		{
+			&Error{Message: "bad", Span: at(2, 3), Stack: []StackFrame{{"len", token.Span{}}, {"f", at(2, 3)}, {"<main>", at(5, 1)}}},
+			"ERROR: 2:3: bad\n    at len\n    at f (2:3)\n    at <main> (5:1)",
+		},
+		{
+			&Error{Message: "bad", Span: at(1, 9), Stack: []StackFrame{{"f", at(1, 9)}, {"f", at(1, 20)}, {"f", at(1, 20)}, {"f", at(1, 20)}, {"<main>", at(2, 1)}}},
+			"ERROR: 1:9: bad\n    at f (1:9)\n    at f (1:20)\n    ... repeated 2 more times\n    at <main> (2:1)",
+		},
+	}
+
+	for _, tt := range tests {
+		if tt.err.Trace() != tt.expected {
+			t.Errorf("wrong Trace. expected=%q, got=%q", tt.expected, tt.err.Trace())
+		}
+	}
+}
+
+func TestRangeLen(t *testing.T) {
+	tests := []struct {
+		r        Range
+		expected int64
+	}{
+		{Range{Start: 0, End: 10, Step: 1}, 10},
+		{Range{Start: 0, End: 10, Step: 3}, 4},
+		{Range{Start: 10, End: 0, Step: -5}, 2},
+		{Range{Start: 0, End: 10, Step: -1}, 0},
+		{Range{Start: 5, End: 5, Step: 1}, 0},
+		{Range{Start: math.MinInt64, End: math.MaxInt64, Step: math.MaxInt64}, 3},
+		{Range{Start: 0, End: math.MaxInt64, Step: 1}, math.MaxInt64},
+		{Range{Start: -math.MaxInt64, End: math.MaxInt64, Step: 1}, math.MaxInt64},
+		{Range{Start: math.MaxInt64, End: math.MinInt64, Step: -1}, math.MaxInt64},
+	}
+
+	for _, tt := range tests {
+		if tt.r.Len() != tt.expected {
+			t.Errorf("wrong Len for %s. expected=%d, got=%d", tt.r.Inspect(), tt.expected, tt.r.Len())
+		}
+	}
+}
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -0,0 +1,79 @@
+package parser
+
+import (
+	"fmt"
+	"monkey/token"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityInfo
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityInfo:
+		return "info"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// MarshalText lets severities appear by name when diagnostics are encoded as JSON.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Diagnostic codes are stable identifiers for tools that filter or suppress
+// specific problems. Messages may change, codes should not.
+const (
+	CodeUnexpectedToken    = "unexpected-token"
+	CodeExpectedExpression = "expected-expression"
+	CodeIllegalToken       = "illegal-token"
+	CodeInvalidEscape      = "invalid-escape"
+	CodeInvalidInteger     = "invalid-integer"
+	CodeIntegerOverflow    = "integer-overflow"
+	CodeInvalidFloat       = "invalid-float"
+	CodeUnclosedBlock      = "unclosed-block"
+	CodeOutsideLoop        = "outside-loop"
+	CodeUndefinedLabel     = "undefined-label"
+	CodeArgumentOrder      = "argument-order"
+)
+
+type Diagnostic struct {
+	Severity Severity      `json:"severity"`
+	Code     string        `json:"code"`
+	Message  string        `json:"message"`
+	Span     token.Span    `json:"span"`
+	Related  []RelatedSpan `json:"related,omitempty"`
+	Fix      *SuggestedFix `json:"fix,omitempty"`
+}
+
+func (d Diagnostic) String() string {
+	if d.Severity == SeverityError {
+		return fmt.Sprintf("%s: %s", d.Span.Start, d.Message)
+	}
+
+	return fmt.Sprintf("%s: %s: %s", d.Span.Start, d.Severity, d.Message)
+}
+
+// RelatedSpan points at another location that helps explain a diagnostic.
+type RelatedSpan struct {
+	Span    token.Span `json:"span"`
+	Message string     `json:"message"`
+}
+
+// SuggestedFix describes an edit that would resolve a diagnostic: the text in
+// Span is replaced with Replacement. An empty span is an insertion.
+type SuggestedFix struct {
+	Description string     `json:"description"`
+	Span        token.Span `json:"span"`
+	Replacement string     `json:"replacement"`
+}
@@ -2,48 +2,93 @@ package parser
 
 import (
 	"fmt"
+	"math"
 	"monkey/ast"
 	"monkey/lexer"
 	"monkey/token"
 	"strconv"
+	"strings"
 )
 
-// Precedences
+// Precedences. Bitwise operators bind tighter than comparisons, so that
+// `x & mask == 0` means `(x & mask) == 0`.
 const (
 	_ int = iota
 	LOWEST
 	ASSIGN      // X = 1
+	LOGICAL_OR  // ||
+	LOGICAL_AND // &&
 	EQUALS      // ==
 	LESSGREATER // < or >
+	BIT_OR      // |
+	BIT_XOR     // ^
+	BIT_AND     // &
+	SHIFT       // << or >>
 	SUM         // +
 	PRODUCT     // *
 	PREFIX      // -X or !X
+	POWER       // X ** Y
 	CALL        // myFunction()
 	INDEX       // array[index]
+	POSTFIX     // X++ or X--
 )
 
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
-	token.ASSIGN:   ASSIGN,
-	token.LBRACKET: INDEX,
+	token.OR:          LOGICAL_OR,
+	token.AND:         LOGICAL_AND,
+	token.EQ:          EQUALS,
+	token.NOT_EQ:      EQUALS,
+	token.LT:          LESSGREATER,
+	token.GT:          LESSGREATER,
+	token.LT_EQ:       LESSGREATER,
+	token.GT_EQ:       LESSGREATER,
+	token.PIPE:        BIT_OR,
+	token.CARET:       BIT_XOR,
+	token.AMPERSAND:   BIT_AND,
+	token.SHIFT_LEFT:  SHIFT,
+	token.SHIFT_RIGHT: SHIFT,
+	token.PLUS:        SUM,
+	token.MINUS:       SUM,
+	token.SLASH:       PRODUCT,
+	token.ASTERISK:    PRODUCT,
+	token.PERCENT:     PRODUCT,
+	token.POWER:       POWER,
+	token.LPAREN:      CALL,
+	token.LBRACKET:    INDEX,
+	token.INCREMENT:   POSTFIX,
+	token.DECREMENT:   POSTFIX,
+
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+	token.PERCENT_ASSIGN:  ASSIGN,
 }
 
 type Parser struct {
 	l *lexer.Lexer
 
-	errors []string
+	diagnostics []Diagnostic
+	// Set after an error is reported until the parser resynchronises at the
+	// next statement. Further errors are suppressed while it is set so that
+	// a single mistake doesn't cascade into many diagnostics.
+	recovering bool
+	errorIndex int // index of the token the last error was reported at
+
+	// Every token read so far, along with the braces and parens open before
+	// it, so that recovery can rewind to where an error was found
+	tokens  []token.Token
+	nesting []nesting
+	index   int // index of curToken in tokens
 
 	curToken  token.Token
 	peekToken token.Token
 
+	// Labels of the loops around the statement being parsed, innermost last,
+	// with "" for loops without one. Function bodies start with none.
+	loopLabels []string
+
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
 }
@@ -53,25 +98,40 @@ type (
 	infixParseFn  func(ast.Expression) ast.Expression
 )
 
+type nesting struct {
+	braces int
+	parens int
+}
+
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
-		l:      l,
-		errors: []string{},
+		l:           l,
+		diagnostics: []Diagnostic{},
+		index:       -1,
 	}
 
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
+	p.registerPrefix(token.TILDE, p.parsePrefixExpression)
+	p.registerPrefix(token.INCREMENT, p.parsePrefixExpression)
+	p.registerPrefix(token.DECREMENT, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
+	p.registerPrefix(token.FOR, p.parseLoopExpression)
+	p.registerPrefix(token.WHILE, p.parseLoopExpression)
+	p.registerPrefix(token.DO, p.parseLoopExpression)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
@@ -82,23 +142,85 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.POWER, p.parseInfixExpression)
+	p.registerInfix(token.AMPERSAND, p.parseInfixExpression)
+	p.registerInfix(token.PIPE, p.parseInfixExpression)
+	p.registerInfix(token.CARET, p.parseInfixExpression)
+	p.registerInfix(token.SHIFT_LEFT, p.parseInfixExpression)
+	p.registerInfix(token.SHIFT_RIGHT, p.parseInfixExpression)
 	p.registerInfix(token.ASSIGN, p.parseInfixExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseInfixExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseInfixExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseInfixExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT_ASSIGN, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.INCREMENT, p.parsePostfixExpression)
+	p.registerInfix(token.DECREMENT, p.parsePostfixExpression)
 
-	p.nextToken()
 	p.nextToken()
 
 	return p
 }
 
+// Errors returns the messages of all error diagnostics, prefixed with their position.
 func (p *Parser) Errors() []string {
-	return p.errors
+	errors := []string{}
+	for _, d := range p.diagnostics {
+		if d.Severity == SeverityError {
+			errors = append(errors, d.String())
+		}
+	}
+
+	return errors
+}
+
+func (p *Parser) Diagnostics() []Diagnostic {
+	return p.diagnostics
 }
 
 func (p *Parser) nextToken() {
-	p.curToken = p.peekToken
-	p.peekToken = p.l.NextToken()
+	p.index++
+	for len(p.tokens) <= p.index+1 {
+		p.readToken()
+	}
+
+	p.curToken = p.tokens[p.index]
+	p.peekToken = p.tokens[p.index+1]
+}
+
+func (p *Parser) readToken() {
+	var level nesting
+
+	if n := len(p.tokens); n > 0 {
+		level = p.nesting[n-1]
+
+		switch p.tokens[n-1].Type {
+		case token.LBRACE:
+			level.braces++
+		case token.RBRACE:
+			level.braces--
+		case token.LPAREN:
+			level.parens++
+		case token.RPAREN:
+			level.parens--
+		}
+	}
+
+	p.tokens = append(p.tokens, p.l.NextToken())
+	p.nesting = append(p.nesting, level)
+}
+
+// seek moves back to a token that has already been read.
+func (p *Parser) seek(index int) {
+	p.index = index - 1
+	p.nextToken()
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -106,10 +228,19 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program.Statements = []ast.Statement{}
 
 	for p.curToken.Type != token.EOF {
+		start := p.index
+
 		statement := p.parseStatement()
 		if statement != nil {
 			program.Statements = append(program.Statements, statement)
 		}
+
+		if p.recovering {
+			// A '}' at the top level has no block to close, it is skipped along
+			// with the rest of the broken statement
+			p.synchronize(start)
+		}
+
 		p.nextToken()
 	}
 
@@ -117,42 +248,78 @@ func (p *Parser) ParseProgram() *ast.Program {
 }
 
 func (p *Parser) parseStatement() ast.Statement {
-	var stmt ast.Statement
+	// Each case checks for nil separately so that a failed parse returns an
+	// untyped nil rather than a nil pointer wrapped in the interface
 	switch p.curToken.Type {
 	case token.LET:
-		stmt = p.parseLetStatement()
+		if stmt := p.parseLetStatement(); stmt != nil {
+			return stmt
+		}
 	case token.RETURN:
-		stmt = p.parseReturnStatement()
-	case token.FOR:
-		stmt = p.parseForLoopStatement()
-	default:
-		stmt = p.parseExpressionStatement()
-	}
+		if stmt := p.parseReturnStatement(); stmt != nil {
+			return stmt
+		}
+	case token.THROW:
+		if stmt := p.parseThrowStatement(); stmt != nil {
+			return stmt
+		}
+	case token.FUNCTION:
+		// Only a name after fn makes a declaration, otherwise it begins a
+		// function literal
+		if p.peekTokenIs(token.IDENT) {
+			if stmt := p.parseFunctionStatement(); stmt != nil {
+				return stmt
+			}
+			break
+		}
 
-	// Because Go's compiler is dumb and will warn this never returns nil without this
-	if stmt == nil {
-		return nil
+		if stmt := p.parseExpressionStatement(); stmt != nil {
+			return stmt
+		}
+	case token.FOR, token.WHILE, token.DO:
+		if stmt := p.parseLoopStatement(nil); stmt != nil {
+			return stmt
+		}
+	case token.BREAK:
+		if stmt := p.parseBreakStatement(); stmt != nil {
+			return stmt
+		}
+	case token.CONTINUE:
+		if stmt := p.parseContinueStatement(); stmt != nil {
+			return stmt
+		}
+	case token.IDENT:
+		if p.peekTokenIs(token.COLON) {
+			if stmt := p.parseLabeledStatement(); stmt != nil {
+				return stmt
+			}
+			break
+		}
+		fallthrough
+	default:
+		if stmt := p.parseExpressionStatement(); stmt != nil {
+			return stmt
+		}
 	}
 
-	return stmt
+	return nil
 }
 
 func (p *Parser) parseLetStatement() *ast.LetStatement {
 	statement := &ast.LetStatement{Token: p.curToken}
 
-	if !p.expectPeek(token.IDENT) {
+	statement.Name = p.expectPattern()
+	if statement.Name == nil {
 		return nil
 	}
 
-	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	statement.Name = ident
-
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
 	}
 
 	p.nextToken()
 	statement.Value = p.parseExpression(LOWEST)
+	nameFunction(statement.Name, statement.Value)
 
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
@@ -161,6 +328,37 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	return statement
 }
 
+func (p *Parser) parseFunctionStatement() *ast.FunctionStatement {
+	statement := &ast.FunctionStatement{Token: p.curToken}
+
+	p.nextToken()
+	statement.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	statement.Function = &ast.FunctionLiteral{Token: statement.Token, Name: statement.Name.Value}
+	if !p.parseFunction(statement.Function) {
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return statement
+}
+
+// nameFunction gives a function literal bound straight to a variable, by let
+// or by assignment, the name of that variable.
+func nameFunction(target ast.Node, value ast.Expression) {
+	name, ok := target.(*ast.Identifier)
+	if !ok {
+		return
+	}
+
+	if function, ok := value.(*ast.FunctionLiteral); ok && function.Name == "" {
+		function.Name = name.Value
+	}
+}
+
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	statement := &ast.ReturnStatement{Token: p.curToken}
 
@@ -174,20 +372,253 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return statement
 }
 
-func (p *Parser) parseForLoopStatement() *ast.ForLoopStatement {
-	statement := &ast.ForLoopStatement{Token: p.curToken}
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+	statement := &ast.ThrowStatement{Token: p.curToken}
+
+	p.nextToken()
+	statement.Value = p.parseExpression(LOWEST)
+	if statement.Value == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return statement
+}
+
+// parseLabeledStatement parses `label: for (...) { ... }`. Only loops can be
+// labelled.
+func (p *Parser) parseLabeledStatement() ast.Statement {
+	label := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	p.nextToken()
+	switch p.peekToken.Type {
+	case token.FOR, token.WHILE, token.DO:
+		p.nextToken()
+	default:
+		p.report(p.peekError(token.FOR))
+		return nil
+	}
+
+	if stmt := p.parseLoopStatement(label); stmt != nil {
+		return stmt
+	}
+
+	return nil
+}
+
+// parseLoopStatement parses a loop in statement position, where it may be
+// followed by a semicolon.
+func (p *Parser) parseLoopStatement(label *ast.Identifier) ast.Statement {
+	loop := p.parseLoop(label)
+	if loop == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return loop
+}
+
+func (p *Parser) parseLoopExpression() ast.Expression {
+	loop := p.parseLoop(nil)
+	if loop == nil {
+		return nil
+	}
+
+	return loop.(ast.Expression)
+}
+
+// parseLoop parses any kind of loop, starting at its keyword.
+func (p *Parser) parseLoop(label *ast.Identifier) ast.Statement {
+	switch p.curToken.Type {
+	case token.WHILE:
+		if stmt := p.parseWhileStatement(label); stmt != nil {
+			return stmt
+		}
+	case token.DO:
+		if stmt := p.parseDoWhileStatement(label); stmt != nil {
+			return stmt
+		}
+	default:
+		if stmt := p.parseForStatement(label); stmt != nil {
+			return stmt
+		}
+	}
+
+	return nil
+}
+
+// parseBreakStatement parses break with an optional label and value. An
+// identifier after break is only taken as a label when it names a loop it is
+// in, otherwise it starts the value, so `break i` breaks with the value of i.
+func (p *Parser) parseBreakStatement() *ast.BreakStatement {
+	statement := &ast.BreakStatement{Token: p.curToken}
+
+	label, ok := p.parseLoopControl(true)
+	if !ok {
+		return nil
+	}
+	statement.Label = label
+
+	if !endsStatement(p.peekToken) {
+		p.nextToken()
+
+		value := p.parseExpression(LOWEST)
+		if value == nil {
+			return nil
+		}
+		statement.Value = value
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return statement
+}
+
+func (p *Parser) parseContinueStatement() *ast.ContinueStatement {
+	statement := &ast.ContinueStatement{Token: p.curToken}
+
+	label, ok := p.parseLoopControl(false)
+	if !ok {
+		return nil
+	}
+	statement.Label = label
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return statement
+}
+
+// parseLoopControl parses the optional label after break or continue, and
+// checks that there is a loop for it to apply to. When a value may follow,
+// an identifier that isn't a label in scope starts the value instead.
+func (p *Parser) parseLoopControl(valueAllowed bool) (*ast.Identifier, bool) {
+	keyword := p.curToken
+
+	if len(p.loopLabels) == 0 {
+		p.errorAt(keyword, CodeOutsideLoop, "%s outside loop", keyword.Literal)
+		return nil, false
+	}
+
+	if !p.peekTokenIs(token.IDENT) {
+		return nil, true
+	}
+
+	name := p.peekToken
+	if p.isLoopLabel(name.Literal) {
+		p.nextToken()
+		return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}, true
+	}
+
+	if valueAllowed {
+		return nil, true
+	}
+
+	p.nextToken()
+	p.errorAt(p.curToken, CodeUndefinedLabel, "%s to undefined label %s", keyword.Literal, name.Literal)
+	return nil, false
+}
+
+// endsStatement reports whether tok can only come after the end of a
+// statement.
+func endsStatement(tok token.Token) bool {
+	switch tok.Type {
+	case token.SEMICOLON, token.RBRACE, token.EOF:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *Parser) isLoopLabel(name string) bool {
+	for _, label := range p.loopLabels {
+		if label == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseForStatement parses either kind of for loop. A header starting with
+// `x in` or `x, y in` is a for-in loop, anything else is a C style loop.
+func (p *Parser) parseForStatement(label *ast.Identifier) ast.Statement {
+	forToken := p.curToken
 
 	if !p.expectPeek(token.LPAREN) {
 		return nil
 	}
 	p.nextToken()
 
+	isPattern := p.curTokenIs(token.LBRACKET) || p.curTokenIs(token.LBRACE)
+	if isPattern || p.curTokenIs(token.IDENT) && (p.peekTokenIs(token.IN) || p.peekTokenIs(token.COMMA)) {
+		if stmt := p.parseForInStatement(forToken, label); stmt != nil {
+			return stmt
+		}
+		return nil
+	}
+
+	if stmt := p.parseForLoopStatement(forToken, label); stmt != nil {
+		return stmt
+	}
+
+	return nil
+}
+
+func (p *Parser) parseForInStatement(forToken token.Token, label *ast.Identifier) *ast.ForInStatement {
+	statement := &ast.ForInStatement{Token: forToken, Label: label}
+
+	variable := p.parsePattern()
+	if variable == nil {
+		return nil
+	}
+	statement.Variables = []ast.Pattern{variable}
+
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+
+		variable := p.expectPattern()
+		if variable == nil {
+			return nil
+		}
+		statement.Variables = append(statement.Variables, variable)
+	}
+
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+	p.nextToken()
+
+	statement.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	statement.Body = p.parseLoopBody(label)
+
+	return statement
+}
+
+func (p *Parser) parseForLoopStatement(forToken token.Token, label *ast.Identifier) *ast.ForLoopStatement {
+	statement := &ast.ForLoopStatement{Token: forToken, Label: label}
+
 	if !p.curTokenIs(token.SEMICOLON) {
 		statement.InitializeStatement = p.parseStatement()
 
 		if !p.curTokenIs(token.SEMICOLON) {
-			msg := fmt.Sprintf("no semicolon after for loop initialization, found %s.", p.curToken.Type)
-			p.errors = append(p.errors, msg)
+			p.errorAt(p.curToken, CodeUnexpectedToken,
+				"no semicolon after for loop initialization, found %s.", p.curToken.Type)
 			return nil
 		}
 	}
@@ -211,15 +642,67 @@ func (p *Parser) parseForLoopStatement() *ast.ForLoopStatement {
 	}
 
 	p.nextToken()
-	if p.curTokenIs(token.LBRACE) {
-		statement.Body = p.parseBlockStatement()
-	} else {
-		statement.Body = p.parseSingleStatementBlockStatement()
+	statement.Body = p.parseLoopBody(label)
+
+	return statement
+}
+
+func (p *Parser) parseWhileStatement(label *ast.Identifier) *ast.WhileStatement {
+	statement := &ast.WhileStatement{Token: p.curToken, Label: label}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
 	}
+	p.nextToken()
+
+	statement.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	statement.Body = p.parseLoopBody(label)
 
 	return statement
 }
 
+func (p *Parser) parseDoWhileStatement(label *ast.Identifier) *ast.WhileStatement {
+	statement := &ast.WhileStatement{Token: p.curToken, Label: label, IsDoWhile: true}
+
+	p.nextToken()
+	statement.Body = p.parseLoopBody(label)
+
+	if !p.expectPeek(token.WHILE) || !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+
+	statement.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	return statement
+}
+
+func (p *Parser) parseLoopBody(label *ast.Identifier) *ast.BlockStatement {
+	name := ""
+	if label != nil {
+		name = label.Value
+	}
+
+	p.loopLabels = append(p.loopLabels, name)
+	defer func() { p.loopLabels = p.loopLabels[:len(p.loopLabels)-1] }()
+
+	if p.curTokenIs(token.LBRACE) {
+		return p.parseBlockStatement()
+	}
+
+	return p.parseSingleStatementBlockStatement()
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
 
@@ -268,8 +751,25 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+			p.errorAt(p.curToken, CodeIntegerOverflow, "integer literal %s overflows int64 (max %d)", p.curToken.Literal, int64(math.MaxInt64))
+		} else {
+			p.errorAt(p.curToken, CodeInvalidInteger, "%s", malformedIntegerMessage(p.curToken.Literal))
+		}
+		return nil
+	}
+
+	lit.Value = value
+
+	return lit
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		p.errorAt(p.curToken, CodeInvalidFloat, "could not parse %q as float", p.curToken.Literal)
 		return nil
 	}
 
@@ -278,6 +778,48 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	return lit
 }
 
+// malformedIntegerMessage explains why lit, which strconv rejected, isn't a
+// valid integer literal.
+func malformedIntegerMessage(lit string) string {
+	base, name, digits := 10, "decimal", lit
+	if len(lit) > 1 && lit[0] == '0' {
+		switch lit[1] {
+		case 'x', 'X':
+			base, name, digits = 16, "hexadecimal", lit[2:]
+		case 'o', 'O':
+			base, name, digits = 8, "octal", lit[2:]
+		case 'b', 'B':
+			base, name, digits = 2, "binary", lit[2:]
+		default:
+			base, name, digits = 8, "octal", lit[1:]
+		}
+	}
+
+	if strings.Trim(digits, "_") == "" {
+		return fmt.Sprintf("%s literal %s has no digits", name, lit)
+	}
+
+	for _, ch := range digits {
+		if ch != '_' && digitValue(ch) >= base {
+			return fmt.Sprintf("invalid digit %q in %s literal %s", ch, name, lit)
+		}
+	}
+
+	return fmt.Sprintf("'_' must separate successive digits in %s", lit)
+}
+
+func digitValue(ch rune) int {
+	switch {
+	case '0' <= ch && ch <= '9':
+		return int(ch - '0')
+	case 'a' <= ch && ch <= 'z':
+		return int(ch-'a') + 10
+	case 'A' <= ch && ch <= 'Z':
+		return int(ch-'A') + 10
+	}
+	return math.MaxInt32
+}
+
 func (p *Parser) parseStringLiteral() ast.Expression {
 	lit := &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 	return lit
@@ -304,12 +846,29 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	}
 
 	precedence := p.curPrecedence()
+	// ** is right associative: 2 ** 3 ** 2 is 2 ** (3 ** 2)
+	if expression.Token.Type == token.POWER {
+		precedence--
+	}
+
 	p.nextToken()
 	expression.Right = p.parseExpression(precedence)
 
+	if expression.Operator == "=" {
+		nameFunction(expression.Left, expression.Right)
+	}
+
 	return expression
 }
 
+func (p *Parser) parsePostfixExpression(left ast.Expression) ast.Expression {
+	return &ast.PostfixExpression{
+		Token:    p.curToken,
+		Left:     left,
+		Operator: p.curToken.Literal,
+	}
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	boolean := &ast.Boolean{
 		Token: p.curToken,
@@ -320,17 +879,24 @@ func (p *Parser) parseBoolean() ast.Expression {
 }
 
 func (p *Parser) parseGroupedExpression() ast.Expression {
+	open := p.curToken
+
 	p.nextToken()
 	exp := p.parseExpression(LOWEST)
-	if !p.expectPeek(token.RPAREN) {
+	if !p.expectClose(token.RPAREN, open) {
 		return nil
 	}
 	return exp
 }
 
 func (p *Parser) parseArrayLiteral() ast.Expression {
+	if pattern := p.parseAssignmentPattern(); pattern != nil {
+		return pattern
+	}
+
 	array := &ast.ArrayLiteral{Token: p.curToken}
-	array.Elements = p.parseExpressionList(token.RBRACKET)
+	array.Elements = p.parseExpressionList(array.Token, token.RBRACKET)
+	array.EndToken = p.curToken
 
 	return array
 }
@@ -368,7 +934,129 @@ func (p *Parser) parseIfExpression() ast.Expression {
 		}
 	}
 
-	expression.Clauses = clauses
+	expression.Clauses = clauses
+
+	return expression
+}
+
+func (p *Parser) parseMatchExpression() ast.Expression {
+	expression := &ast.MatchExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	expression.Subject = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) || !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+
+		arm := p.parseMatchArm()
+		if arm == nil {
+			return nil
+		}
+
+		expression.Arms = append(expression.Arms, arm)
+
+		// A comma is optional after a braced body
+		if p.nextTokenIf(token.COMMA) || p.peekTokenIs(token.RBRACE) || arm.Body.EndToken.Type == token.RBRACE {
+			continue
+		}
+
+		p.errorAt(p.peekToken, CodeUnexpectedToken, "expected next token to be '%s' or '%s', got %s instead",
+			token.RBRACE, token.COMMA, p.peekToken.Type)
+		return nil
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+
+	expression.EndToken = p.curToken
+
+	return expression
+}
+
+func (p *Parser) parseMatchArm() *ast.MatchArm {
+	arm := &ast.MatchArm{}
+
+	arm.Pattern = p.parseMatchPattern(false)
+	if arm.Pattern == nil {
+		return nil
+	}
+
+	if p.nextTokenIf(token.IF) {
+		p.nextToken()
+
+		arm.Guard = p.parseExpression(LOWEST)
+		if arm.Guard == nil {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.ARROW) {
+		return nil
+	}
+
+	p.nextToken()
+	if p.curTokenIs(token.LBRACE) {
+		arm.Body = p.parseBlockStatement()
+	} else {
+		arm.Body = p.parseSingleStatementBlockStatement()
+	}
+
+	return arm
+}
+
+// parseTryExpression parses `try { } catch (e) { } finally { }`, where either
+// the catch or the finally may be left out, as may the catch's parameter.
+func (p *Parser) parseTryExpression() ast.Expression {
+	expression := &ast.TryExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	expression.Block = p.parseBlockStatement()
+
+	if p.nextTokenIf(token.CATCH) {
+		if p.nextTokenIf(token.LPAREN) {
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+
+			expression.CatchParameter = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+			if !p.expectPeek(token.RPAREN) {
+				return nil
+			}
+		}
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+
+		expression.Catch = p.parseBlockStatement()
+	}
+
+	if p.nextTokenIf(token.FINALLY) {
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+
+		expression.Finally = p.parseBlockStatement()
+	}
+
+	if expression.Catch == nil && expression.Finally == nil {
+		p.errorAt(p.peekToken, CodeUnexpectedToken, "expected next token to be %s or %s, got %s instead",
+			token.CATCH, token.FINALLY, p.peekToken.Type)
+		return nil
+	}
 
 	return expression
 }
@@ -404,16 +1092,35 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	p.nextToken()
 
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
+		start := p.index
+
 		stmt := p.parseStatement()
 		if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
+
+		if p.recovering && p.synchronize(start) {
+			// Stopped on this block's closing brace
+			break
+		}
+
 		p.nextToken()
 	}
 
 	if p.curTokenIs(token.EOF) {
-		msg := "block statement not closed by RBRACE"
-		p.errors = append(p.errors, msg)
+		p.report(Diagnostic{
+			Severity: SeverityError,
+			Code:     CodeUnclosedBlock,
+			Message:  "block statement not closed by RBRACE",
+			Span:     block.Token.Span(),
+			Fix: &SuggestedFix{
+				Description: "insert '}'",
+				Span:        token.Span{Start: p.curToken.Start, End: p.curToken.Start},
+				Replacement: "}",
+			},
+		})
+	} else {
+		block.EndToken = p.curToken
 	}
 
 	return block
@@ -430,20 +1137,35 @@ func (p *Parser) parseSingleStatementBlockStatement() *ast.BlockStatement {
 
 func (p *Parser) parseFunctionLiteral() ast.Expression {
 	lit := &ast.FunctionLiteral{Token: p.curToken}
+	if !p.parseFunction(lit) {
+		return nil
+	}
+
+	return lit
+}
 
+// parseFunction parses the parameters and body of lit, starting from the token
+// before the opening parenthesis.
+func (p *Parser) parseFunction(lit *ast.FunctionLiteral) bool {
 	if !p.expectPeek(token.LPAREN) {
-		return nil
+		return false
 	}
 
 	lit.Parameters = p.parseFunctionParameters()
 
 	if !p.expectPeek(token.LBRACE) {
-		return nil
+		return false
 	}
 
+	// break and continue can't reach loops outside the function
+	outerLoops := p.loopLabels
+	p.loopLabels = nil
 	lit.Body = p.parseBlockStatement()
+	p.loopLabels = outerLoops
 
-	return lit
+	markTailCalls(lit.Body)
+
+	return true
 }
 
 func (p *Parser) parseFunctionParameters() []*ast.FunctionParameter {
@@ -457,6 +1179,9 @@ func (p *Parser) parseFunctionParameters() []*ast.FunctionParameter {
 	for ok := true; ok; ok = p.nextTokenIf(token.COMMA) {
 		p.nextToken()
 		param := p.parseFunctionParameter()
+		if param == nil {
+			return nil
+		}
 		params = append(params, param)
 
 		// Here we could check if param is variodic and not the last argument, but
@@ -480,67 +1205,403 @@ func (p *Parser) parseFunctionParameter() *ast.FunctionParameter {
 		param.IsVariodic = false
 	}
 
-	param.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if !param.IsVariodic && (p.curTokenIs(token.LBRACKET) || p.curTokenIs(token.LBRACE)) {
+		param.Name = p.parsePattern()
+		if param.Name == nil {
+			return nil
+		}
+	} else {
+		param.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
+	// A variodic parameter defaults to an empty array already
+	if !param.IsVariodic && p.nextTokenIf(token.ASSIGN) {
+		p.nextToken()
+		param.Default = p.parseExpression(LOWEST)
+		if param.Default == nil {
+			return nil
+		}
+	}
 
 	return param
 }
 
-func (p *Parser) parseHashLiteral() ast.Expression {
-	hash := &ast.HashLiteral{Token: p.curToken}
-	hash.Pairs = make(map[ast.Expression]ast.Expression)
+// expectPattern moves on to the pattern starting at the next token, which may
+// be a plain name.
+func (p *Parser) expectPattern() ast.Pattern {
+	switch p.peekToken.Type {
+	case token.IDENT, token.LBRACKET, token.LBRACE:
+		p.nextToken()
+		return p.parsePattern()
+	}
+
+	p.report(p.peekError(token.IDENT))
+	return nil
+}
+
+// parsePattern parses the name, array pattern or hash pattern starting at the
+// current token.
+func (p *Parser) parsePattern() ast.Pattern {
+	// Each case checks for nil separately so that a failed parse returns an
+	// untyped nil rather than a nil pointer wrapped in the interface
+	switch p.curToken.Type {
+	case token.LBRACKET:
+		if pattern := p.parseArrayPattern(false); pattern != nil {
+			return pattern
+		}
+	case token.LBRACE:
+		if pattern := p.parseHashPattern(false); pattern != nil {
+			return pattern
+		}
+	default:
+		return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
+	return nil
+}
+
+// The names of the types a match pattern can test for
+var matchTypes = map[string]bool{
+	"int":    true,
+	"float":  true,
+	"string": true,
+	"bool":   true,
+	"null":   true,
+	"array":  true,
+	"hash":   true,
+	"range":  true,
+	"fn":     true,
+	"error":  true,
+}
+
+// parseMatchPattern parses the pattern of a match arm starting at the current
+// token. Besides names and the array and hash patterns of let, these can be
+// `_`, literals and type names. A type name on its own only tests the type at
+// the top of the pattern, nested inside an array or hash pattern it is a name
+// to bind like any other, so [array, count] binds array. To test a type there
+// the type name needs a name after it, as in [int n, string s].
+func (p *Parser) parseMatchPattern(nested bool) ast.Pattern {
+	switch p.curToken.Type {
+	case token.LBRACKET:
+		if pattern := p.parseArrayPattern(true); pattern != nil {
+			return pattern
+		}
+		return nil
+
+	case token.LBRACE:
+		if pattern := p.parseHashPattern(true); pattern != nil {
+			return pattern
+		}
+		return nil
+
+	case token.INT, token.FLOAT, token.STRING, token.TRUE, token.FALSE:
+		return p.parseLiteralPattern()
+
+	case token.MINUS:
+		if !p.peekTokenIs(token.INT) && !p.peekTokenIs(token.FLOAT) {
+			p.report(p.peekError(token.INT))
+			return nil
+		}
+
+		return p.parseLiteralPattern()
+
+	case token.IDENT, token.FUNCTION:
+		if p.curToken.Literal == "_" {
+			return &ast.WildcardPattern{Token: p.curToken}
+		}
+
+		if !matchTypes[p.curToken.Literal] {
+			return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		}
+
+		if nested && !p.peekTokenIs(token.IDENT) {
+			// fn is a keyword, so unlike the other type names it can't be bound
+			if p.curTokenIs(token.FUNCTION) {
+				p.report(p.peekError(token.IDENT))
+				return nil
+			}
+
+			return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		}
+
+		pattern := &ast.TypePattern{Token: p.curToken}
+		if p.nextTokenIf(token.IDENT) {
+			pattern.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		}
+
+		return pattern
+	}
+
+	p.errorAt(p.curToken, CodeUnexpectedToken, "expected a pattern, got %s instead", p.curToken.Type)
+	return nil
+}
+
+func (p *Parser) parseLiteralPattern() ast.Pattern {
+	// Only the literal itself, a pattern can't be any larger expression
+	value := p.prefixParseFns[p.curToken.Type]()
+	if value == nil {
+		return nil
+	}
+
+	return &ast.LiteralPattern{Value: value}
+}
+
+// expectElementPattern moves on to the pattern for an element of an array or
+// hash pattern, which is a match pattern inside a match pattern.
+func (p *Parser) expectElementPattern(match bool) ast.Pattern {
+	if !match {
+		return p.expectPattern()
+	}
+
+	p.nextToken()
+	return p.parseMatchPattern(true)
+}
+
+// parseElementDefault parses the default of an element of an array or hash
+// pattern. Elements of match patterns have no defaults, if they are missing
+// there is no match.
+func (p *Parser) parseElementDefault(target ast.Pattern, match bool) *ast.PatternElement {
+	if match {
+		return &ast.PatternElement{Target: target}
+	}
+
+	return p.parsePatternDefault(target)
+}
+
+func (p *Parser) parseArrayPattern(match bool) *ast.ArrayPattern {
+	pattern := &ast.ArrayPattern{Token: p.curToken}
+
+	if !p.peekTokenIs(token.RBRACKET) {
+		for ok := true; ok; ok = p.nextTokenIf(token.COMMA) {
+			// The rest of the items can only be collected at the end
+			if p.nextTokenIf(token.ELLIPSIS) {
+				if !p.expectPeek(token.IDENT) {
+					return nil
+				}
+
+				pattern.Rest = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+				break
+			}
+
+			target := p.expectElementPattern(match)
+			if target == nil {
+				return nil
+			}
+
+			element := p.parseElementDefault(target, match)
+			if element == nil {
+				return nil
+			}
+
+			pattern.Elements = append(pattern.Elements, element)
+		}
+	}
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+
+	pattern.EndToken = p.curToken
+
+	return pattern
+}
+
+func (p *Parser) parseHashPattern(match bool) *ast.HashPattern {
+	pattern := &ast.HashPattern{Token: p.curToken}
 
 	for !p.peekTokenIs(token.RBRACE) {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+
+		key := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+		var target ast.Pattern = key
+		if p.nextTokenIf(token.COLON) {
+			if target = p.expectElementPattern(match); target == nil {
+				return nil
+			}
+		}
+
+		value := p.parseElementDefault(target, match)
+		if value == nil {
+			return nil
+		}
+
+		pattern.Pairs = append(pattern.Pairs, &ast.HashPatternPair{Key: key, Value: value})
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+
+	pattern.EndToken = p.curToken
+
+	return pattern
+}
+
+// parsePatternDefault parses the `= value` that may follow a target in an
+// array or hash pattern.
+func (p *Parser) parsePatternDefault(target ast.Pattern) *ast.PatternElement {
+	element := &ast.PatternElement{Target: target}
+
+	if p.nextTokenIf(token.ASSIGN) {
 		p.nextToken()
-		key := p.parseExpression(LOWEST)
 
-		if !p.expectPeek(token.COLON) {
+		element.Default = p.parseExpression(LOWEST)
+		if element.Default == nil {
 			return nil
 		}
+	}
+
+	return element
+}
+
+// parseAssignmentPattern parses the array or hash pattern starting at the
+// current token when it is assigned to, as in `[a, b] = [b, a]`. Until the
+// '=' it can't be told apart from a literal, so if there is no '=' after it
+// the parser backs out to the start without reporting anything and returns
+// nil.
+func (p *Parser) parseAssignmentPattern() ast.Pattern {
+	start := p.index
+	diagnostics, recovering, errorIndex := len(p.diagnostics), p.recovering, p.errorIndex
+
+	pattern := p.parsePattern()
+	if pattern != nil && p.peekTokenIs(token.ASSIGN) {
+		return pattern
+	}
+
+	p.diagnostics = p.diagnostics[:diagnostics]
+	p.recovering, p.errorIndex = recovering, errorIndex
+	p.seek(start)
+
+	return nil
+}
 
+func (p *Parser) parseHashLiteral() ast.Expression {
+	if pattern := p.parseAssignmentPattern(); pattern != nil {
+		return pattern
+	}
+
+	hash := &ast.HashLiteral{Token: p.curToken}
+	hash.Pairs = make(map[ast.Expression]ast.Expression)
+
+	for !p.peekTokenIs(token.RBRACE) {
 		p.nextToken()
-		value := p.parseExpression(LOWEST)
 
-		hash.Pairs[key] = value
+		if p.curTokenIs(token.ELLIPSIS) {
+			spread := p.parseSpreadExpression()
+			if spread == nil {
+				return nil
+			}
+
+			hash.Order = append(hash.Order, spread)
+		} else {
+			key := p.parseExpression(LOWEST)
+
+			if !p.expectPeek(token.COLON) {
+				return nil
+			}
+
+			p.nextToken()
+			value := p.parseExpression(LOWEST)
+
+			hash.Pairs[key] = value
+			hash.Order = append(hash.Order, key)
+		}
 
 		if p.peekTokenIs(token.COMMA) {
 			p.nextToken()
 		}
 
 		if !p.peekTokenIs(token.RBRACE) && !p.curTokenIs(token.COMMA) {
-			msg := fmt.Sprintf("expected next token to be '%s' or '%s', got %s instead",
-				token.RBRACE, token.COMMA, p.peekToken.Type)
-			p.errors = append(p.errors, msg)
+			p.report(Diagnostic{
+				Severity: SeverityError,
+				Code:     CodeUnexpectedToken,
+				Message: fmt.Sprintf("expected next token to be '%s' or '%s', got %s instead",
+					token.RBRACE, token.COMMA, p.peekToken.Type),
+				Span:    p.peekToken.Span(),
+				Related: matching(hash.Token),
+			})
 			return nil
 		}
 	}
 
-	if !p.expectPeek(token.RBRACE) {
+	if !p.expectClose(token.RBRACE, hash.Token) {
 		return nil
 	}
 
+	hash.EndToken = p.curToken
+
 	return hash
 }
 
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
-	exp.Arguments = p.parseExpressionList(token.RPAREN)
+	p.parseCallArguments(exp)
+	exp.EndToken = p.curToken
 	return exp
 }
 
+// parseCallArguments parses the arguments of a call up to the closing ')'.
+// Named arguments, written name: value, go after the positional ones.
+func (p *Parser) parseCallArguments(exp *ast.CallExpression) {
+	exp.Arguments = []ast.Expression{}
+
+	if p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		return
+	}
+
+	for ok := true; ok; ok = p.nextTokenIf(token.COMMA) {
+		p.nextToken()
+
+		if p.curTokenIs(token.IDENT) && p.peekTokenIs(token.COLON) {
+			arg := &ast.NamedArgument{Name: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}}
+			p.nextToken()
+			p.nextToken()
+			arg.Value = p.parseExpression(LOWEST)
+
+			exp.NamedArguments = append(exp.NamedArguments, arg)
+			continue
+		}
+
+		if len(exp.NamedArguments) > 0 {
+			p.errorAt(p.curToken, CodeArgumentOrder, "positional argument after named argument")
+			exp.Arguments, exp.NamedArguments = nil, nil
+			return
+		}
+
+		exp.Arguments = append(exp.Arguments, p.parseElement())
+	}
+
+	if !p.expectClose(token.RPAREN, exp.Token) {
+		exp.Arguments, exp.NamedArguments = nil, nil
+	}
+}
+
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
 
 	p.nextToken()
 	exp.Index = p.parseExpression(LOWEST)
 
-	if !p.expectPeek(token.RBRACKET) {
+	if !p.expectClose(token.RBRACKET, exp.Token) {
 		return nil
 	}
 
+	exp.EndToken = p.curToken
+
 	return exp
 }
 
-func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
+// parseExpressionList parses the items of a list opened by the token open, up
+// to the token end that closes it.
+func (p *Parser) parseExpressionList(open token.Token, end token.TokenType) []ast.Expression {
 	exprs := []ast.Expression{}
 
 	if p.peekTokenIs(end) {
@@ -549,21 +1610,43 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 	}
 
 	p.nextToken()
-	exprs = append(exprs, p.parseExpression(LOWEST))
+	exprs = append(exprs, p.parseElement())
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
-		exprs = append(exprs, p.parseExpression(LOWEST))
+		exprs = append(exprs, p.parseElement())
 	}
 
-	if !p.expectPeek(end) {
+	if !p.expectClose(end, open) {
 		return nil
 	}
 
 	return exprs
 }
 
+// parseElement parses an item of a list, which may spread out the items of
+// another value.
+func (p *Parser) parseElement() ast.Expression {
+	if p.curTokenIs(token.ELLIPSIS) {
+		return p.parseSpreadExpression()
+	}
+
+	return p.parseExpression(LOWEST)
+}
+
+func (p *Parser) parseSpreadExpression() ast.Expression {
+	spread := &ast.SpreadExpression{Token: p.curToken}
+
+	p.nextToken()
+	spread.Value = p.parseExpression(LOWEST)
+	if spread.Value == nil {
+		return nil
+	}
+
+	return spread
+}
+
 func (p *Parser) curTokenIs(tok token.TokenType) bool {
 	return tok == p.curToken.Type
 }
@@ -584,12 +1667,34 @@ func (p *Parser) nextTokenIf(tok token.TokenType) bool {
 func (p *Parser) expectPeek(tok token.TokenType) bool {
 	res := p.nextTokenIf(tok)
 	if !res {
-		p.peekError(tok)
+		p.report(p.peekError(tok))
 	}
 
 	return res
 }
 
+// expectClose is expectPeek for the token that closes the bracket open. When
+// it is missing the diagnostic also points at open.
+func (p *Parser) expectClose(tok token.TokenType, open token.Token) bool {
+	if p.nextTokenIf(tok) {
+		return true
+	}
+
+	d := p.peekError(tok)
+	d.Related = matching(open)
+
+	p.report(d)
+	return false
+}
+
+// matching points at the bracket open, which is missing its closing bracket.
+func matching(open token.Token) []RelatedSpan {
+	return []RelatedSpan{{
+		Span:    open.Span(),
+		Message: fmt.Sprintf("to match this '%s'", open.Literal),
+	}}
+}
+
 func (p *Parser) peekPrecedence() int {
 	if p, ok := precedences[p.peekToken.Type]; ok {
 		return p
@@ -606,15 +1711,150 @@ func (p *Parser) curPrecedence() int {
 	return LOWEST
 }
 
-func (p *Parser) peekError(tok token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
-		tok, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+// report records a diagnostic unless the parser is still recovering from an
+// earlier error. Errors put the parser into recovery.
+func (p *Parser) report(d Diagnostic) {
+	if p.recovering {
+		return
+	}
+
+	p.diagnostics = append(p.diagnostics, d)
+
+	if d.Severity == SeverityError {
+		p.recovering = true
+		p.errorIndex = p.index
+	}
+}
+
+// errorAt reports an error located at tok.
+func (p *Parser) errorAt(tok token.Token, code string, format string, args ...interface{}) {
+	p.report(Diagnostic{
+		Severity: SeverityError,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+		Span:     tok.Span(),
+	})
+}
+
+// Tokens that always begin a new statement, used to resynchronise after an error
+var statementKeywords = map[token.TokenType]bool{
+	token.LET:      true,
+	token.RETURN:   true,
+	token.THROW:    true,
+	token.FOR:      true,
+	token.WHILE:    true,
+	token.DO:       true,
+	token.BREAK:    true,
+	token.CONTINUE: true,
+}
+
+// synchronize skips the rest of the statement starting at token index start,
+// which failed to parse. Parsing may have moved past the point where the error
+// was found, so it first rewinds to there. It stops on the last token of the
+// broken statement, so that the caller's next call to nextToken moves to the
+// start of the following statement. It returns true instead if it stops on a
+// '}' that closes the enclosing block.
+func (p *Parser) synchronize(start int) bool {
+	p.recovering = false
+	p.seek(p.errorIndex)
+
+	base := p.nesting[start]
+
+	for !p.curTokenIs(token.EOF) {
+		level := p.nesting[p.index]
+
+		switch p.curToken.Type {
+		case token.RBRACE:
+			if level.braces <= base.braces {
+				return true
+			}
+
+			// The end of a block opened by the statement, unless it continues
+			if level.braces == base.braces+1 && !p.continuesAfterBrace() {
+				return false
+			}
+		case token.SEMICOLON:
+			if level == base {
+				return false
+			}
+		}
+
+		next := p.nesting[p.index+1]
+		if next == base && (statementKeywords[p.peekToken.Type] || p.peekTokenIs(token.RBRACE)) {
+			return false
+		}
+
+		p.nextToken()
+	}
+
+	return false
+}
+
+// continuesAfterBrace reports whether the statement goes on past the '}' that
+// is curToken, because it closed a hash literal used in an expression or a
+// block with more clauses to come.
+func (p *Parser) continuesAfterBrace() bool {
+	switch p.peekToken.Type {
+	case token.ELSE, token.CATCH, token.FINALLY, token.SEMICOLON, token.COMMA, token.RPAREN, token.RBRACKET:
+		return true
+	}
+
+	_, ok := p.infixParseFns[p.peekToken.Type]
+	return ok
+}
+
+// Closing delimiters that can be suggested as a fix when they are missing
+var closingTokens = map[token.TokenType]bool{
+	token.RPAREN:   true,
+	token.RBRACKET: true,
+	token.RBRACE:   true,
+	token.COLON:    true,
+}
+
+// peekError describes finding peekToken where tok was expected.
+func (p *Parser) peekError(tok token.TokenType) Diagnostic {
+	d := Diagnostic{
+		Severity: SeverityError,
+		Code:     CodeUnexpectedToken,
+		Message:  fmt.Sprintf("expected next token to be %s, got %s instead", tok, p.peekToken.Type),
+		Span:     p.peekToken.Span(),
+	}
+
+	if closingTokens[tok] {
+		d.Fix = &SuggestedFix{
+			Description: fmt.Sprintf("insert '%s'", tok),
+			Span:        token.Span{Start: p.curToken.End, End: p.curToken.End},
+			Replacement: string(tok),
+		}
+	}
+
+	return d
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found.", t)
-	p.errors = append(p.errors, msg)
+	if t == token.ILLEGAL {
+		p.illegalTokenError(p.curToken)
+		return
+	}
+
+	p.errorAt(p.curToken, CodeExpectedExpression, "no prefix parse function for %s found.", t)
+}
+
+// illegalTokenError reports a token the lexer could not make sense of. Bad
+// strings come through as their offending text: the unterminated string from
+// its opening quote, or the malformed escape sequence. An unclosed block
+// comment comes through whole.
+func (p *Parser) illegalTokenError(tok token.Token) {
+	switch {
+	case strings.HasPrefix(tok.Literal, "\""):
+		p.errorAt(tok, CodeIllegalToken, "unterminated string literal")
+	case strings.HasPrefix(tok.Literal, "/*"):
+		p.errorAt(tok, CodeIllegalToken, "unterminated block comment")
+	case strings.HasPrefix(tok.Literal, "\\"):
+		p.errorAt(tok, CodeInvalidEscape, "invalid escape sequence %s in string literal", tok.Literal)
+	default:
+		p.errorAt(tok, CodeIllegalToken, "illegal token %q", tok.Literal)
+	}
 }
 
 func (p *Parser) registerPrefix(tokenType token.TokenType, fn prefixParseFn) {
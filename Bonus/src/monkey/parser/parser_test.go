@@ -65,6 +65,41 @@ func TestReturnStatements(t *testing.T) {
 	}
 }
 
+func TestComments(t *testing.T) {
+	input := `
+// Adds things
+let add = fn(a, b) {
+	a + b // the sum
+};
+
+/* Unused for now:
+let sub = fn(a, b) { a - b };
+*/
+add(1, /* two */ 2)
+`
+
+	program := parseAndCheckErrors(input, t)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d",
+			len(program.Statements))
+	}
+
+	expected := "let add = fn(a, b) { (a + b); };add(1, 2);"
+	if program.String() != expected {
+		t.Errorf("program.String() wrong. expected=%q, got=%q", expected, program.String())
+	}
+
+	let, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not *ast.LetStatement. got=%T", program.Statements[0])
+	}
+
+	if len(let.Token.Comments) != 1 || let.Token.Comments[0].Text != "// Adds things" {
+		t.Errorf("let token has wrong comments. got=%+v", let.Token.Comments)
+	}
+}
+
 func TestIdentifier(t *testing.T) {
 	input := "foobar;"
 
@@ -102,6 +137,113 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestIntegerLiteralBases(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"0xFF;", 255},
+		{"0Xff;", 255},
+		{"0o755;", 493},
+		{"0b1010;", 10},
+		{"1_000_000;", 1000000},
+		{"0x_dead_beef;", 0xdeadbeef},
+		{"0;", 0},
+		{"9223372036854775807;", 9223372036854775807},
+	}
+
+	for _, tt := range tests {
+		program := parseAndCheckErrors(tt.input, t)
+
+		stmt, ok := extractSingleExpressionStatement(t, program)
+		if !ok {
+			return
+		}
+
+		intLit, ok := stmt.Expression.(*ast.IntegerLiteral)
+		if !ok {
+			t.Fatalf("exp is not *ast.IntegerLiteral. got=%T", stmt.Expression)
+		}
+		if intLit.Value != tt.expected {
+			t.Errorf("intLit.Value not %d. got=%d", tt.expected, intLit.Value)
+		}
+	}
+}
+
+func TestMalformedIntegerLiterals(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedCode  string
+		expectedError string
+	}{
+		{"99999999999999999999", CodeIntegerOverflow, "1:1: integer literal 99999999999999999999 overflows int64 (max 9223372036854775807)"},
+		{"0xFFFFFFFFFFFFFFFF", CodeIntegerOverflow, "1:1: integer literal 0xFFFFFFFFFFFFFFFF overflows int64 (max 9223372036854775807)"},
+		{"0x", CodeInvalidInteger, "1:1: hexadecimal literal 0x has no digits"},
+		{"0b_", CodeInvalidInteger, "1:1: binary literal 0b_ has no digits"},
+		{"0b102", CodeInvalidInteger, "1:1: invalid digit '2' in binary literal 0b102"},
+		{"0o78", CodeInvalidInteger, "1:1: invalid digit '8' in octal literal 0o78"},
+		{"0xFG", CodeInvalidInteger, "1:1: invalid digit 'G' in hexadecimal literal 0xFG"},
+		{"09", CodeInvalidInteger, "1:1: invalid digit '9' in octal literal 09"},
+		{"1__000", CodeInvalidInteger, "1:1: '_' must separate successive digits in 1__000"},
+		{"1_", CodeInvalidInteger, "1:1: '_' must separate successive digits in 1_"},
+		{"12abc", CodeInvalidInteger, "1:1: invalid digit 'a' in decimal literal 12abc"},
+		{"0x1G2", CodeInvalidInteger, "1:1: invalid digit 'G' in hexadecimal literal 0x1G2"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) != 1 {
+			t.Errorf("wrong number of diagnostics for %q. expected=1, got=%d (%v)",
+				tt.input, len(diagnostics), p.Errors())
+			continue
+		}
+
+		if diagnostics[0].Code != tt.expectedCode {
+			t.Errorf("wrong diagnostic code for %q. expected=%q, got=%q",
+				tt.input, tt.expectedCode, diagnostics[0].Code)
+		}
+
+		if diagnostics[0].String() != tt.expectedError {
+			t.Errorf("wrong error for %q. expected=%q, got=%q",
+				tt.input, tt.expectedError, diagnostics[0].String())
+		}
+	}
+}
+
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14;", 3.14},
+		{".5;", 0.5},
+		{"1e-9;", 1e-9},
+		{"2.5E3;", 2500},
+		{"1_000.5;", 1000.5},
+	}
+
+	for _, tt := range tests {
+		program := parseAndCheckErrors(tt.input, t)
+
+		stmt, ok := extractSingleExpressionStatement(t, program)
+		if !ok {
+			return
+		}
+
+		floatLit, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp is not *ast.FloatLiteral. got=%T", stmt.Expression)
+		}
+		if floatLit.Value != tt.expected {
+			t.Errorf("floatLit.Value not %g. got=%g", tt.expected, floatLit.Value)
+		}
+	}
+}
+
 func TestStringLiteralExpression(t *testing.T) {
 	input := `"hello world";`
 	program := parseAndCheckErrors(input, t)
@@ -190,6 +332,11 @@ func TestParsingInfixExpressions(t *testing.T) {
 		{"true != false", true, "!=", false},
 		{"false == false", false, "==", false},
 		{"x = 1", "x", "=", 1},
+		{"x += 1", "x", "+=", 1},
+		{"x -= 1", "x", "-=", 1},
+		{"x *= 1", "x", "*=", 1},
+		{"x /= 1", "x", "/=", 1},
+		{"x %= 1", "x", "%=", 1},
 	}
 
 	for _, tt := range infixTests {
@@ -238,6 +385,62 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"-a * b",
 			"((-a) * b);",
 		},
+		{
+			"a || b && c",
+			"(a || (b && c));",
+		},
+		{
+			"a && b || c && d",
+			"((a && b) || (c && d));",
+		},
+		{
+			"a < b && c == d || !e",
+			"(((a < b) && (c == d)) || (!e));",
+		},
+		{
+			"x = a || b",
+			"(x = (a || b));",
+		},
+		{
+			"a % b * c",
+			"((a % b) * c);",
+		},
+		{
+			"a <= b == c >= d",
+			"((a <= b) == (c >= d));",
+		},
+		{
+			"2 ** 3 ** 2",
+			"(2 ** (3 ** 2));",
+		},
+		{
+			"-a ** b",
+			"(-(a ** b));",
+		},
+		{
+			"a * b ** c",
+			"(a * (b ** c));",
+		},
+		{
+			"a | b ^ c & d",
+			"(a | (b ^ (c & d)));",
+		},
+		{
+			"a & b == c",
+			"((a & b) == c);",
+		},
+		{
+			"a << b + c >> d",
+			"((a << (b + c)) >> d);",
+		},
+		{
+			"a & b << c",
+			"(a & (b << c));",
+		},
+		{
+			"~a & b",
+			"((~a) & b);",
+		},
 		{
 			"!-a",
 			"(!(-a));",
@@ -350,6 +553,26 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"(x = 1) + 1",
 			"((x = 1) + 1);",
 		},
+		{
+			"x += y = 2 * z",
+			"((x += y) = (2 * z));",
+		},
+		{
+			"x -= a || b",
+			"(x -= (a || b));",
+		},
+		{
+			"-x++ * 2",
+			"((-(x++)) * 2);",
+		},
+		{
+			"++a[i] + b--",
+			"((++(a[i])) + (b--));",
+		},
+		{
+			"a[i++] += --j",
+			"((a[(i++)]) += (--j));",
+		},
 		{
 			"a * [1, 2, 3, 4][b * c] * d",
 			"((a * ([1, 2, 3, 4][(b * c)])) * d);",
@@ -615,6 +838,279 @@ func TestFunctionParameterParsing(t *testing.T) {
 	}
 }
 
+func TestFunctionStatementParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn add(x, y) { x + y }", "fn add(x, y) { (x + y); }"},
+		{"fn f() { } fn g(a = 1, ...rest) { f() }", "fn f() {  }fn g(a = 1, ...rest) { f(); }"},
+		{"fn(x) { x }(1)", "fn(x) { x; }(1);"},
+		{"if (x) { fn f() { 1 }; f() }", "if x { fn f() { 1; }f(); };"},
+	}
+
+	for _, tt := range tests {
+		program := parseAndCheckErrors(tt.input, t)
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong program for %q. expected=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestFunctionNames(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedName string
+	}{
+		{"fn connect(host) { host }", "connect"},
+		{"let connect = fn(host) { host }", "connect"},
+		{"connect = fn(host) { host }", "connect"},
+		{"let [connect] = [fn(host) { host }]", ""},
+		{"fn(host) { host }", ""},
+		{"handlers[0] = fn(host) { host }", ""},
+	}
+
+	for _, tt := range tests {
+		program := parseAndCheckErrors(tt.input, t)
+
+		var value ast.Node
+		switch stmt := program.Statements[0].(type) {
+		case *ast.FunctionStatement:
+			value = stmt.Function
+		case *ast.LetStatement:
+			value = stmt.Value
+		case *ast.ExpressionStatement:
+			value = stmt.Expression
+		}
+
+		if infix, ok := value.(*ast.InfixExpression); ok {
+			value = infix.Right
+		}
+
+		if array, ok := value.(*ast.ArrayLiteral); ok {
+			value = array.Elements[0]
+		}
+
+		function, ok := value.(*ast.FunctionLiteral)
+		if !ok {
+			t.Fatalf("no function literal in %q. got=%T", tt.input, value)
+		}
+
+		if function.Name != tt.expectedName {
+			t.Errorf("wrong name for %q. expected=%q, got=%q", tt.input, tt.expectedName, function.Name)
+		}
+	}
+}
+
+func TestTailCallMarking(t *testing.T) {
+	last := func(block *ast.BlockStatement) ast.Node {
+		node := ast.Node(block.Statements[len(block.Statements)-1])
+		switch stmt := node.(type) {
+		case *ast.ExpressionStatement:
+			return stmt.Expression
+		case *ast.ReturnStatement:
+			return stmt.ReturnValue
+		}
+		return node
+	}
+
+	tests := []struct {
+		input    string
+		call     func(body *ast.BlockStatement) ast.Node
+		expected bool
+	}{
+		{"fn() { f() }", last, true},
+		{"fn() { return f() }", last, true},
+		{"fn() { f(); 1 }", func(b *ast.BlockStatement) ast.Node { return b.Statements[0].(*ast.ExpressionStatement).Expression }, false},
+		{"fn() { 1 + f() }", func(b *ast.BlockStatement) ast.Node { return last(b).(*ast.InfixExpression).Right }, false},
+		{"fn() { [f()] }", func(b *ast.BlockStatement) ast.Node { return last(b).(*ast.ArrayLiteral).Elements[0] }, false},
+		{"fn() { f()() }", func(b *ast.BlockStatement) ast.Node { return last(b).(*ast.CallExpression).Function }, false},
+		{"fn() { if (x) { f() } else { 1 } }", func(b *ast.BlockStatement) ast.Node { return last(last(b).(*ast.IfExpression).Clauses[0].Consequence) }, true},
+		{"fn() { if (x) { 1 } else { f() } }", func(b *ast.BlockStatement) ast.Node { return last(last(b).(*ast.IfExpression).Alternative) }, true},
+		{"fn() { if (f()) { 1 } }", func(b *ast.BlockStatement) ast.Node { return last(b).(*ast.IfExpression).Clauses[0].Condition }, false},
+		{"fn() { match (x) { _ => f() } }", func(b *ast.BlockStatement) ast.Node { return last(last(b).(*ast.MatchExpression).Arms[0].Body) }, true},
+		{"fn() { if (x) { return f() }; 1 }", func(b *ast.BlockStatement) ast.Node {
+			return last(b.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.IfExpression).Clauses[0].Consequence)
+		}, true},
+		{"fn() { while (x) { return f() } }", func(b *ast.BlockStatement) ast.Node { return last(b.Statements[0].(*ast.WhileStatement).Body) }, true},
+		{"fn() { for (;;) { return f() } }", func(b *ast.BlockStatement) ast.Node { return last(b.Statements[0].(*ast.ForLoopStatement).Body) }, false},
+		{"fn() { try { f() } catch (e) { 1 } }", func(b *ast.BlockStatement) ast.Node { return last(last(b).(*ast.TryExpression).Block) }, false},
+		{"fn() { try { return f() } finally { 1 } }", func(b *ast.BlockStatement) ast.Node { return last(last(b).(*ast.TryExpression).Block) }, false},
+		{"fn() { fn() { 1 } }", func(b *ast.BlockStatement) ast.Node { return last(last(b).(*ast.FunctionLiteral).Body) }, false},
+	}
+
+	for _, tt := range tests {
+		program := parseAndCheckErrors(tt.input, t)
+
+		stmt, ok := extractSingleExpressionStatement(t, program)
+		if !ok {
+			return
+		}
+
+		node := tt.call(stmt.Expression.(*ast.FunctionLiteral).Body)
+
+		call, ok := node.(*ast.CallExpression)
+		if !ok {
+			if tt.expected {
+				t.Errorf("expected a call in %q. got=%T", tt.input, node)
+			}
+			continue
+		}
+
+		if call.Tail != tt.expected {
+			t.Errorf("wrong Tail for %q. expected=%t, got=%t", tt.input, tt.expected, call.Tail)
+		}
+	}
+}
+
+func TestPatternParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let [a, b] = xs;", "let [a, b] = xs;"},
+		{"let [] = xs", "let [] = xs;"},
+		{"let [a, [b, c], ...rest] = xs", "let [a, [b, c], ...rest] = xs;"},
+		{"let [a = 1, b = a + 1] = xs", "let [a = 1, b = (a + 1)] = xs;"},
+		{"let {name, port: p = 80,} = cfg", "let {name, port: p = 80} = cfg;"},
+		{"let {db: {host, port}, tags: [first]} = cfg", "let {db: {host, port}, tags: [first]} = cfg;"},
+		{"fn([a, b], {c}, ...d) { a }", "fn([a, b], {c}, ...d) { a; };"},
+		{"for ([k, v] in pairs) { k }", "for ([k, v] in pairs) { k; }"},
+		{"for (i, {id} in rows) { id }", "for (i, {id} in rows) { id; }"},
+		{"[a, b] = [b, a]", "([a, b] = [b, a]);"},
+		{"{name, port = 1} = cfg", "({name, port = 1} = cfg);"},
+		{"let x = [a, ...r] = xs", "let x = ([a, ...r] = xs);"},
+		{"[a, b]", "[a, b];"},
+		{"[a, b] == c", "([a, b] == c);"},
+		{"[a = 1, b]", "[(a = 1), b];"},
+		{`{"a": b}`, "{a:b};"},
+		{"{a: b}", "{a:b};"},
+	}
+
+	for _, tt := range tests {
+		program := parseAndCheckErrors(tt.input, t)
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong program for %q. expected=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestArgumentParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn(host, port = 80) { host }", "fn(host, port = 80) { host; };"},
+		{"fn(a = 1 + 2, [b, c] = [a, a], ...d) { a }", "fn(a = (1 + 2), [b, c] = [a, a], ...d) { a; };"},
+		{"fn(f = fn(x = 1) { x }) { f() }", "fn(f = fn(x = 1) { x; }) { f(); };"},
+		{"connect(port: 8080, host: \"x\")", "connect(port: 8080, host: x);"},
+		{"connect(h, port: p + 1)", "connect(h, port: (p + 1));"},
+		{"f(a: {b: c})", "f(a: {b:c});"},
+		{"f(a: g(b: 1))", "f(a: g(b: 1));"},
+	}
+
+	for _, tt := range tests {
+		program := parseAndCheckErrors(tt.input, t)
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong program for %q. expected=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestSpreadParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"[...a, ...b]", "[...a, ...b];"},
+		{"[1, ...a + b, 2]", "[1, ...(a + b), 2];"},
+		{"f(...args)", "f(...args);"},
+		{"f(x, ...xs, y, port: 1)", "f(x, ...xs, y, port: 1);"},
+		{"{...defaults, ...overrides}", "{...defaults, ...overrides};"},
+		{`{"a": 1, ...b, "c": 2,}`, "{a:1, ...b, c:2};"},
+		{"let [...a] = [...b]", "let [...a] = [...b];"},
+	}
+
+	for _, tt := range tests {
+		program := parseAndCheckErrors(tt.input, t)
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong program for %q. expected=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestMatchExpressionParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"match (x) { 1 => a, _ => b }", "match (x) { 1 => { a; }, _ => { b; } };"},
+		{"match (x) { -1 => a, 2.5 => b, \"s\" => c, true => d, }", "match (x) { (-1) => { a; }, 2.5 => { b; }, s => { c; }, true => { d; } };"},
+		{"match (x) { int n if n > 0 => n, int => 0, fn => 1, string s => s }", "match (x) { int n if (n > 0) => { n; }, int => { 0; }, fn => { 1; }, string s => { s; } };"},
+		{"match (x) { [] => 0, [a, _, ...rest] => a, [[b]] => b }", "match (x) { [] => { 0; }, [a, _, ...rest] => { a; }, [[b]] => { b; } };"},
+		{"match (x) { {type: \"ping\", id} => id, {body: {size: int n}} => n }", "match (x) { {type: ping, id} => { id; }, {body: {size: int n}} => { n; } };"},
+		{"match (x) { n => { let y = n; y } _ => 0 }", "match (x) { n => { let y = n;y; }, _ => { 0; } };"},
+		{"match (f(x)) { }", "match (f(x)) {  };"},
+		{"let y = match (x) { _ => 1 } + 1", "let y = (match (x) { _ => { 1; } } + 1);"},
+	}
+
+	for _, tt := range tests {
+		program := parseAndCheckErrors(tt.input, t)
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong program for %q. expected=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestNestedTypeNamePatterns(t *testing.T) {
+	program := parseAndCheckErrors("match (x) { array => 1, [array, int n] => 2, {size: int} => 3 }", t)
+	arms := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.MatchExpression).Arms
+
+	if _, ok := arms[0].Pattern.(*ast.TypePattern); !ok {
+		t.Errorf("top level array is not *ast.TypePattern. got=%T", arms[0].Pattern)
+	}
+
+	elements := arms[1].Pattern.(*ast.ArrayPattern).Elements
+	if _, ok := elements[0].Target.(*ast.Identifier); !ok {
+		t.Errorf("nested array is not *ast.Identifier. got=%T", elements[0].Target)
+	}
+	if _, ok := elements[1].Target.(*ast.TypePattern); !ok {
+		t.Errorf("nested int n is not *ast.TypePattern. got=%T", elements[1].Target)
+	}
+
+	pairs := arms[2].Pattern.(*ast.HashPattern).Pairs
+	if _, ok := pairs[0].Value.Target.(*ast.Identifier); !ok {
+		t.Errorf("nested int is not *ast.Identifier. got=%T", pairs[0].Value.Target)
+	}
+}
+
+func TestTryExpressionParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"try { f() } catch (e) { e }", "try { f(); } catch (e) { e; };"},
+		{"try { f() } finally { g() }", "try { f(); } finally { g(); };"},
+		{"try { f() } catch { 0 } finally { g() }", "try { f(); } catch { 0; } finally { g(); };"},
+		{"let x = try { f() } catch (e) { 1 } + 1", "let x = (try { f(); } catch (e) { 1; } + 1);"},
+		{"throw error(\"bad\")", "throw error(bad);"},
+		{"if (x) { throw e; }", "if x { throw e; };"},
+	}
+
+	for _, tt := range tests {
+		program := parseAndCheckErrors(tt.input, t)
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong program for %q. expected=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
 func TestCallExpressionParsing(t *testing.T) {
 	input := "add(1, 2 * 3, 4 + 5);"
 	program := parseAndCheckErrors(input, t)
@@ -885,6 +1381,198 @@ func TestForLoopStatementStepExpressionParsing(t *testing.T) {
 	}
 }
 
+func TestBreakAndContinueParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"for (;;) { break; }", "{ break; }"},
+		{"for (;;) continue", "{ continue; }"},
+		{"for (;;) { if (x) { break } else { continue } }", "{ if x { break; } else { continue; }; }"},
+		{"outer: for (;;) { for (;;) { break outer; continue outer } }", "{ for ({ break outer;continue outer; } }"},
+	}
+
+	for _, tt := range tests {
+		program := parseAndCheckErrors(tt.input, t)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.ForLoopStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ForLoopStatement. got=%T", program.Statements[0])
+		}
+
+		if stmt.Body.String() != tt.expected {
+			t.Errorf("wrong body for %q. expected=%q, got=%q", tt.input, tt.expected, stmt.Body.String())
+		}
+	}
+}
+
+func TestLoopLabels(t *testing.T) {
+	program := parseAndCheckErrors("outer: for (;;) { inner: for (;;) { break outer } };", t)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+	}
+
+	outer, ok := program.Statements[0].(*ast.ForLoopStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ForLoopStatement. got=%T", program.Statements[0])
+	}
+	if outer.Label == nil || outer.Label.Value != "outer" {
+		t.Fatalf("outer loop has wrong label. got=%v", outer.Label)
+	}
+
+	inner, ok := outer.Body.Statements[0].(*ast.ForLoopStatement)
+	if !ok {
+		t.Fatalf("outer.Body.Statements[0] is not ast.ForLoopStatement. got=%T", outer.Body.Statements[0])
+	}
+	if inner.Label == nil || inner.Label.Value != "inner" {
+		t.Fatalf("inner loop has wrong label. got=%v", inner.Label)
+	}
+
+	brk, ok := inner.Body.Statements[0].(*ast.BreakStatement)
+	if !ok {
+		t.Fatalf("inner.Body.Statements[0] is not ast.BreakStatement. got=%T", inner.Body.Statements[0])
+	}
+	if brk.Label == nil || brk.Label.Value != "outer" {
+		t.Fatalf("break has wrong label. got=%v", brk.Label)
+	}
+}
+
+func TestForInStatementParsing(t *testing.T) {
+	tests := []struct {
+		input             string
+		expectedVariables []string
+		expectedIterable  string
+		expectedBody      string
+	}{
+		{"for (x in arr) { puts(x) }", []string{"x"}, "arr", "{ puts(x); }"},
+		{"for (k, v in hash) { k }", []string{"k", "v"}, "hash", "{ k; }"},
+		{`for (ch in "abc") ch`, []string{"ch"}, "abc", "{ ch; }"},
+		{"for (i in range(0, 10, 2)) { break };", []string{"i"}, "range(0, 10, 2)", "{ break; }"},
+		{"for (x in a + b) { continue }", []string{"x"}, "(a + b)", "{ continue; }"},
+	}
+
+	for _, tt := range tests {
+		program := parseAndCheckErrors(tt.input, t)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.ForInStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ForInStatement. got=%T", program.Statements[0])
+		}
+
+		if len(stmt.Variables) != len(tt.expectedVariables) {
+			t.Fatalf("wrong number of variables. expected=%d, got=%d", len(tt.expectedVariables), len(stmt.Variables))
+		}
+
+		for i, name := range tt.expectedVariables {
+			if stmt.Variables[i].String() != name {
+				t.Errorf("variable %d is not %q. got=%q", i, name, stmt.Variables[i].String())
+			}
+		}
+
+		if stmt.Iterable.String() != tt.expectedIterable {
+			t.Errorf("wrong iterable. expected=%q, got=%q", tt.expectedIterable, stmt.Iterable.String())
+		}
+
+		if stmt.Body.String() != tt.expectedBody {
+			t.Errorf("wrong body. expected=%q, got=%q", tt.expectedBody, stmt.Body.String())
+		}
+	}
+
+	program := parseAndCheckErrors("items: for (x in xs) { for (y in ys) { continue items } }", t)
+
+	stmt, ok := program.Statements[0].(*ast.ForInStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ForInStatement. got=%T", program.Statements[0])
+	}
+	if stmt.Label == nil || stmt.Label.Value != "items" {
+		t.Fatalf("loop has wrong label. got=%v", stmt.Label)
+	}
+	if stmt.String() != "items: for (x in xs) { for (y in ys) { continue items; } }" {
+		t.Errorf("wrong String(). got=%q", stmt.String())
+	}
+}
+
+func TestWhileStatementParsing(t *testing.T) {
+	tests := []struct {
+		input             string
+		expectedCondition string
+		expectedBody      string
+		expectedDoWhile   bool
+	}{
+		{"while (x < 10) { x = x + 1 }", "(x < 10)", "{ (x = (x + 1)); }", false},
+		{"while (true) break", "true", "{ break; }", false},
+		{"do { x = x + 1 } while (x < 10)", "(x < 10)", "{ (x = (x + 1)); }", true},
+		{"do x = x + 1; while (x < 10);", "(x < 10)", "{ (x = (x + 1)); }", true},
+	}
+
+	for _, tt := range tests {
+		program := parseAndCheckErrors(tt.input, t)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.WhileStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.WhileStatement. got=%T", program.Statements[0])
+		}
+
+		if stmt.IsDoWhile != tt.expectedDoWhile {
+			t.Errorf("wrong IsDoWhile for %q. got=%t", tt.input, stmt.IsDoWhile)
+		}
+
+		if stmt.Condition.String() != tt.expectedCondition {
+			t.Errorf("wrong condition. expected=%q, got=%q", tt.expectedCondition, stmt.Condition.String())
+		}
+
+		if stmt.Body.String() != tt.expectedBody {
+			t.Errorf("wrong body. expected=%q, got=%q", tt.expectedBody, stmt.Body.String())
+		}
+	}
+}
+
+func TestLoopExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = while (true) { break 5 };", "let x = while (true) { break 5; };"},
+		{"let x = for (i in xs) { if (i > 2) { break i * 2 } }", "let x = for (i in xs) { if (i > 2) { break (i * 2); }; };"},
+		{"outer: while (a) { do { break outer (1) } while (b) }", "outer: while (a) { do { break outer 1; } while (b) }"},
+		{"while (a) { break (found) }", "while (a) { break (found); }"},
+		{"while (a) { break found + 1 }", "while (a) { break (found + 1); }"},
+		{"while (a) { break found }", "while (a) { break (found); }"},
+		{"for (i in xs) { break i; }", "for (i in xs) { break (i); }"},
+		{"outer: while (a) { while (b) { break outer } break inner }", "outer: while (a) { while (b) { break outer; }break (inner); }"},
+		{"f(while (true) { break })", "f(while (true) { break; });"},
+		{"let x = do { break } while (true) == null", "let x = (do { break; } while (true) == null);"},
+	}
+
+	for _, tt := range tests {
+		program := parseAndCheckErrors(tt.input, t)
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong program for %q. expected=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+
+	program := parseAndCheckErrors("for (i in xs) { break i }", t)
+	loop := program.Statements[0].(*ast.ForInStatement)
+	brk := loop.Body.Statements[0].(*ast.BreakStatement)
+	if brk.Label != nil || brk.Value == nil || brk.Value.String() != "i" {
+		t.Errorf("break i should break with the value of i. got label=%v, value=%v", brk.Label, brk.Value)
+	}
+}
+
 func TestForLoopStatementBodyParsing(t *testing.T) {
 	tests := []struct {
 		input             string
@@ -1136,6 +1824,243 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 	}
 }
 
+func TestParserErrorPositions(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"let = 5;", "1:5: expected next token to be IDENT, got = instead"},
+		{"let x = 5;\nadd(1, 2", "2:9: expected next token to be ), got EOF instead"},
+		{"let x = 5;\n\n  fn(x) { x", "3:9: block statement not closed by RBRACE"},
+		{"x +\n  ;", "2:3: no prefix parse function for ; found."},
+		{"let é = 1;\nlet s = \"ab", "2:9: unterminated string literal"},
+		{"let s = \"é\\u{zz}\";", "1:9: invalid escape sequence \\u{zz} in string literal"},
+		{"let x = 1; /* a /* b */\nx", "1:12: unterminated block comment"},
+		{"break;", "1:1: break outside loop"},
+		{"if (x) { continue }", "1:10: continue outside loop"},
+		{"for (;;) { fn() { break }() }", "1:19: break outside loop"},
+		{"for (;;) { continue outer }", "1:21: continue to undefined label outer"},
+		{"while (true) { continue found; }", "1:25: continue to undefined label found"},
+		{"outer: if (x) { 1 }", "1:8: expected next token to be FOR, got IF instead"},
+		{"outer: for (;;) { 1 }; continue outer", "1:24: continue outside loop"},
+		{"outer: let x = 1;", "1:8: expected next token to be FOR, got LET instead"},
+		{"let [a, 1] = xs", "1:9: expected next token to be IDENT, got INT instead"},
+		{"let [...a, b] = xs", "1:10: expected next token to be ], got , instead"},
+		{"let {a: 1} = xs", "1:9: expected next token to be IDENT, got INT instead"},
+		{`let {"a": b} = xs`, "1:6: expected next token to be IDENT, got STRING instead"},
+		{"fn([a, ...b, c]) { a }", "1:12: expected next token to be ], got , instead"},
+		{"f(a: 1, 2)", "1:9: positional argument after named argument"},
+		{"match x { _ => 1 }", "1:7: expected next token to be (, got IDENT instead"},
+		{"match (x) { 1 + 2 => 1 }", "1:15: expected next token to be =>, got + instead"},
+		{"match (x) { a b => 1 }", "1:15: expected next token to be =>, got IDENT instead"},
+		{"match (x) { 1 => a 2 => b }", "1:20: expected next token to be '}' or ',', got INT instead"},
+		{"match (x) { [a = 1] => a }", "1:16: expected next token to be ], got = instead"},
+		{"match (x) { - a => 1 }", "1:15: expected next token to be INT, got IDENT instead"},
+		{"match (x) { (a) => 1 }", "1:13: expected a pattern, got ( instead"},
+		{"match (x) { [fn] => 1 }", "1:16: expected next token to be IDENT, got ] instead"},
+		{"[1, ...]", "1:8: no prefix parse function for ] found."},
+		{"{...a: 1}", "1:6: expected next token to be '}' or ',', got : instead"},
+		{"let a = ...b", "1:9: no prefix parse function for ... found."},
+		{"fn(...a = []) { a }", "1:9: expected next token to be ), got = instead"},
+		{"fn(a = ) { a }", "1:8: no prefix parse function for ) found."},
+		{"try { 1 }", "1:10: expected next token to be CATCH or FINALLY, got EOF instead"},
+		{"try f() catch (e) { 1 }", "1:5: expected next token to be {, got IDENT instead"},
+		{"try { 1 } catch (1) { 2 }", "1:18: expected next token to be IDENT, got INT instead"},
+		{"try { 1 } catch e { 2 }", "1:17: expected next token to be {, got IDENT instead"},
+		{"throw;", "1:6: no prefix parse function for ; found."},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Fatalf("expected parser errors for %q", tt.input)
+		}
+
+		if errors[0] != tt.expectedError {
+			t.Errorf("wrong error for %q. expected=%q, got=%q", tt.input, tt.expectedError, errors[0])
+		}
+	}
+}
+
+func TestParserRecovery(t *testing.T) {
+	tests := []struct {
+		input              string
+		expectedCodes      []string
+		expectedStatements int
+	}{
+		{"let x = ;\nlet y = 5;\ny", []string{CodeExpectedExpression}, 3},
+		{"let x = 5 +;\nlet y = ;\ny", []string{CodeExpectedExpression, CodeExpectedExpression}, 3},
+		{"if (x { y }\nlet z = 1;", []string{CodeUnexpectedToken}, 1},
+		{"if (x { y } else { z }\nlet z = 1;", []string{CodeUnexpectedToken}, 1},
+		{"let f = fn() { foo( };\nlet a = 1;", []string{CodeExpectedExpression}, 2},
+		{"let = 5 return 1; for (;;) { 1 }", []string{CodeUnexpectedToken}, 2},
+		{"for (let i = 0 i < 10; i = i + 1) { i }\nlet a = 1;", []string{CodeUnexpectedToken}, 1},
+		{"fn() { let a = ; let b = 1; b }", []string{CodeExpectedExpression}, 1},
+		{"}\nlet a = 1;", []string{CodeExpectedExpression}, 1},
+		{"let a = 99999999999999999999; let b = 1;", []string{CodeIntegerOverflow}, 2},
+		{"let a = 0xFG; let b = 1;", []string{CodeInvalidInteger}, 2},
+		{"let a = 1e; let b = 1;", []string{CodeInvalidFloat}, 2},
+		{"let a = 1.2.3; let b = 1;", []string{CodeInvalidFloat}, 2},
+		{"let a = 12abc; let b = 1;", []string{CodeInvalidInteger}, 2},
+		{"let a = 1.5x; let b = 1;", []string{CodeInvalidFloat}, 2},
+		{"let a = \"abc", []string{CodeIllegalToken}, 1},
+		{"let a = \"\\x4\"; let b = 1;", []string{CodeInvalidEscape}, 2},
+		{"break; let b = 1;", []string{CodeOutsideLoop}, 1},
+		{"for (;;) { continue x; let b = 1; }", []string{CodeUndefinedLabel}, 1},
+		{"f(a: 1, 2); let b = 1;", []string{CodeArgumentOrder}, 2},
+		{"do { 1 } until (x); let b = 1;", []string{CodeUnexpectedToken}, 2},
+		{"while x { 1 }\nlet b = 1;", []string{CodeUnexpectedToken}, 1},
+		{"let h = {1: };\nlet a = 1;", []string{CodeExpectedExpression}, 2},
+		{"let h = {1: 2 3};\nlet a = 1;", []string{CodeUnexpectedToken}, 2},
+		{"let h = {1: } + 2;\nlet a = 1;", []string{CodeExpectedExpression}, 2},
+		{"let h = [{1: }, 2];\nlet a = 1;", []string{CodeExpectedExpression}, 2},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) != len(tt.expectedCodes) {
+			t.Errorf("wrong number of diagnostics for %q. expected=%d, got=%d (%v)",
+				tt.input, len(tt.expectedCodes), len(diagnostics), p.Errors())
+			continue
+		}
+
+		for i, code := range tt.expectedCodes {
+			if diagnostics[i].Code != code {
+				t.Errorf("wrong diagnostic code for %q. expected=%q, got=%q",
+					tt.input, code, diagnostics[i].Code)
+			}
+
+			if diagnostics[i].Severity != SeverityError {
+				t.Errorf("wrong severity for %q. got=%s", tt.input, diagnostics[i].Severity)
+			}
+		}
+
+		if len(program.Statements) != tt.expectedStatements {
+			t.Errorf("wrong number of statements for %q. expected=%d, got=%d",
+				tt.input, tt.expectedStatements, len(program.Statements))
+		}
+	}
+}
+
+func TestDiagnosticSuggestedFixes(t *testing.T) {
+	tests := []struct {
+		input               string
+		expectedReplacement string
+		expectedOffset      int
+	}{
+		{"add(1, 2", ")", 8},
+		{"[1, 2", "]", 5},
+		{"fn() { 1", "}", 8},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) != 1 {
+			t.Fatalf("expected 1 diagnostic for %q. got=%d (%v)", tt.input, len(diagnostics), p.Errors())
+		}
+
+		fix := diagnostics[0].Fix
+		if fix == nil {
+			t.Fatalf("expected a suggested fix for %q", tt.input)
+		}
+
+		if fix.Replacement != tt.expectedReplacement {
+			t.Errorf("wrong fix for %q. expected=%q, got=%q", tt.input, tt.expectedReplacement, fix.Replacement)
+		}
+
+		if fix.Span.Start.Offset != tt.expectedOffset || fix.Span.End.Offset != tt.expectedOffset {
+			t.Errorf("wrong fix position for %q. expected=%d, got=%d-%d",
+				tt.input, tt.expectedOffset, fix.Span.Start.Offset, fix.Span.End.Offset)
+		}
+	}
+}
+
+func TestDiagnosticRelatedSpans(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedOffset int
+		expectedText   string
+	}{
+		{"add(1, 2", 3, "to match this '('"},
+		{"[1, 2", 0, "to match this '['"},
+		{`let h = {"a": 1`, 8, "to match this '{'"},
+		{"(1 + 2", 0, "to match this '('"},
+		{"a[1", 1, "to match this '['"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) != 1 {
+			t.Fatalf("expected 1 diagnostic for %q. got=%d (%v)", tt.input, len(diagnostics), p.Errors())
+		}
+
+		related := diagnostics[0].Related
+		if len(related) != 1 {
+			t.Fatalf("expected 1 related span for %q. got=%d", tt.input, len(related))
+		}
+
+		if related[0].Span.Start.Offset != tt.expectedOffset {
+			t.Errorf("wrong related position for %q. expected=%d, got=%d",
+				tt.input, tt.expectedOffset, related[0].Span.Start.Offset)
+		}
+
+		if related[0].Message != tt.expectedText {
+			t.Errorf("wrong related message for %q. expected=%q, got=%q", tt.input, tt.expectedText, related[0].Message)
+		}
+	}
+}
+
+func TestNodeSpans(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedStart int
+		expectedEnd   int
+	}{
+		{"foobar", 0, 6},
+		{"  1 + 2 * 3", 2, 11},
+		{"add(1, 2)", 0, 9},
+		{"[1, 2][0]", 0, 9},
+		{`{"a": 1}`, 0, 8},
+		{"fn(x) { x }", 0, 11},
+		{"if (x) { 1 } else { 2 }", 0, 23},
+		{"let x = 5;", 0, 9},
+		{"for (;;) { x }", 0, 14},
+		{"try { x } catch (e) { y } finally { z }", 0, 39},
+		{"throw x;", 0, 7},
+		{"fn f(x) { x }", 0, 13},
+	}
+
+	for _, tt := range tests {
+		program := parseAndCheckErrors(tt.input, t)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+		}
+
+		span := program.Statements[0].Span()
+		if span.Start.Offset != tt.expectedStart || span.End.Offset != tt.expectedEnd {
+			t.Errorf("wrong span for %q. expected=%d-%d, got=%d-%d",
+				tt.input, tt.expectedStart, tt.expectedEnd, span.Start.Offset, span.End.Offset)
+		}
+	}
+}
+
 func testIntegerLiteral(t *testing.T, exp ast.Expression, value int64) bool {
 	intLit, ok := exp.(*ast.IntegerLiteral)
 	if !ok {
@@ -1261,8 +2186,13 @@ func testLetStatement(t *testing.T, s ast.Statement, name string, value interfac
 		return false
 	}
 
-	if letStatement.Name.Value != name {
-		t.Fatalf("letStatement.Name was not '%s', got=%s", name, letStatement.Name.Value)
+	ident, ok := letStatement.Name.(*ast.Identifier)
+	if !ok {
+		t.Fatalf("letStatement.Name not *ast.Identifier, got=%T", letStatement.Name)
+	}
+
+	if ident.Value != name {
+		t.Fatalf("letStatement.Name was not '%s', got=%s", name, ident.Value)
 	}
 
 	if letStatement.Name.TokenLiteral() != name {
@@ -0,0 +1,88 @@
+package parser
+
+import (
+	"monkey/ast"
+)
+
+// markTailCalls marks the calls in tail position in the body of a function:
+// those whose value becomes the value of the function, with nothing left for
+// it to do afterwards. That is the call the body ends with, followed through
+// if and match branches, and a call given to return anywhere in the body but
+// a C style for loop, where return ends only the loop.
+// Nothing inside a try is in tail position, since the try still has to handle
+// what the call raises, and nested functions are marked on their own.
+func markTailCalls(body *ast.BlockStatement) {
+	markTail(body)
+	markReturns(body)
+}
+
+// markTail marks the calls that give the value of node.
+func markTail(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.CallExpression:
+		node.Tail = true
+
+	case *ast.BlockStatement:
+		if node != nil && len(node.Statements) > 0 {
+			markTail(node.Statements[len(node.Statements)-1])
+		}
+
+	case *ast.ExpressionStatement:
+		markTail(node.Expression)
+
+	case *ast.ReturnStatement:
+		markTail(node.ReturnValue)
+
+	case *ast.IfExpression:
+		for _, clause := range node.Clauses {
+			markTail(clause.Consequence)
+		}
+		markTail(node.Alternative)
+
+	case *ast.MatchExpression:
+		for _, arm := range node.Arms {
+			markTail(arm.Body)
+		}
+	}
+}
+
+// markReturns marks the calls given to the return statements found in the
+// blocks of node.
+func markReturns(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.ReturnStatement:
+		markTail(node.ReturnValue)
+
+	case *ast.BlockStatement:
+		if node == nil {
+			return
+		}
+
+		for _, statement := range node.Statements {
+			markReturns(statement)
+		}
+
+	case *ast.ExpressionStatement:
+		markReturns(node.Expression)
+
+	case *ast.LetStatement:
+		markReturns(node.Value)
+
+	case *ast.IfExpression:
+		for _, clause := range node.Clauses {
+			markReturns(clause.Consequence)
+		}
+		markReturns(node.Alternative)
+
+	case *ast.MatchExpression:
+		for _, arm := range node.Arms {
+			markReturns(arm.Body)
+		}
+
+	case *ast.ForInStatement:
+		markReturns(node.Body)
+
+	case *ast.WhileStatement:
+		markReturns(node.Body)
+	}
+}
@@ -4,24 +4,38 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"monkey/ast"
+	"monkey/compiler"
 	"monkey/evaluator"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"monkey/vm"
+	"sort"
 )
 
 const PROMPT = ">>"
 
-func Start(in io.Reader, out io.Writer) {
+// Engine selects how programs are run.
+type Engine string
+
+const (
+	EngineEval Engine = "eval" // the tree-walking evaluator
+	EngineVM   Engine = "vm"   // the bytecode compiler and virtual machine
+)
+
+// Start reads and runs lines until the input ends or the program calls exit.
+// It returns the status the session should exit with.
+func Start(in io.Reader, out io.Writer, engine Engine) int {
 	scanner := bufio.NewScanner(in)
 
-	env := object.NewEnvironment()
+	run := NewRunner(engine, nil)
 
 	for {
 		fmt.Print(PROMPT)
 		scanned := scanner.Scan()
 		if !scanned {
-			return
+			return 0
 		}
 
 		line := scanner.Text()
@@ -34,14 +48,73 @@ func Start(in io.Reader, out io.Writer) {
 			continue
 		}
 
-		evaluated := evaluator.Eval(program, env)
-		if evaluated != nil {
+		evaluated := run(program)
+		if exit, ok := evaluated.(*object.Exit); ok {
+			return int(exit.Code)
+		}
+
+		switch evaluated := evaluated.(type) {
+		case nil:
+		case *object.Error:
+			io.WriteString(out, evaluated.Trace())
+			io.WriteString(out, "\n")
+		default:
 			io.WriteString(out, evaluated.Inspect())
 			io.WriteString(out, "\n")
 		}
 	}
 }
 
+// Runner runs a program and returns its value, an *object.Error if it failed
+// or an *object.Exit if it called exit.
+type Runner func(program *ast.Program) object.Object
+
+// NewRunner returns a Runner for the given engine. Programs run one after the
+// other share their variables, starting with the given globals.
+func NewRunner(engine Engine, globals map[string]object.Object) Runner {
+	names := []string{}
+	for name := range globals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if engine == EngineVM {
+		symbolTable := compiler.NewSymbolTable()
+		constants := []object.Object{}
+		values := make([]object.Object, vm.GlobalsSize)
+
+		for _, name := range names {
+			values[symbolTable.Define(name).Index] = globals[name]
+		}
+
+		return func(program *ast.Program) object.Object {
+			comp := compiler.NewWithState(symbolTable, constants)
+			if err := comp.Compile(program); err != nil {
+				compileErr, ok := err.(*compiler.Error)
+				if !ok {
+					return &object.Error{Message: err.Error()}
+				}
+
+				return &object.Error{Message: compileErr.Message, Span: compileErr.Span}
+			}
+
+			bytecode := comp.Bytecode()
+			constants = bytecode.Constants
+
+			return vm.NewWithGlobals(bytecode, values).Run()
+		}
+	}
+
+	env := object.NewEnvironment()
+	for _, name := range names {
+		env.Add(name, globals[name])
+	}
+
+	return func(program *ast.Program) object.Object {
+		return evaluator.Eval(program, env)
+	}
+}
+
 func printParserErrors(out io.Writer, errors []string) {
 	for _, msg := range errors {
 		io.WriteString(out, "\t"+msg+"\n")
@@ -1,10 +1,106 @@
 package token
 
+import "fmt"
+
 type TokenType string
 
 type Token struct {
 	Type    TokenType
 	Literal string
+	Start   Position // position of the first character of the token
+	End     Position // position just past the last character of the token
+
+	// Comments between the previous token and this one, in source order.
+	// Comments at the end of the input are attached to the EOF token.
+	Comments []Comment
+}
+
+// Span returns the source range covered by the token.
+func (t Token) Span() Span {
+	return Span{Start: t.Start, End: t.End}
+}
+
+// Comment is a // line comment or a /* */ block comment. Text is the comment
+// as written, delimiters included but without the newline ending a line
+// comment.
+type Comment struct {
+	Text  string
+	Start Position
+	End   Position
+}
+
+func (c Comment) Span() Span {
+	return Span{Start: c.Start, End: c.End}
+}
+
+// IsBlock reports whether c is a /* */ comment.
+func (c Comment) IsBlock() bool {
+	return len(c.Text) > 1 && c.Text[1] == '*'
+}
+
+// Position is a location in a source file. Lines and columns start at 1 and
+// columns count characters, the offset is the byte offset from the start of
+// the input.
+type Position struct {
+	Filename string
+	Offset   int
+	Line     int
+	Column   int
+}
+
+// IsValid reports whether the position was set by the lexer.
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	if !p.IsValid() {
+		if p.Filename != "" {
+			return p.Filename
+		}
+		return "-"
+	}
+
+	if p.Filename != "" {
+		return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
+	}
+
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Span is a range of source text. End is exclusive.
+type Span struct {
+	Start Position
+	End   Position
+}
+
+func (s Span) IsValid() bool {
+	return s.Start.IsValid()
+}
+
+func (s Span) String() string {
+	return s.Start.String()
+}
+
+// Join returns the smallest span that covers both s and other. Invalid spans
+// are ignored.
+func (s Span) Join(other Span) Span {
+	if !s.IsValid() {
+		return other
+	}
+	if !other.IsValid() {
+		return s
+	}
+
+	joined := s
+	if other.Start.Offset < joined.Start.Offset {
+		joined.Start = other.Start
+	}
+	if other.End.Offset > joined.End.Offset {
+		joined.End = other.End
+	}
+
+	return joined
 }
 
 const (
@@ -14,6 +110,7 @@ const (
 	// Values
 	IDENT  = "IDENT"  // variable name
 	INT    = "INT"    // Integer
+	FLOAT  = "FLOAT"  // Floating point number
 	STRING = "STRING" // String
 
 	// Operators
@@ -23,12 +120,37 @@ const (
 	BANG     = "!"
 	ASTERISK = "*"
 	SLASH    = "/"
+	PERCENT  = "%"
+	POWER    = "**"
+
+	// Compound assignment
+	PLUS_ASSIGN     = "+="
+	MINUS_ASSIGN    = "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN    = "/="
+	PERCENT_ASSIGN  = "%="
+	INCREMENT       = "++"
+	DECREMENT       = "--"
+
+	// Bitwise
+	AMPERSAND   = "&"
+	PIPE        = "|"
+	CARET       = "^"
+	TILDE       = "~"
+	SHIFT_LEFT  = "<<"
+	SHIFT_RIGHT = ">>"
 
 	// Comparison
 	EQ     = "=="
 	NOT_EQ = "!="
 	LT     = "<"
 	GT     = ">"
+	LT_EQ  = "<="
+	GT_EQ  = ">="
+
+	// Logical
+	AND = "&&"
+	OR  = "||"
 
 	// Delimiters
 	COMMA     = ","
@@ -44,6 +166,7 @@ const (
 	RBRACKET = "]"
 
 	ELLIPSIS = "..."
+	ARROW    = "=>"
 
 	// Keywords
 	FUNCTION = "FUNCTION"
@@ -51,20 +174,40 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	FOR      = "FOR"
+	IN       = "IN"
+	WHILE    = "WHILE"
+	DO       = "DO"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
 	RETURN   = "RETURN"
 	TRUE     = "TRUE"
 	FALSE    = "FALSE"
+	MATCH    = "MATCH"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
+	THROW    = "THROW"
 )
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"if":     IF,
-	"else":   ELSE,
-	"for":    FOR,
-	"return": RETURN,
-	"true":   TRUE,
-	"false":  FALSE,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"if":       IF,
+	"else":     ELSE,
+	"for":      FOR,
+	"in":       IN,
+	"while":    WHILE,
+	"do":       DO,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"return":   RETURN,
+	"true":     TRUE,
+	"false":    FALSE,
+	"match":    MATCH,
+	"try":      TRY,
+	"catch":    CATCH,
+	"finally":  FINALLY,
+	"throw":    THROW,
 }
 
 func LookupIdent(ident string) TokenType {
@@ -0,0 +1,931 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/object"
+	"monkey/token"
+)
+
+const (
+	StackSize   = 1 << 16
+	GlobalsSize = 1 << 16 // global slots are addressed with 2 byte operands
+)
+
+var operators = map[code.Opcode]string{
+	code.OpAdd:          "+",
+	code.OpSub:          "-",
+	code.OpMul:          "*",
+	code.OpDiv:          "/",
+	code.OpMod:          "%",
+	code.OpPow:          "**",
+	code.OpBitAnd:       "&",
+	code.OpBitOr:        "|",
+	code.OpBitXor:       "^",
+	code.OpShiftLeft:    "<<",
+	code.OpShiftRight:   ">>",
+	code.OpEqual:        "==",
+	code.OpNotEqual:     "!=",
+	code.OpLessThan:     "<",
+	code.OpGreaterThan:  ">",
+	code.OpLessEqual:    "<=",
+	code.OpGreaterEqual: ">=",
+	code.OpMinus:        "-",
+	code.OpBang:         "!",
+	code.OpBitNot:       "~",
+}
+
+// VM runs compiled programs. Values, operators and builtins are shared with
+// the evaluator, so a program produces the same result and the same errors
+// whichever engine runs it.
+type VM struct {
+	constants   []object.Object
+	globals     []object.Object // nil marks a global that is not defined yet
+	globalNames []string
+
+	stack []object.Object
+	sp    int // the next free slot, the top of the stack is stack[sp-1]
+
+	frames      []*Frame
+	framesIndex int
+
+	handlers []handler // the tries protecting the code running, innermost last
+
+	budget *evaluator.Budget
+}
+
+// handler is where an error raised inside a try goes.
+type handler struct {
+	framesIndex int // frames above the try's own are unwound
+	sp          int // the stack as it was when the try started
+	catch       int // position of the code handling the error
+}
+
+func New(bytecode *compiler.Bytecode) *VM {
+	return NewWithGlobals(bytecode, make([]object.Object, GlobalsSize))
+}
+
+// NewWithGlobals creates a VM that uses the globals of an earlier run, which
+// is how the REPL keeps them alive between lines.
+func NewWithGlobals(bytecode *compiler.Bytecode, globals []object.Object) *VM {
+	mainClosure := &object.Closure{Fn: bytecode.Main}
+
+	return &VM{
+		constants:   bytecode.Constants,
+		globals:     globals,
+		globalNames: bytecode.GlobalNames,
+
+		stack: make([]object.Object, 256),
+
+		frames:      []*Frame{NewFrame(mainClosure, 0)},
+		framesIndex: 1,
+	}
+}
+
+// Run executes the program and returns its value, which is an *object.Error
+// if it failed.
+func (vm *VM) Run() object.Object {
+	return vm.RunContext(context.Background(), evaluator.Limits{})
+}
+
+// RunContext is Run for programs that can't be trusted to finish. It stops
+// when ctx is done or limits are exceeded, and returns an *object.Halt saying
+// why.
+func (vm *VM) RunContext(ctx context.Context, limits evaluator.Limits) object.Object {
+	return vm.RunBudget(evaluator.NewBudget(ctx, limits))
+}
+
+// RunBudget is RunContext with a budget made by the caller, who can then see
+// from its Stats what the program used.
+func (vm *VM) RunBudget(budget *evaluator.Budget) object.Object {
+	vm.budget = budget
+
+	for {
+		frame := vm.frames[vm.framesIndex-1]
+		frame.ip++
+
+		if err := vm.budget.Step(); err != nil {
+			return vm.halt(err)
+		}
+
+		ip := frame.ip
+		ins := frame.Instructions()
+
+		result, err := vm.execute(frame, ins, ip)
+		if err != nil {
+			if !err.Span.IsValid() {
+				err.Span = frame.cl.Fn.SourceMap.Lookup(ip)
+			}
+
+			if err.Stack == nil {
+				err.Stack = vm.stackTrace(err.Span)
+			}
+
+			if vm.catch(err) {
+				continue
+			}
+
+			return err
+		}
+
+		if result != nil {
+			// Whatever the last instructions allocated is counted too
+			if err := vm.budget.CheckMemory(); err != nil {
+				return vm.halt(err)
+			}
+
+			return result.value
+		}
+	}
+}
+
+// programResult wraps the value of the program, which may itself be nil.
+type programResult struct {
+	value object.Object
+}
+
+func (vm *VM) execute(frame *Frame, ins code.Instructions, ip int) (*programResult, *object.Error) {
+	op := code.Opcode(ins[ip])
+
+	switch op {
+	case code.OpConstant:
+		constIndex := code.ReadUint16(ins[ip+1:])
+		frame.ip += 2
+
+		return nil, vm.push(vm.constants[constIndex])
+
+	case code.OpPop:
+		vm.pop()
+
+	case code.OpPopBelow:
+		count := int(code.ReadUint16(ins[ip+1:]))
+		frame.ip += 2
+
+		top := vm.pop()
+		for i := 0; i < count; i++ {
+			vm.pop()
+		}
+
+		return nil, vm.push(top)
+
+	case code.OpDup:
+		return nil, vm.push(vm.stack[vm.sp-1])
+
+	case code.OpDupTwo:
+		if err := vm.push(vm.stack[vm.sp-2]); err != nil {
+			return nil, err
+		}
+		return nil, vm.push(vm.stack[vm.sp-2])
+
+	case code.OpPushBelow:
+		count := int(code.ReadUint16(ins[ip+1:]))
+		frame.ip += 2
+
+		top := vm.stack[vm.sp-1]
+		copy(vm.stack[vm.sp-count:], vm.stack[vm.sp-1-count:vm.sp-1])
+		vm.stack[vm.sp-1-count] = top
+
+		return nil, nil
+
+	case code.OpEmpty:
+		return nil, vm.push(nil)
+
+	case code.OpNull:
+		return nil, vm.push(evaluator.NULL)
+
+	case code.OpTrue:
+		return nil, vm.push(evaluator.TRUE)
+
+	case code.OpFalse:
+		return nil, vm.push(evaluator.FALSE)
+
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod, code.OpPow,
+		code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShiftLeft, code.OpShiftRight,
+		code.OpEqual, code.OpNotEqual, code.OpLessThan, code.OpGreaterThan,
+		code.OpLessEqual, code.OpGreaterEqual:
+		right := vm.pop()
+		left := vm.pop()
+
+		return nil, vm.pushResult(vm.allocate(vm.executeBinaryOperation(op, left, right)))
+
+	case code.OpMinus, code.OpBang, code.OpBitNot:
+		right := vm.pop()
+
+		return nil, vm.pushResult(evaluator.EvalPrefixOperator(operators[op], right))
+
+	case code.OpJump:
+		frame.ip = int(code.ReadUint16(ins[ip+1:])) - 1
+
+	case code.OpJumpNotTruthy:
+		frame.ip += 2
+
+		condition := vm.pop()
+		if !evaluator.IsTruthy(condition) {
+			frame.ip = int(code.ReadUint16(ins[ip+1:])) - 1
+		}
+
+	case code.OpJumpNotEmpty:
+		frame.ip += 2
+
+		if vm.stack[vm.sp-1] != nil {
+			frame.ip = int(code.ReadUint16(ins[ip+1:])) - 1
+		}
+
+	case code.OpGetGlobal:
+		globalIndex := code.ReadUint16(ins[ip+1:])
+		frame.ip += 2
+
+		value, err := vm.getGlobal(int(globalIndex))
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, vm.push(value)
+
+	case code.OpDefineGlobal:
+		globalIndex := code.ReadUint16(ins[ip+1:])
+		frame.ip += 2
+
+		value := vm.pop()
+		if vm.globals[globalIndex] != nil {
+			return nil, newError("identifier already exists: %s", vm.globalNames[globalIndex])
+		}
+
+		if value == nil {
+			return nil, emptyValueError()
+		}
+
+		vm.globals[globalIndex] = value
+
+	case code.OpSetGlobal:
+		globalIndex := code.ReadUint16(ins[ip+1:])
+		frame.ip += 2
+
+		if vm.globals[globalIndex] == nil {
+			return nil, identifierNotFoundError(vm.globalNames[globalIndex])
+		}
+
+		value := vm.stack[vm.sp-1]
+		if value == nil {
+			return nil, emptyValueError()
+		}
+
+		vm.globals[globalIndex] = value
+
+	case code.OpGetLocal:
+		localIndex := code.ReadUint16(ins[ip+1:])
+		frame.ip += 2
+
+		cell := frame.locals[localIndex]
+		if cell == nil || cell.Value == nil {
+			return nil, identifierNotFoundError(frame.cl.Fn.LocalNames[localIndex])
+		}
+
+		return nil, vm.push(cell.Value)
+
+	case code.OpDefineLocal:
+		localIndex := code.ReadUint16(ins[ip+1:])
+		frame.ip += 2
+
+		value := vm.pop()
+
+		cell := frame.locals[localIndex]
+		if cell != nil && cell.Value != nil {
+			return nil, newError("identifier already exists: %s", frame.cl.Fn.LocalNames[localIndex])
+		}
+
+		if value == nil {
+			return nil, emptyValueError()
+		}
+
+		if cell == nil {
+			frame.locals[localIndex] = &object.Cell{Value: value}
+		} else {
+			cell.Value = value
+		}
+
+	case code.OpSetLocal:
+		localIndex := code.ReadUint16(ins[ip+1:])
+		frame.ip += 2
+
+		cell := frame.locals[localIndex]
+		if cell == nil || cell.Value == nil {
+			return nil, identifierNotFoundError(frame.cl.Fn.LocalNames[localIndex])
+		}
+
+		return nil, vm.setCell(cell)
+
+	case code.OpClearLocals:
+		first := int(code.ReadUint16(ins[ip+1:]))
+		count := int(code.ReadUint16(ins[ip+3:]))
+		frame.ip += 4
+
+		for i := first; i < first+count; i++ {
+			frame.locals[i] = nil
+		}
+
+	case code.OpGetFree:
+		freeIndex := code.ReadUint8(ins[ip+1:])
+		frame.ip += 1
+
+		cell := frame.cl.Free[freeIndex]
+		if cell.Value == nil {
+			return nil, identifierNotFoundError(frame.cl.Fn.Captures[freeIndex].Name)
+		}
+
+		return nil, vm.push(cell.Value)
+
+	case code.OpSetFree:
+		freeIndex := code.ReadUint8(ins[ip+1:])
+		frame.ip += 1
+
+		cell := frame.cl.Free[freeIndex]
+		if cell.Value == nil {
+			return nil, identifierNotFoundError(frame.cl.Fn.Captures[freeIndex].Name)
+		}
+
+		return nil, vm.setCell(cell)
+
+	case code.OpArray:
+		numElements := int(code.ReadUint16(ins[ip+1:]))
+		frame.ip += 2
+
+		elements := make([]object.Object, numElements)
+		copy(elements, vm.stack[vm.sp-numElements:vm.sp])
+		vm.sp -= numElements
+
+		return nil, vm.push(vm.allocate(&object.Array{Elements: elements}))
+
+	case code.OpHash:
+		numElements := int(code.ReadUint16(ins[ip+1:]))
+		frame.ip += 2
+
+		hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
+		if err != nil {
+			return nil, err
+		}
+		vm.sp -= numElements
+
+		return nil, vm.push(vm.allocate(hash))
+
+	case code.OpIndex:
+		index := vm.pop()
+		left := vm.pop()
+
+		return nil, vm.pushResult(evaluator.EvalIndex(left, index))
+
+	case code.OpSetIndex:
+		value := vm.pop()
+		index := vm.pop()
+		left := vm.pop()
+
+		before := evaluator.SizeOf(left)
+		result := evaluator.EvalIndexAssignment(left, index, value)
+		vm.budget.Allocate(evaluator.SizeOf(left) - before)
+
+		return nil, vm.pushResult(result)
+
+	case code.OpSpreadArray:
+		value := vm.pop()
+		array := vm.stack[vm.sp-1].(*object.Array)
+
+		items, res := evaluator.SpreadItems(value, vm.budget)
+		switch res := res.(type) {
+		case *object.Error:
+			return nil, res
+		case *object.Halt:
+			return &programResult{value: vm.halt(res.Err)}, nil
+		}
+
+		// The array was made for this literal, so it can be added to in place
+		before := evaluator.SizeOf(array)
+		array.Elements = append(array.Elements, items...)
+		vm.budget.Allocate(evaluator.SizeOf(array) - before)
+
+	case code.OpSpreadHash:
+		value := vm.pop()
+		hash := vm.stack[vm.sp-1].(*object.Hash)
+
+		before := evaluator.SizeOf(hash)
+		err := evaluator.SpreadPairs(hash.Pairs, value)
+		vm.budget.Allocate(evaluator.SizeOf(hash) - before)
+
+		return nil, err
+
+	case code.OpIterator:
+		iterator, err := evaluator.NewIterator(vm.pop())
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, vm.push(iterator)
+
+	case code.OpIterNext:
+		count := int(code.ReadUint8(ins[ip+1:]))
+		exit := int(code.ReadUint16(ins[ip+2:]))
+		frame.ip += 3
+
+		iterator := vm.stack[vm.sp-1].(*evaluator.Iterator)
+
+		values, ok := iterator.Next(count)
+		if !ok {
+			frame.ip = exit - 1
+			return nil, nil
+		}
+
+		for _, value := range values {
+			if err := vm.push(value); err != nil {
+				return nil, err
+			}
+		}
+
+	case code.OpArrayPattern:
+		required := int(code.ReadUint16(ins[ip+1:]))
+		count := int(code.ReadUint16(ins[ip+3:]))
+		rest := code.ReadUint8(ins[ip+5:]) == 1
+		frame.ip += 5
+
+		if err := evaluator.CheckArrayPattern(vm.stack[vm.sp-1], required, count, rest); err != nil {
+			return nil, err
+		}
+
+	case code.OpArrayElement:
+		index := int(code.ReadUint16(ins[ip+1:]))
+		frame.ip += 2
+
+		array := vm.stack[vm.sp-1].(*object.Array)
+		return nil, vm.push(evaluator.ArrayPatternElement(array, index))
+
+	case code.OpArrayRest:
+		index := int(code.ReadUint16(ins[ip+1:]))
+		frame.ip += 2
+
+		array := vm.stack[vm.sp-1].(*object.Array)
+		return nil, vm.push(vm.allocate(evaluator.ArrayPatternRest(array, index)))
+
+	case code.OpHashPattern:
+		if err := evaluator.CheckHashPattern(vm.stack[vm.sp-1]); err != nil {
+			return nil, err
+		}
+
+	case code.OpHashElement:
+		key := vm.constants[code.ReadUint16(ins[ip+1:])].(*object.String)
+		optional := code.ReadUint8(ins[ip+3:]) == 1
+		frame.ip += 3
+
+		hash := vm.stack[vm.sp-1].(*object.Hash)
+
+		value, err := evaluator.HashPatternElement(hash, key.Value, optional)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, vm.push(value)
+
+	case code.OpMatch:
+		patternIndex := code.ReadUint16(ins[ip+1:])
+		frame.ip += 5
+
+		pattern := vm.constants[patternIndex].(*object.MatchPattern)
+
+		values, ok := evaluator.MatchPattern(pattern.Pattern, vm.stack[vm.sp-1], vm.budget)
+		if !ok {
+			frame.ip = int(code.ReadUint16(ins[ip+4:])) - 1
+			return nil, nil
+		}
+
+		for _, value := range values {
+			if err := vm.push(value); err != nil {
+				return nil, err
+			}
+		}
+
+	case code.OpNoMatch:
+		return nil, evaluator.NoMatchError(vm.pop())
+
+	case code.OpTry:
+		catch := int(code.ReadUint16(ins[ip+1:]))
+		frame.ip += 2
+
+		vm.handlers = append(vm.handlers, handler{framesIndex: vm.framesIndex, sp: vm.sp, catch: catch})
+
+	case code.OpEndTry:
+		vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+	case code.OpThrow:
+		return nil, evaluator.ThrowError(vm.pop())
+
+	case code.OpError:
+		messageIndex := code.ReadUint16(ins[ip+1:])
+		frame.ip += 2
+
+		return nil, newError("%s", vm.constants[messageIndex].(*object.String).Value)
+
+	case code.OpCall, code.OpTailCall:
+		numArgs := code.ReadUint8(ins[ip+1:])
+		frame.ip += 1
+
+		return vm.executeCall(int(numArgs), nil, op == code.OpTailCall)
+
+	case code.OpCallNamed, code.OpTailCallNamed:
+		numArgs := code.ReadUint8(ins[ip+1:])
+		namesIndex := code.ReadUint16(ins[ip+2:])
+		frame.ip += 3
+
+		return vm.executeCall(int(numArgs), vm.argumentNames(int(namesIndex)), op == code.OpTailCallNamed)
+
+	case code.OpCallSpread, code.OpTailCallSpread:
+		numNamed := int(code.ReadUint8(ins[ip+1:]))
+		namesIndex := code.ReadUint16(ins[ip+2:])
+		frame.ip += 3
+
+		named := make([]object.Object, numNamed)
+		copy(named, vm.stack[vm.sp-numNamed:vm.sp])
+		positional := vm.stack[vm.sp-numNamed-1].(*object.Array).Elements
+		vm.sp -= numNamed + 1
+
+		for _, arg := range append(positional, named...) {
+			if err := vm.push(arg); err != nil {
+				return nil, err
+			}
+		}
+
+		return vm.executeCall(len(positional)+numNamed, vm.argumentNames(int(namesIndex)), op == code.OpTailCallSpread)
+
+	case code.OpNameFunction:
+		nameIndex := code.ReadUint16(ins[ip+1:])
+		frame.ip += 2
+
+		evaluator.NameFunction(vm.stack[vm.sp-1], vm.constants[nameIndex].(*object.String).Value)
+
+	case code.OpJumpHasArgument:
+		localIndex := code.ReadUint16(ins[ip+1:])
+		frame.ip += 4
+
+		if cell := frame.locals[localIndex]; cell != nil && cell.Value != nil {
+			frame.ip = int(code.ReadUint16(ins[ip+3:])) - 1
+		}
+
+	case code.OpReturnValue:
+		returnValue := vm.pop()
+
+		if vm.framesIndex == 1 {
+			return &programResult{value: returnValue}, nil
+		}
+
+		frame := vm.popFrame()
+		vm.sp = frame.basePointer
+
+		return nil, vm.push(returnValue)
+
+	case code.OpClosure:
+		constIndex := code.ReadUint16(ins[ip+1:])
+		frame.ip += 2
+
+		return nil, vm.push(vm.newClosure(frame, int(constIndex)))
+
+	default:
+		return nil, newError("unknown opcode: %d", op)
+	}
+
+	return nil, nil
+}
+
+// catch hands err to the innermost try protecting the code that raised it,
+// unwinding the frames and the stack back to where the try started. It
+// reports whether there was such a try.
+func (vm *VM) catch(err *object.Error) bool {
+	if len(vm.handlers) == 0 {
+		return false
+	}
+
+	h := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+	for vm.framesIndex > h.framesIndex {
+		vm.popFrame()
+	}
+
+	for vm.sp > h.sp {
+		vm.pop()
+	}
+
+	vm.frames[vm.framesIndex-1].ip = h.catch - 1
+
+	return vm.push(&object.ErrorValue{Err: err}) == nil
+}
+
+func (vm *VM) executeBinaryOperation(op code.Opcode, left, right object.Object) object.Object {
+	leftInt, leftOk := left.(*object.Integer)
+	rightInt, rightOk := right.(*object.Integer)
+
+	// Fast path for the most common case, everything else is left to the
+	// evaluator's implementation
+	if leftOk && rightOk {
+		leftVal, rightVal := leftInt.Value, rightInt.Value
+
+		switch op {
+		case code.OpAdd:
+			return &object.Integer{Value: leftVal + rightVal}
+		case code.OpSub:
+			return &object.Integer{Value: leftVal - rightVal}
+		case code.OpMul:
+			return &object.Integer{Value: leftVal * rightVal}
+		case code.OpLessThan:
+			return nativeBoolToBooleanObject(leftVal < rightVal)
+		case code.OpGreaterThan:
+			return nativeBoolToBooleanObject(leftVal > rightVal)
+		case code.OpLessEqual:
+			return nativeBoolToBooleanObject(leftVal <= rightVal)
+		case code.OpGreaterEqual:
+			return nativeBoolToBooleanObject(leftVal >= rightVal)
+		case code.OpEqual:
+			return nativeBoolToBooleanObject(leftVal == rightVal)
+		case code.OpNotEqual:
+			return nativeBoolToBooleanObject(leftVal != rightVal)
+		}
+	}
+
+	return evaluator.EvalInfixOperator(operators[op], left, right)
+}
+
+func (vm *VM) getGlobal(index int) (object.Object, *object.Error) {
+	if value := vm.globals[index]; value != nil {
+		return value, nil
+	}
+
+	name := vm.globalNames[index]
+	if builtin, ok := evaluator.LookupBuiltin(name); ok {
+		return builtin, nil
+	}
+
+	return nil, identifierNotFoundError(name)
+}
+
+// setCell assigns the value on top of the stack, leaving it there as the
+// result of the assignment.
+func (vm *VM) setCell(cell *object.Cell) *object.Error {
+	value := vm.stack[vm.sp-1]
+	if value == nil {
+		return emptyValueError()
+	}
+
+	cell.Value = value
+	return nil
+}
+
+func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, *object.Error) {
+	pairs := make(map[object.HashKey]object.HashPair)
+
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return nil, newError("unusable as hash key: %s", key.Type())
+		}
+
+		pairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: value}
+	}
+
+	return &object.Hash{Pairs: pairs}, nil
+}
+
+// argumentNames returns the names in the constant at index, which name the
+// last arguments of a call.
+func (vm *VM) argumentNames(index int) []string {
+	elements := vm.constants[index].(*object.Array).Elements
+
+	names := make([]string, len(elements))
+	for i, name := range elements {
+		names[i] = name.(*object.String).Value
+	}
+
+	return names
+}
+
+// executeCall calls the function below the numArgs arguments on top of the
+// stack, the last len(names) of which were given by name. A tail call of a
+// closure replaces the frame making it, see callClosure.
+func (vm *VM) executeCall(numArgs int, names []string, tail bool) (*programResult, *object.Error) {
+	callee := vm.stack[vm.sp-1-numArgs]
+
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return nil, vm.callClosure(callee, numArgs, names, tail)
+
+	case *object.Builtin:
+		if len(names) > 0 {
+			return nil, newError("builtin functions do not take named arguments")
+		}
+
+		// A builtin counts as a call while it runs, as in the evaluator,
+		// though it has no frame of its own
+		if err := vm.budget.EnterCall(vm.framesIndex - 1); err != nil {
+			return nil, evaluator.CallDepthError()
+		}
+
+		args := make([]object.Object, numArgs)
+		copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+
+		vm.sp = vm.sp - numArgs - 1
+
+		result := callee.Fn(args...)
+		vm.budget.Allocate(evaluator.BuiltinAllocation(result, args))
+
+		if err := vm.budget.Spend(evaluator.BuiltinSteps(result, args)); err != nil {
+			return &programResult{value: vm.halt(err)}, nil
+		}
+
+		if exit, ok := result.(*object.Exit); ok {
+			// The program ends here, however deep the call stack is
+			return &programResult{value: exit}, nil
+		}
+
+		// The builtin has no frame of its own, so its place in the stack
+		// trace is added here while the error is still known to be its
+		if err, ok := result.(*object.Error); ok && err.Stack == nil {
+			frame := vm.frames[vm.framesIndex-1]
+			if !err.Span.IsValid() {
+				err.Span = frame.cl.Fn.SourceMap.Lookup(frame.ip)
+			}
+
+			builtinFrame := object.StackFrame{Function: callee.Name}
+			err.Stack = append([]object.StackFrame{builtinFrame}, vm.stackTrace(err.Span)...)
+		}
+
+		return nil, vm.pushResult(result)
+
+	default:
+		return nil, newError("not a function: %s", callee.Type())
+	}
+}
+
+// callClosure starts a call of cl with the numArgs arguments on top of the
+// stack. A tail call leaves the function making it nothing to do but return
+// what cl returns, so, as in the evaluator, cl takes over that function's
+// frame and the calls don't nest any deeper.
+func (vm *VM) callClosure(cl *object.Closure, numArgs int, names []string, tail bool) *object.Error {
+	params := cl.Fn.Parameters
+
+	values, err := evaluator.MatchArguments(cl.FunctionName(), params, vm.stack[vm.sp-numArgs:vm.sp], names)
+	if err != nil {
+		return err
+	}
+
+	vm.budget.Allocate(evaluator.ArgumentAllocation(params, values))
+
+	basePointer := vm.sp - 1 - numArgs
+	if tail {
+		basePointer = vm.popFrame().basePointer
+		vm.stack[basePointer] = cl
+	} else if err := vm.budget.EnterCall(vm.framesIndex - 1); err != nil {
+		return evaluator.CallDepthError()
+	}
+
+	frame := NewFrame(cl, basePointer)
+	vm.budget.Allocate(evaluator.EnvironmentSize(len(frame.locals)))
+
+	// Parameters left to their defaults stay unset, the function fills them
+	// in itself
+	for i, value := range values {
+		if value != nil || params[i].Default == nil {
+			frame.locals[i] = &object.Cell{Value: value}
+		}
+	}
+
+	// The arguments now live in the frame, only the callee stays on the stack
+	vm.sp = frame.basePointer + 1
+	vm.pushFrame(frame)
+
+	return nil
+}
+
+func (vm *VM) newClosure(frame *Frame, constIndex int) object.Object {
+	function := vm.constants[constIndex].(*object.CompiledFunction)
+
+	free := make([]*object.Cell, len(function.Captures))
+	for i, capture := range function.Captures {
+		if !capture.Local {
+			free[i] = frame.cl.Free[capture.Index]
+			continue
+		}
+
+		// Variables are captured by reference, so a variable that is not
+		// defined yet needs its cell now
+		cell := frame.locals[capture.Index]
+		if cell == nil {
+			cell = &object.Cell{}
+			frame.locals[capture.Index] = cell
+		}
+
+		free[i] = cell
+	}
+
+	return &object.Closure{Fn: function, Free: free}
+}
+
+func (vm *VM) pushFrame(f *Frame) {
+	if vm.framesIndex < len(vm.frames) {
+		vm.frames[vm.framesIndex] = f
+	} else {
+		vm.frames = append(vm.frames, f)
+	}
+
+	vm.framesIndex++
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+
+	frame := vm.frames[vm.framesIndex]
+	vm.frames[vm.framesIndex] = nil
+
+	return frame
+}
+
+func (vm *VM) push(o object.Object) *object.Error {
+	if vm.sp >= len(vm.stack) {
+		if len(vm.stack) >= StackSize {
+			return newError("stack overflow")
+		}
+
+		stack := make([]object.Object, len(vm.stack)*2)
+		copy(stack, vm.stack)
+		vm.stack = stack
+	}
+
+	vm.stack[vm.sp] = o
+	vm.sp++
+
+	return nil
+}
+
+// halt stops the program at the instruction being executed, see RunContext.
+func (vm *VM) halt(err error) *object.Halt {
+	frame := vm.frames[vm.framesIndex-1]
+	return &object.Halt{Err: err, Span: frame.cl.Fn.SourceMap.Lookup(frame.ip)}
+}
+
+// stackTrace lists the calls active when an error is raised at span, innermost
+// first and ending with the program itself. Each frame holds the position its
+// function had reached.
+func (vm *VM) stackTrace(span token.Span) []object.StackFrame {
+	stack := make([]object.StackFrame, 0, vm.framesIndex)
+
+	for i := vm.framesIndex - 1; i > 0; i-- {
+		stack = append(stack, object.StackFrame{Function: evaluator.FunctionName(vm.frames[i].cl), Span: span})
+
+		caller := vm.frames[i-1]
+		span = caller.cl.Fn.SourceMap.Lookup(caller.ip)
+	}
+
+	return append(stack, object.StackFrame{Function: evaluator.MainFunctionName, Span: span})
+}
+
+// allocate records the memory taken up by o, which has just been made, and
+// returns it.
+func (vm *VM) allocate(o object.Object) object.Object {
+	vm.budget.Allocate(evaluator.SizeOf(o))
+	return o
+}
+
+// pushResult pushes the result of an operation, unless it is an error.
+func (vm *VM) pushResult(o object.Object) *object.Error {
+	if err, ok := o.(*object.Error); ok {
+		return err
+	}
+
+	return vm.push(o)
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.stack[vm.sp-1] = nil
+	vm.sp--
+
+	return o
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return evaluator.TRUE
+	}
+	return evaluator.FALSE
+}
+
+func newError(format string, args ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, args...)}
+}
+
+func identifierNotFoundError(name string) *object.Error {
+	return newError("identifier not found: %s", name)
+}
+
+func emptyValueError() *object.Error {
+	return newError("cannot assign empty value to variable")
+}
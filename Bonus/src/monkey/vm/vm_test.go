@@ -0,0 +1,1123 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The VM must give the same results and errors as the evaluator, so the
+// evaluator's own test programs are run through both engines and compared.
+func TestSameResultsAsEvaluator(t *testing.T) {
+	inputs := []string{
+		// EvalIntegerExpression
+		"5",
+		"10",
+		"-5",
+		"-10",
+		"5 + 5 + 5 + 5 - 10",
+		"2 * 2 * 2 * 2 * 2",
+		"-50 + 100 + -50",
+		"5 * 2 + 10",
+		"5 + 2 * 10",
+		"20 + 2 * -10",
+		"50 / 2 * 2 + 10",
+		"2 * (5 + 10)",
+		"3 * 3 * 3 + 10",
+		"3 * (3 * 3) + 10",
+		"(5 + 10 * 2 + 15 / 3) * 2 + -10",
+
+		// EvalBooleanExpression
+		"true",
+		"false",
+		"1 < 2",
+		"1 > 2",
+		"1 < 1",
+		"1 > 1",
+		"1 == 1",
+		"1 != 1",
+		"1 == 2",
+		"1 != 2",
+		"true == true",
+		"false == false",
+		"true == false",
+		"true != false",
+		"false != true",
+		"(1 < 2) == true",
+		"(1 < 2) == false",
+		"(1 > 2) == true",
+		"(1 > 2) == false",
+		`"a" == "a"`,
+		`"a" == "b"`,
+		`"a" != "a"`,
+		`"a" != "b"`,
+		`"a" < "b"`,
+		`"a" < "a"`,
+		`"a" > "b"`,
+		`"a" > "a"`,
+
+		// BangOperator
+		"!true",
+		"!false",
+		"!5",
+		"!!true",
+		"!!false",
+		"!!5",
+
+		// IfElseExpressions
+		"if (true) { 10 }",
+		"if (false) { 10 }",
+		"if (1) { 10 }",
+		"if (1 < 2) { 10 }",
+		"if (1 > 2) { 10 }",
+		"if (1 > 2) { 10 } else { 20 }",
+		"if (1 < 2) { 10 } else { 20 }",
+		"if (1 > 2) { 10 } else if (2 > 3) { 20 }",
+		"if (1 > 2) { 10 } else if (2 > 3) { 20 } else { 30 }",
+		"if (1 > 2) { 10 } else if (2 < 3) { 20 } else { 30 }",
+		"if (1 < 2) { 10 } else if (2 < 3) { 20 } else { 30 }",
+
+		// ReturnStatements
+		"return 10;",
+		"return 10; 9;",
+		"return 2 * 5; 9;",
+		"9; return 2 * 5; 9;",
+		`if (10 > 1) {
+				if (10 > 1) {
+					return 10;
+				}
+				return 1;
+			}`,
+		`
+			let i = 0; 
+			for (; i < 10; i = i + 1) { 
+				if (i == 5) { return 1; } 
+			}
+			i;
+			`,
+
+		// ErrorHandling
+		"5 + true;",
+		"5 + true; 5;",
+		"-true",
+		"true + false;",
+		"5; true + false; 5",
+		"if (10 > 1) { true + false; }",
+		`
+			if (10 > 1) {
+				if (10 > 1) {
+					return true + false;
+				}
+				return 1;
+			}
+			`,
+		"foobar",
+		"let a = 5; 5 = 4;",
+		"for (i = 0; i < 10; i = i + 1) {}",
+		"x = 0;",
+		"let x = 0; let x = 0;",
+		"fn(...x, y) { }",
+		"fn(x) { let x = 0; }(1)",
+		"fn(x) { }()",
+		"fn(x, y) { }(1)",
+		"fn() { }(1)",
+		"fn(x) { }(1, 2)",
+		"fn(x, ...y) { }()",
+		"let x = fn() { }()",
+		"let x = if (true) { }",
+		"let x = 5; x = if (true) { }",
+		"let x = for (;;) { break if (true) { } }",
+		`"Hello" - "World"`,
+		`{"name": "Monkey"}[fn(x) { x }];`,
+
+		// ErrorPositions
+		"foobar",
+		"let x = 1;\n  x + true",
+		"let f = fn(x) {\n  x + y\n};\nf(1)",
+		"let x = 0;\nlet x = 1;",
+
+		// LetStatements
+		"let a = 5; a;",
+		"let a = 5 * 5; a;",
+		"let a = 5; let b = a; b;",
+		"let a = 5; let b = a; let c = a + b + 5; c;",
+		"let a = 5; fn() { let a = 4; }(); a;",
+		"let a = 5; if (true) { let a = 4; } a;",
+		`
+			let a = 5;
+			let b = fn() {
+				let a = 4;
+				fn() {
+					a = 5;
+				}();
+				a;
+			}();
+			a + b;
+			`,
+
+		// AssignExpression
+		"let a = 5; a = 4; a",
+		"let a = 5; a = 4;",
+		"let a = 5; fn() { a = 4; }(); a",
+		"let a = 5; if (true) { a = 4; } a",
+		"let a = 5; if (false) { a = 4; } a",
+
+		// FunctionObject
+		"fn(x) { x + 2; };",
+
+		// FunctionApplication
+		"let identity = fn(x) { x; }; identity(5);",
+		"let identity = fn(x) { return x; }; identity(5);",
+		"let double = fn(x) { x * 2; }; double(5);",
+		"let add = fn(x, y) { x + y; }; add(5, 5);",
+		"let add = fn(x, y) { x + y; }; add(5 + 5, add(5, 5));",
+		"fn(x) { x; }(5)",
+		"fn(...x) { x; }()",
+		"fn(...x) { x; }(5)",
+		"fn(...x) { x; }(5, 10)",
+		"fn(x, ...y) { y; }(5)",
+		"fn(x, ...y) { y; }(5, 10)",
+
+		// Closures
+		`
+	let newAdder = fn(x) {
+		fn(y) { x + y };
+	};
+	let addTwo = newAdder(2);
+	addTwo(2);`,
+
+		// ForLoopStatement
+		`
+			for (let i = 0; i < 10; i = i + 1) {
+				i
+			}
+			`,
+		`
+			let x = 0; 
+			for (let i = 0; i < 10; i = i + 1) { 
+				x = x + 1; 
+			} 
+			x;
+			`,
+		`
+			let i = 0; 
+			for (; i < 10; i = i + 1) { } 
+			i;
+			`,
+		`
+			let x = 0; 
+			let i = 0; 
+			for (i = 5; i < 10; i = i + 1) { 
+				x = x + 1; 
+			} 
+			x;
+			`,
+		`
+			let i = 0; 
+			for (i = 0; i < 10;) { 
+				i = i + 1 
+			} 
+			i;
+			`,
+		`
+			let x = 0; 
+			let i = 5; 
+			for (let i = 0; i < 10; i = i + 1) { 
+				x = x + 1; 
+			} 
+			x;
+			`,
+		`
+			let x = 0;
+			for (let i = 0; i < 10; i = i + 1) { 
+				for (let i = 0; i < 10; i = i + 1) { 
+					x = x + 1; 
+				} 
+			} 
+			x;
+			`,
+
+		// StringLiteral
+		`"Hello World!"`,
+
+		// StringConcatenation
+		`"Hello" + " " + "World!"`,
+
+		// BuiltinFunctions
+		`len("")`,
+		`len("four")`,
+		`len("hello world")`,
+		`len([])`,
+		`len([1])`,
+		`len([1, 2])`,
+		`len(1)`,
+		`len("one", "two")`,
+		`first("foobar")`,
+		`first()`,
+		`first([], [])`,
+		`first([])`,
+		`first([1])`,
+		`first([1, 2])`,
+		`last("foobar")`,
+		`last()`,
+		`last([], [])`,
+		`last([])`,
+		`last([1])`,
+		`last([1, 2])`,
+		`rest("foobar")`,
+		`rest()`,
+		`rest([], [])`,
+		`rest([])`,
+		`rest([1])`,
+		`rest([1, 2])`,
+		`push("foobar", [])`,
+		`push()`,
+		`push([])`,
+		`push([], 1)`,
+		`push([1], 2)`,
+		`push([1], "foobar")`,
+		`push([1], [2])`,
+		`pop("foobar")`,
+		`pop()`,
+		`pop([], [])`,
+		`pop([])`,
+		`pop([1])`,
+		`pop([1, 2])`,
+		`slice([1, 2, 3], 1)`,
+		`slice([1, 2, 3], 2, 4)`,
+
+		// BreakAndContinue
+		"let i = 0; for (; i < 10; i = i + 1) { if (i == 3) { break; } }; i",
+		"let i = 0; for (; i < 10; i = i + 1) break; i",
+		"let x = 0; for (let i = 0; i < 10; i = i + 1) { if (i % 2 == 0) { continue } x = x + i }; x",
+		"let x = 0; for (let i = 0; i < 5; i = i + 1) { if (i > 0) { if (i < 4) { continue } } x = x + 1 }; x",
+		"let n = 0; for (;;) { n = n + 1; if (n == 7) { break } }; n",
+		"let x = 0; for (let i = 0; i < 3; i = i + 1) { for (let j = 0; j < 3; j = j + 1) { if (j == 1) { break } x = x + 1 } }; x",
+		"let found = 0; outer: for (let i = 1; i < 10; i = i + 1) { for (let j = 1; j < 10; j = j + 1) { if (i * j == 42) { found = i * 10 + j; break outer; } } }; found",
+		"let x = 0; rows: for (let i = 0; i < 3; i = i + 1) { for (let j = 0; j < 3; j = j + 1) { if (j > i) { continue rows } x = x + 1 } }; x",
+		"let f = fn() { for (;;) { break } 5 }; f()",
+		"let x = 0; for (let i = 0; i < 3; i = i + 1) { x = x + fn() { for (;;) { break } 1 }() }; x",
+		"let f = fn(n) { let total = 0; for (let i = 0; i < n; i = i + 1) { if (i == 2) { continue } if (total > 10) { break } total = total + [i, i * 2][1] }; total }; f(10)",
+		// ForInLoops
+		"let t = 0; for (x in [1, 2, 3]) { t = t + x }; t",
+		"let t = 0; for (i, x in [5, 6, 7]) { t = t + i * x }; t",
+		`let s = ""; for (k, v in {"b": 2, "a": 1, "c": 3}) { s = s + k }; s`,
+		`let s = ""; for (i, ch in "héllo") { if (i > 0) { s = s + ch } }; s`,
+		"let t = 0; for (i in range(10, 0, -3)) { t = t + i }; t",
+		"let t = 0; for (x in [1, 2, 3, 4]) { if (x == 2) { continue } if (x == 4) { break } t = t + x }; t",
+		"let found = 0; outer: for (i in range(1, 10)) { for (j in range(1, 10)) { if (i * j == 42) { found = i * 10 + j; break outer; } } }; found",
+		"let fs = []; for (x in [1, 2, 3]) { fs = push(fs, fn() { x }) }; fs[0]() * 100 + fs[1]() * 10 + fs[2]()",
+		"let fs = []; for (x in [1, 2]) { let y = x * 2; fs = push(fs, fn() { y }) }; fs[0]() + fs[1]()",
+		"let f = fn() { for (x in [1, 2, 3]) { if (x == 2) { return x * 10 } } 0 }; f()",
+		"let f = fn() { for (;;) { for (x in [1, 2]) { return x } } 5 }; f()",
+		"let f = fn() { for (x in [1, 2]) { for (let i = 0; i < 1; i = i + 1) { return 3 } } 5 }; f()",
+		"for (x in [1, 2]) { if (x == 2) { return x } }",
+		"let f = fn(xs) { let t = 0; for (x in xs) { t = t + [x, x][1] * 2 }; t }; f(range(4))",
+		"let x = 7; for (x in [1]) { }; x",
+		"for (x in 5) { }",
+		"for (x, x in [1]) { }",
+		"for (x in [1]) { let x = 2 }",
+		"len(range(1, 10, 2))",
+		"range(3)",
+		// WhileAndLoopValues
+		"let i = 0; while (i < 10) { i = i + 1 }; i",
+		"let i = 5; do { i = i + 1 } while (i < 3); i",
+		"let i = 0; let n = 0; while (i < 10) { i = i + 1; if (i % 3 != 0) { continue } n = n + i }; n",
+		"let i = 0; do { i = i + 1; if (i < 5) { continue } break } while (true); i",
+		"let i = 0; outer: while (true) { do { i = i + 1; if (i == 4) { break outer } } while (true) }; i",
+		"let fs = []; let i = 0; while (i < 3) { let j = i; fs = push(fs, fn() { j }); i = i + 1 }; fs[0]() + fs[2]()",
+		"let f = fn() { let i = 0; while (true) { i = i + 1; if (i == 3) { return i * 10 } } }; f()",
+		"let x = for (let i = 0; i < 10; i = i + 1) { if (i * i > 20) { break (i) } }; x",
+		"let x = for (let i = 0; i < 3; i = i + 1) { }; x",
+		"let x = for (n in [3, 8, 5]) { if (n > 4) { break n * 10 } }; x",
+		"let x = for (n in [1, 2]) { if (n > 4) { break (n) } }; x",
+		"for (n in [1, 2]) { break }",
+		"for (n in [1, 2]) { }",
+		"while (false) { }",
+		"let x = do { break 7 } while (true); x",
+		"1 + for (x in range(10)) { if (x > 5) { break (x) } }",
+		"let found = for (i in range(5)) { let r = for (j in range(5)) { if (i * j == 6) { break i * 10 + j } }; if (r) { break (r) } }; found",
+		"let f = fn(xs) { for (x in xs) { if (x < 0) { break x } } }; f([1, -2, 3])",
+		"let f = fn() { outer: for (i in range(5)) { for (j in range(5)) { if (i * j == 6) { break outer i } } } }; f()",
+		"[1, 2, for (x in [3]) { 9 + [x, if (x == 3) { break x * 3 } else { 0 }][1] }]",
+		"let x = for (i in range(3)) { for (j in range(3)) { if (j == 1) { break (j) } } }; x",
+		"let f = fn(xs) { for (x in xs) { if (x < 0) { break (x) } } }; f([1, -2, 3])",
+		"let x = for (;;) { return 5 }; x",
+		"outer: for (i in range(5)) { for (j in range(5)) { if (i * j == 6) { break outer i * 10 + j } } }",
+		"let x = 0; while (x < 3) { x = x + 1 }",
+		// IndexAssignment
+		"let a = [1, 2, 3]; a[1] = 5; a",
+		"let a = [1, 2, 3]; a[0] = a[2] + 1",
+		"let a = [1, 2]; let b = a; b[0] = 9; a",
+		"let a = [1, 2]; let f = fn(xs) { xs[1] = 7 }; f(a); a",
+		"let a = [[1, 2], [3, 4]]; a[1][0] = 0; a",
+		`let cfg = {"db": {"port": 1}}; let db = cfg["db"]; cfg["db"]["port"] = 5432; db["port"]`,
+		`let rows = {}; for (w in ["a", "b", "a"]) { if (rows[w]) { rows[w] = rows[w] + 1 } else { rows[w] = 1 } }; rows`,
+		"let a = [0, 0, 0]; for (i, x in a) { a[i] = i * 2; a[2] = 9 }; a",
+		"let f = fn() { let a = [1]; a[0] = a[0] + 1; a }; f()[0] + f()[0]",
+		"let a = [1, 2, 3]; a[3] = 4",
+		"let a = [1, 2, 3]; a[-1] = 4",
+		`let h = {}; h[[1]] = 4`,
+		`let s = "abc"; s[0] = "x"`,
+		"let a = [1]; a[0] = if (true) { }",
+		"let a = [1]; a[0] = a; match (a) { 5 => 1 }",
+		`let h = {}; h["self"] = h; match (h) { 5 => 1 }`,
+		// CompoundAssignment
+		"let a = 5; a += 2; a -= 1; a *= 3; a /= 4; a %= 3; a",
+		"let a = 1.5; a *= 2; a++; a",
+		`let s = "ab"; s += "cd"; s`,
+		"let a = 5; [a++, a, ++a, a--, --a, a]",
+		"let a = 5; fn() { a += 1; a++ }(); a",
+		"let f = fn() { let n = 0; fn() { n++ } }; let g = f(); g(); g(); g()",
+		"let f = fn(x) { x *= 2; x-- }; f(4)",
+		"let a = [1, 2]; [a[0]++, a[1] += 5, ++a[1], a]",
+		"let a = [1, 2]; let i = 0; a[i++] += 10; [a, i]",
+		`let h = {"n": 1}; h["n"] *= 3; h["n"]++; h`,
+		"let m = [[1]]; m[0][0]++; m[0][0] -= 5; m",
+		"let t = 0; for (let i = 0; i < 5; i++) { t += i }; t",
+		"let t = 0; let i = 10; while (i > 0) { t += i--; }; [t, i]",
+		"let a = 5; a /= 0",
+		`let a = 5; a += "x"`,
+		`let s = "x"; s++`,
+		"let a = [1]; a[1] += 1",
+		"let a = [1]; a[1]++",
+		"b += 1",
+		"b++",
+		"let a = 5; 5 = 4;",
+		"5 += 1",
+		"--5",
+		"let f = fn() { 1 }; f()++",
+		`let log = []; try { log = push(log, 1); 5++; log = push(log, 2) } catch (e) { push(log, e["message"]) }`,
+		"let f = fn(x) { if (x) { 5 = x } x }; f(false)",
+		// Destructuring
+		"let [a, b] = [1, 2]; a * 10 + b",
+		"let [a, [b, c], ...rest] = [1, [2, 3], 4, 5]; [a, b, c, rest]",
+		"let [a, b = a + 1, c = 10] = [1]; [a, b, c]",
+		`let {name, port: p = 80, tls = false} = {"name": "db", "tls": true}; [name, p, tls]`,
+		`let {db: {ports: [first, ...more]}} = {"db": {"ports": [1, 2, 3]}}; [first, more]`,
+		"let f = fn(n, {scale = n}) { n * scale }; [f(2, {}), f(2, {\"scale\": 5})]",
+		"let f = fn({x = y}, y) { x }; f({}, 3)",
+		"let f = fn(a, [b, c], ...d) { [a, b, c, d] }; f(1, [2, 3], 4)",
+		"let f = fn() { let [q, ...r] = [1, 2]; let g = fn() { [q, r] }; g() }; f()",
+		"let t = 0; for ([k, v] in [[1, 2], [3, 4]]) { t += k * v }; t",
+		`let t = []; for (i, {n = i} in [{"n": 5}, {}]) { t = push(t, n) }; t`,
+		"let fs = []; for ([x] in [[1], [2]]) { fs = push(fs, fn() { x }) }; [fs[0](), fs[1]()]",
+		"let a = 1; let b = 2; [a, b] = [b, a]; [a, b]",
+		"let a = 1; let b = 2; let c = [a, b] = [3, 4]; [a, b, c]",
+		`let n = 0; {n} = {"n": 3}; n`,
+		"let f = fn() { let a = 0; let g = fn() { [a] = [5] }; g(); a }; f()",
+		"let [a, b] = [1]",
+		"let [a] = [1, 2]",
+		"let [a, [b]] = [1, 2]",
+		`let {a, b} = {"a": 1}`,
+		"let {a} = [1]",
+		"let [a, a] = [1, 2]",
+		"let [a = b] = []",
+		"let [a] = if (true) { }",
+		"[a] = [1]",
+		"let f = fn(y, [a]) { a }; f(1, 2)",
+		"let [a = 1 + true] = []",
+		// Defaults and named arguments
+		"let f = fn(a, b = a * 2, c = a + b) { [a, b, c] }; [f(1), f(1, 5), f(1, c: 0)]",
+		"let f = fn(a = 1, b) { [a, b] }; f(b: 2)",
+		"let f = fn(host, port = 80) { [host, port] }; f(port: 8080, host: \"x\")",
+		"let f = fn(a, ...rest) { [a, rest] }; [f(a: 1), f(1, 2, 3)]",
+		"let f = fn([a, b] = [1, 2], {x = a} = {}) { [a, b, x] }; [f(), f([3, 4]), f([5, 6], {\"x\": 0})]",
+		"let n = 0; let f = fn(x = n) { x }; n = 5; f()",
+		"let count = 0; let f = fn(x = count += 1) { x }; f(); f(9); f(); count",
+		"let mk = fn(n) { fn(x = fn() { n }) { x() } }; mk(7)()",
+		"let f = fn(a, b = 10) { a + b }; f()",
+		"let f = fn(a, b = 10) { a + b }; f(1, 2, 3)",
+		"let f = fn(a, b = 10) { a + b }; f(b: 1)",
+		"let f = fn(a, b = 10) { a + b }; f(1, c: 2)",
+		"let f = fn(a, b = 10) { a + b }; f(1, a: 2)",
+		"let f = fn(a, b = 10) { a + b }; f(b: 1, b: 2)",
+		"fn f(...xs) { xs }; f(xs: 1)",
+		"let f = fn(a = b, b = 1) { a }; f()",
+		"let f = fn(a = 1 + true) { a }; f()",
+		"len(x: \"a\")",
+		// Spread
+		"let a = [1, 2]; let b = [3]; [[...a, ...b], [0, ...a, 9, ...b, 10], [...[]]]",
+		`[..."ab", ...range(1, 3), ...{"y": 1, "x": 2}]`,
+		"let a = [1]; let b = [...a]; b[0] = 2; [a, b]",
+		"let sum = fn(...xs) { let t = 0; for (x in xs) { t += x }; t }; sum(...[1, 2], 3, ...[4])",
+		"let f = fn(x, y, z = 0) { [x, y, z] }; [f(...[1, 2]), f(...[1], 2, z: 5), f(...[], y: 1, x: 0)]",
+		`len(...["abc"])`,
+		"let f = fn() { let xs = [1, 2]; let g = fn(...ys) { ys }; g(...xs, ...xs) }; f()",
+		`let d = {"host": "x", "port": 80}; [{...d, "port": 8080}, {"port": 1, ...d}, {...d, ...{"tls": true}, "n": 1}]`,
+		`{"a": 1, "a": 2}`,
+		"[...1]",
+		"[1, ...[2], ...3]",
+		"{...[1]}",
+		`{"a": 1, ...{}, ...2}`,
+		"let f = fn(x) { x }; f(...true)",
+		"let f = fn(x) { x }; f(...[1, 2])",
+		"len(...[], x: 1)",
+		// Match
+		`let describe = fn(msg) {
+			match (msg) {
+				0 => "zero",
+				-1 => "minus one",
+				1.5 => "one and a half",
+				"hi" => "greeting",
+				true => "yes",
+				[] => "empty",
+				[x] => ["one", x],
+				[x, y] if x == y => "same",
+				[first, ...rest] => [first, len(rest)],
+				{type: "ping", id} => ["ping", id],
+				{type: "data", body: {size: int n}} if n > 10 => { let s = n * 2; ["big", s] }
+				{type: "data"} => "data",
+				int n if n < 0 => "negative",
+				int => "int",
+				string s => s + "!",
+				fn => "function",
+				_ => "other"
+			}
+		};
+		let inputs = [0, -1, 1.5, "hi", true, false, [], [7], [2, 2], [2, 3], [1, 2, 3],
+			{"type": "ping", "id": 4}, {"type": "ping"}, {"type": "data", "body": {"size": 20}},
+			{"type": "data", "body": {"size": 2}}, -5, 42, 1.0, "x", fn() { 1 }, len, range(1, 3)];
+		let out = [];
+		for (i in inputs) { out = push(out, describe(i)) };
+		out`,
+		"match (5) { n => n + 1 }",
+		"match (1) { 1.0 => 1, 1 => 2 }",
+		"match (if (false) { 1 }) { null => 1, _ => 2 }",
+		"let n = 1; match (2) { n => n }; n",
+		"let f = fn() { let n = 1; let m = match (2) { n => n * 10 }; [n, m] }; f()",
+		"let t = 0; for (i in range(1, 6)) { t += match (i % 3) { 0 => { continue }, 1 => i, _ => 10 * i } }; t",
+		"let t = []; for (i in range(0, 4)) { t = push(t, match (i) { 0 => { break }, n if n > 2 => fn() { n }, n => fn() { -n } }) }; t",
+		"let fs = []; for (i in range(0, 3)) { fs = push(fs, match ([i]) { [n] => fn() { n } }) }; [fs[0](), fs[1](), fs[2]()]",
+		"let f = fn(m) { match (m) { [x] => { return x } _ => 0 }; 9 }; [f([1]), f(2)]",
+		"match ([1, 2]) { [array, count] => array + count }",
+		`match ({"size": "x"}) { {size: int} => int }`,
+		`match ([1, "a"]) { [int n, int m] => n, [int n, string s] => s }`,
+		"match (3) { 1 => 2 }",
+		"match ([1]) { [x] if x > 1 => x }",
+		"match (1) { }",
+		"match ([1, 2]) { [a, a] => a }",
+		"match (1) { x if y => 1 }",
+		"match (1) { x => 1 }; x",
+		"match (1 + true) { _ => 1 }",
+		"match (1) { x => x + true }",
+		// Exceptions
+		"try { 1 } catch (e) { 2 }",
+		"try { 1 / 0 } catch (e) { [e[\"message\"], e[\"kind\"], e[\"line\"], e[\"column\"]] }",
+		"try { 1 / 0 } catch { 2 }",
+		"try {\n  throw error(\"bad\", \"ConfigError\")\n} catch (e) { [e[\"message\"], e[\"kind\"], e[\"line\"], e[\"column\"]] }",
+		"let f = fn(n) { if (n == 0) { throw \"bottom\" } f(n - 1) }; try { f(20) } catch (e) { e[\"message\"] }",
+		"let f = fn(n) { if (n == 0) { throw \"bottom\" } f(n - 1) }; [1, 2, try { [3, f(5)] } catch (e) { 4 }, 5]",
+		"let g = fn() { try { 1 } catch (e) { 2 } }; let f = fn() { g(); throw \"after\" }; try { f() } catch (e) { e[\"message\"] }",
+		"try { try { throw \"a\" } catch (e) { throw e[\"message\"] + \"b\" } } catch (e) { e[\"message\"] }",
+		"let log = []; try { try { throw \"x\" } finally { log = push(log, 1) } } catch (e) { log = push(log, e[\"message\"]) }; log",
+		"let log = []; try { 1 } catch (e) { log = push(log, 1) } finally { log = push(log, 2) }; log",
+		"let log = []; let f = fn() { try { throw \"a\" } catch (e) { throw \"b\" } finally { log = push(log, 1) } }; [try { f() } catch (e) { e[\"message\"] }, log]",
+		"let x = try { 5 } finally { 6 }; x",
+		"let f = fn() { try { return 1 } finally { return 2 } }; f()",
+		"let f = fn() { try { throw \"x\" } finally { return 3 } }; f()",
+		"let f = fn() { try { throw \"x\" } catch (e) { return e[\"message\"] } finally { 0 } }; f()",
+		"let log = []; let f = fn() { try { try { return 1 } finally { log = push(log, 2) } } finally { log = push(log, 3) } }; [f(), log]",
+		"let log = []; for (x in [1, 2, 3]) { try { if (x == 2) { continue } log = push(log, x) } finally { log = push(log, 0) } }; log",
+		"let log = []; let r = for (x in [1, 2]) { try { try { break x * 10 } finally { log = push(log, 1) } } finally { log = push(log, 2) } }; [r, log]",
+		"let log = []; outer: for (x in [1, 2]) { for (y in [1, 2]) { try { continue outer } finally { log = push(log, [x, y]) } } }; log",
+		"let log = []; let f = fn() { for (let i = 0; i < 3; i++) { try { if (i == 1) { return 7 } } finally { log = push(log, i) } } }; [f(), log]",
+		"let n = 0; while (n < 3) { try { let v = n; if (v == 1) { throw \"one\" } } catch (e) { let v = 0 }; n++ }; n",
+		"let out = []; for (i in range(4)) { out = push(out, try { if (i % 2 == 1) { throw \"odd\" } i } catch (e) { -i }) }; out",
+		"try { let x = 1 } catch (e) { 0 }; x",
+		"try { 1 / 0 } catch (e) { e + 1 }",
+		"try { 1 / 0 } finally { 2 }",
+		"try { 1 } finally {\n  throw \"late\" }",
+		"let e = try {\n  1 / 0\n} catch (e) { e };\nthrow e",
+		"throw 1",
+		"throw error(\"bad\", \"Custom\")",
+		"let f = fn() {\n  throw \"bad\"\n};\nf()",
+		"match (error(\"x\")) { error e => e[\"message\"], _ => \"no\" }",
+		"error(\"a\")[0]",
+		"try { exit(3) } finally { puts(\"never\") }",
+
+		// Stack traces
+		"try { 1 / 0 } catch (e) { e[\"stack\"] }",
+		"let f = fn(x) {\n  x / 0\n};\ntry { f(1) } catch (e) { e[\"stack\"] }",
+		"let f = fn() { 1 / 0 };\nlet g = fn() {\n  [f()]\n};\ntry { g() } catch (e) { e[\"stack\"] }",
+		"let f = fn() {\n  len(1)\n};\ntry { f() } catch (e) { e[\"stack\"] }",
+		"let f = fn(x) { x };\nlet g = fn() {\n  f()\n};\ntry { g() } catch (e) { e[\"stack\"] }",
+		"let f = fn(x = 1 / 0) { x };\ntry { f() } catch (e) { e[\"stack\"] }",
+		"let f = fn(n) { if (n == 0) { throw \"bottom\" } 1 + f(n - 1) };\ntry { f(3) } catch (e) { e[\"stack\"] }",
+		"let g = fn() { throw \"bad\" };\nlet f = fn() {\n  try { g() } catch (e) { throw e }\n};\ntry { f() } catch (e) { e[\"stack\"] }",
+		"let f = fn() { try { 1 / 0 } catch (e) { e } };\nlet e = f();\n[e[\"stack\"], try { throw e } catch (again) { again[\"stack\"] }]",
+		"error(\"bad\")[\"stack\"]",
+		"try { 1 / 0 } catch (e) { e[\"stack\"][0][\"function\"] }",
+
+		// Function declarations
+		"fn add(x, y) { x + y }; add(1, 2)",
+		"let r = add(1, 2); fn add(x, y) { x + y }; r",
+		"fn fact(n) { if (n < 2) { 1 } else { n * fact(n - 1) } } fact(10)",
+		"fn even(n) { if (n == 0) { true } else { odd(n - 1) } } fn odd(n) { if (n == 0) { false } else { even(n - 1) } } [even(10), odd(7), even(3)]",
+		"let f = fn() { g() }; fn g() { 5 }; f()",
+		"fn outer() { let r = inner(); fn inner() { 7 }; r } outer()",
+		"fn outer(a) { fn inner() { a + b } let b = 2; inner() } outer(1)",
+		"let x = 1; fn f() { x }; let g = fn() { let x = 2; f() }; g()",
+		"fn f() { 1 }; let r = if (true) { fn f() { 2 }; f() }; [r, f()]",
+		"let fs = []; for (i in [1, 2]) { fn f() { i * 10 } fs = push(fs, f) }; [fs[0](), fs[1]()]",
+		"fn f() { 1 }",
+		"fn add(x, y) { x + y }; add",
+		"let add = fn(x, ...ys) { x }; add",
+		"let f = 0; f = fn(x = 1) { x }; f",
+		"let f = fn() { 1 }; let g = f; g",
+		"if (true) { fn f() { 1 } }; f()",
+		"fn f() { 1 };\nfn f() { 2 }",
+		"fn f() { 1 };\nlet f = 2",
+		"fn connect(host, port) { [host, port] }; connect(\"localhost\")",
+		"fn f(x, ...xs) { x }; f()",
+		"fn f() {\n  1 / 0\n}\nfn g() {\n  [f()]\n}\ntry { g() } catch (e) { e[\"stack\"] }",
+		"let f = fn(g) {\n  [g()]\n};\ntry { f(fn() { 1 / 0 }) } catch (e) { e[\"stack\"] }",
+		"let make = fn() { fn(x) { x } }; let g = make(); g()",
+		"let make = fn() { fn(x) { x } }; let g = make(); let h = g; h()",
+		"let make = fn() { fn(x) { x } }; let g = make(); g",
+		"let make = fn(n) { fn() { 1 / n } };\nlet g = make(0);\nlet h = make(0);\ntry { g() } catch (e) { [e[\"stack\"], h] }",
+		"let g = if (true) { fn(x) { x } }; g",
+
+		// Tail calls
+		"fn sum(n, acc) { if (n == 0) { acc } else { sum(n - 1, acc + n) } } sum(100, 0)",
+		"fn f(n) { for (x in [1, 2]) { if (n > 0) { return f(n - 1) } } \"done\" } f(10)",
+		"fn f(n) { match (n) { 0 => \"zero\", _ => f(n - 1) } } f(5)",
+		"fn f(n, step = 1) { if (n <= 0) { n } else { f(n - step, step: 2) } } f(9)",
+		"fn g(x) { x * 2 } fn f(x) { if (x > 0) { g(x) } else { len(\"abc\") } } [f(2), f(0)]",
+		"let log = []; fn f(n) { try { if (n == 0) { throw \"x\" } f(n - 1) } catch (e) { log = push(log, n); n } } [f(3), log]",
+		"fn f(n) { try { return n } finally { 0 } } f(1)",
+		"fn f() { g(1) }\nfn g() { 0 }\nf()",
+		"fn f() { g(1) }\nfn g() { 0 }\ntry { f() } catch (e) { e[\"stack\"] }",
+		"fn f(n) { if (n == 0) { 1 / 0 } else { f(n - 1) } }\ntry { f(5) } catch (e) { e[\"stack\"] }",
+		"fn f(n) { if (n == 0) { 0 } else { f(n - 1) } }\nf(100000)",
+		"let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; f(100000)",
+		"fn f(n) { if (n == 0) { 1 } else { g(n - 1) } }\nfn g(n) { f(n, 1) }\ntry { f(100) } catch (e) { e[\"stack\"] }",
+		"fn f(n) { for (;;) { return g(n) } }\nfn g(n) { n * 2 }\nf(4)",
+		"fn f() { 1 / 0 }\nfn g() {\n  f()\n}\ntry { g() } catch (e) { e[\"stack\"] }",
+		"let f = fn(n) {\n  if (n == 0) { return 1 / 0 }\n  return f(n - 1)\n};\ntry { f(3) } catch (e) { e[\"stack\"] }",
+		"fn f(n, ...xs) { if (n == 0) { xs } else { f(n - 1, ...xs, n) } } f(5)",
+		"fn f(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }\ntry { f(20000) } catch (e) { [e[\"message\"], len(e[\"stack\"])] }",
+
+		// Returning from loops
+		"fn f() { for (let i = 0; i < 10; i++) { if (i == 5) { return i } }; 7 } f()",
+		"fn f() { for (i in range(10)) { if (i == 5) { return i } }; 7 } f()",
+		"fn f() { let i = 0; while (i < 10) { if (i == 5) { return i }; i++ }; 7 } f()",
+		"fn f() { let i = 0; do { if (i == 5) { return i }; i++ } while (i < 10); 7 } f()",
+		"let x = for (;;) { return 5 }; x",
+
+		// Operators
+		"7 % 3",
+		"-7 % 3",
+		"2 ** 3 ** 2",
+		"2 ** -1",
+		"7.5 % 2",
+		"6 & 3 | 8 ^ 1",
+		"~5",
+		"1 << 10 >> 2",
+		"1 <= 2",
+		"2 >= 3",
+		"2.5 >= 2",
+		`"a" <= "b"`,
+		"1 / 0",
+		"let zero = 0; 5 % zero",
+		"1 << -1",
+		"1.5 & 1",
+		"~1.5",
+		// LogicalOperators
+		"true && false",
+		"false || true",
+		"1 < 2 && 2 > 3 || 3 == 3",
+		"1 && 2",
+		"1 || 2",
+		"if (false) { 1 } && 7",
+		"false && foobar",
+		"true || foobar",
+		"true && foobar",
+		"let x = 0; let f = fn() { x = x + 1; true }; false && f(); true || f(); x",
+		"let x = 0; let f = fn() { x = x + 1; true }; true && f(); false || f(); x",
+		"let i = 0; for (; i < 10 && i != 5; i = i + 1) { }; i",
+		"let f = fn(a, b) { let c = a || b; c && a }; f(false, 3)",
+		// IntegerBases
+		"0xFF + 0o17 + 0b1010",
+		"1_000_000 / 1_000",
+		// UnicodeStrings
+		`len("héllo")`,
+		`"héllo"[1]`,
+		`"héllo"[5]`,
+		`slice("héllo", 1, 3)`,
+		`let café = "\u{1F600}"; café + "\x41"`,
+		// Floats
+		"3.5",
+		"-2.5",
+		".5 + .25",
+		"2 * 1.5",
+		"3 / 2.0",
+		"1.5 < 2",
+		"1 == 1.0",
+		"1.5 + true",
+		"-true + 1.5",
+		`round(2.345, 2) + floor(1.5) + ceil(1.5) + int(2.5) + float(1)`,
+
+		// Exit
+		"exit(); 5",
+		"exit(3); 5",
+		"let f = fn() { exit(2); 1 }; f(); 5",
+		"for (let i = 0; i < 10; i = i + 1) { if (i == 4) { exit(i) } }; 5",
+		"let x = [1, exit(5)]; x",
+		`exit("1")`,
+		"exit(1, 2)",
+
+		// ArrayLiterals
+		"[1, 2 * 2, 3 + 3]",
+
+		// ArrayIndexExpressions
+		"[1, 2, 3][0]",
+		"[1, 2, 3][1]",
+		"[1, 2, 3][2]",
+		"let i = 0; [1][i];",
+		"[1, 2, 3][1 + 1];",
+		"let myArray = [1, 2, 3]; myArray[2];",
+		"let myArray = [1, 2, 3]; myArray[0] + myArray[1] + myArray[2];",
+		"let myArray = [1, 2, 3]; let i = myArray[0]; myArray[i]",
+		"[1, 2, 3][3]",
+		"[1, 2, 3][-1]",
+
+		// HashLiterals
+		`let two = "two";
+	{
+		"one": 10 - 9,
+		two: 1 + 1,
+		"thr" + "ee": 6 / 2,
+		4: 4,
+		true: 5,
+		false: 6
+	}`,
+
+		// HashIndexExpressions
+		`{"foo": 5}["foo"]`,
+		`{"foo": 5}["bar"]`,
+		`let key = "foo"; {"foo": 5}[key]`,
+		`{}["foo"]`,
+		`{5: 5}[5]`,
+		`{true: 5}[true]`,
+		`{false: 5}[false]`,
+	}
+
+	for _, input := range inputs {
+		expected := testEval(input)
+		actual := testRun(input)
+
+		if !sameObject(expected, actual) {
+			t.Errorf("results differ for %q.\nevaluator=%s\nvm=%s",
+				input, describe(expected), describe(actual))
+		}
+	}
+
+	// Programs whose results depend on the limits they run under
+	limited := []struct {
+		input  string
+		limits evaluator.Limits
+	}{
+		{`fn f(n) { if (n == 0) { len("abc") } else { 1 + f(n - 1) } } f(1)`, evaluator.Limits{MaxCallDepth: 3}},
+		{`fn f(n) { if (n == 0) { len("abc") } else { 1 + f(n - 1) } } f(2)`, evaluator.Limits{MaxCallDepth: 3}},
+		{`fn f(n) { if (n == 0) { len("abc") } else { 1 + f(n - 1) } } try { f(2) } catch (e) { e["stack"] }`, evaluator.Limits{MaxCallDepth: 3}},
+		{`fn f(n) { if (n == 0) { len("abc") } else { f(n - 1) } } f(1000)`, evaluator.Limits{MaxCallDepth: 2}},
+	}
+
+	for _, tt := range limited {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		expected := evaluator.EvalContext(context.Background(), program, object.NewEnvironment(), tt.limits)
+
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		actual := New(comp.Bytecode()).RunContext(context.Background(), tt.limits)
+
+		if !sameObject(expected, actual) {
+			t.Errorf("results differ for %q with %+v.\nevaluator=%s\nvm=%s",
+				tt.input, tt.limits, describe(expected), describe(actual))
+		}
+	}
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{
+			`
+			let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+			fib(15);
+			`,
+			610,
+		},
+		{
+			`
+			let wrapper = fn() {
+				let countDown = fn(x) { if (x == 0) { 0 } else { countDown(x - 1) } };
+				countDown(10);
+			};
+			wrapper();
+			`,
+			0,
+		},
+		{
+			`
+			let wrapper = fn() {
+				let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+				let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+				if (isEven(10)) { 1 } else { 0 };
+			};
+			wrapper();
+			`,
+			1,
+		},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testRun(tt.input), tt.expected)
+	}
+}
+
+func TestClosures(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{
+			`
+			let newCounter = fn() {
+				let count = 0;
+				fn() { count = count + 1 };
+			};
+			let counter = newCounter();
+			counter();
+			counter();
+			counter();
+			`,
+			3,
+		},
+		{
+			`
+			let newAdderOuter = fn(a, b) {
+				let c = a + b;
+				fn(d) {
+					let e = d + c;
+					fn(f) { e + f; };
+				};
+			};
+			newAdderOuter(1, 2)(3)(8);
+			`,
+			14,
+		},
+		{
+			`
+			let fns = [];
+			for (let i = 0; i < 3; i = i + 1) {
+				if (true) {
+					let j = i;
+					fns = push(fns, fn() { j });
+				}
+			}
+			fns[0]() + fns[1]() * 10 + fns[2]() * 100;
+			`,
+			210,
+		},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testRun(tt.input), tt.expected)
+	}
+}
+
+func TestGlobalsAcrossRuns(t *testing.T) {
+	symbolTable := compiler.NewSymbolTable()
+	constants := []object.Object{}
+	globals := make([]object.Object, GlobalsSize)
+
+	lines := []string{"let a = 1;", "let add = fn(x) { a + x };", "add(2)"}
+
+	var result object.Object
+	for _, line := range lines {
+		program := parser.New(lexer.New(line)).ParseProgram()
+
+		comp := compiler.NewWithState(symbolTable, constants)
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := comp.Bytecode()
+		constants = bytecode.Constants
+
+		result = NewWithGlobals(bytecode, globals).Run()
+	}
+
+	testIntegerObject(t, result, 3)
+}
+
+func TestStackOverflow(t *testing.T) {
+	input := "let f = fn() { 1 + f() }; f()"
+
+	errObj, ok := testRun(input).(*object.Error)
+	if !ok {
+		t.Fatalf("object is not Error. got=%T", errObj)
+	}
+
+	if errObj.Message != "maximum recursion depth exceeded" {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+}
+
+// Catching the overflow unwinds every frame the recursion pushed
+func TestCatchStackOverflow(t *testing.T) {
+	input := `
+	let f = fn() { 1 + f() };
+	let g = fn(n) { n * 2 };
+	let r = try { f() } catch (e) { e["message"] };
+	if (r == "maximum recursion depth exceeded") { g(21) } else { 0 }`
+
+	testIntegerObject(t, testRun(input), 42)
+}
+
+func TestRunContextLimits(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		input    string
+		ctx      context.Context
+		limits   evaluator.Limits
+		expected interface{}
+	}{
+		{"1 + 2", context.Background(), evaluator.Limits{MaxSteps: 100, MaxDuration: time.Second, MaxCallDepth: 10}, 3},
+		{"for (;;) {}", context.Background(), evaluator.Limits{MaxSteps: 1000}, evaluator.ErrStepLimit},
+		{"for (;;) {}", context.Background(), evaluator.Limits{MaxDuration: 10 * time.Millisecond}, evaluator.ErrTimeLimit},
+		{"exit(1)", cancelled, evaluator.Limits{}, context.Canceled},
+		{"fn f(n) { 1 + f(n) } f(0)", context.Background(), evaluator.Limits{MaxCallDepth: 10}, evaluator.ErrCallDepthLimit},
+		{"fn f(n) { 1 + f(n) } try { f(0) } catch (e) { throw e }", context.Background(), evaluator.Limits{MaxCallDepth: 10}, evaluator.ErrCallDepthLimit},
+		{"throw \"maximum recursion depth exceeded\"", context.Background(), evaluator.Limits{}, "maximum recursion depth exceeded"},
+		{"fn f(n) { if (n == 0) { 0 } else { f(n - 1) } } f(1000)", context.Background(), evaluator.Limits{MaxCallDepth: 10}, 0},
+		{"try { for (;;) {} } catch (e) { 1 }", context.Background(), evaluator.Limits{MaxSteps: 1000}, evaluator.ErrStepLimit},
+		{"try { for (;;) {} } finally { exit(1) }", context.Background(), evaluator.Limits{MaxSteps: 1000}, evaluator.ErrStepLimit},
+		{"[...range(0, 100000)]", context.Background(), evaluator.Limits{MaxSteps: 1000}, evaluator.ErrStepLimit},
+		{"let a = [...range(0, 10000)]; push(a, 1); 1", context.Background(), evaluator.Limits{MaxSteps: 15000}, evaluator.ErrStepLimit},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		result := New(comp.Bytecode()).RunContext(tt.ctx, tt.limits)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, result, int64(expected))
+		case string:
+			errObj, ok := result.(*object.Error)
+			if !ok || errObj.Message != expected || errObj.Cause != nil {
+				t.Errorf("wrong result for %q. expected error %q, got=%T (%+v)", tt.input, expected, result, result)
+			}
+		case error:
+			// Running too deep raises an error the program could have caught
+			if errObj, ok := result.(*object.Error); ok {
+				if !errors.Is(errObj.Cause, expected) {
+					t.Errorf("wrong error cause for %q. expected=%q, got=%v", tt.input, expected, errObj.Cause)
+				}
+				continue
+			}
+
+			halt, ok := result.(*object.Halt)
+			if !ok {
+				t.Errorf("object is not Halt for %q. got=%T (%+v)", tt.input, result, result)
+				continue
+			}
+
+			if !errors.Is(halt.Err, expected) {
+				t.Errorf("wrong halt error for %q. expected=%q, got=%q", tt.input, expected, halt.Err)
+			}
+		}
+	}
+}
+
+// A spread too long to finish is stopped part way through, not after it
+func TestCancelSpread(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	program := parser.New(lexer.New("[...range(0, 1000000000)]")).ParseProgram()
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	start := time.Now()
+	result := New(comp.Bytecode()).RunContext(ctx, evaluator.Limits{})
+
+	halt, ok := result.(*object.Halt)
+	if !ok {
+		t.Fatalf("object is not Halt. got=%T (%+v)", result, result)
+	}
+
+	if !errors.Is(halt.Err, context.DeadlineExceeded) {
+		t.Errorf("wrong halt error. expected=%q, got=%q", context.DeadlineExceeded, halt.Err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("spread took %s to stop", elapsed)
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	args := strings.Repeat("1, ", 200) + "1"
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let s = \"\"; for (;;) { s = s + \"x\" }", evaluator.ErrMemoryLimit},
+		{"let a = []; for (;;) { a = push(a, 1) }", evaluator.ErrMemoryLimit},
+		{"let a = [1]; for (;;) { a = [...a, ...a] }", evaluator.ErrMemoryLimit},
+		{"[...range(0, 20000000)]", evaluator.ErrMemoryLimit},
+		{"fn f(...xs) { len(xs) } f(...range(0, 20000000))", evaluator.ErrMemoryLimit},
+		{"let h = {}; let i = 0; for (;;) { h[i] = i; i++ }", evaluator.ErrMemoryLimit},
+		{"fn f(...xs) { 0 }; for (i in range(0, 30)) { f(" + args + ") }; 1", evaluator.ErrMemoryLimit},
+		{"let a = [...range(0, 1000)]; for (i in range(0, 10)) { let [x, ...r] = a }; 1", evaluator.ErrMemoryLimit},
+		{"let a = [...range(0, 1000)]; fn f([x, ...r]) { 0 }; for (i in range(0, 10)) { f(a) }; 1", evaluator.ErrMemoryLimit},
+		{"let a = [...range(0, 1000)]; for (i in range(0, 10)) { match (a) { [x, ...r] => 0 } }; 1", evaluator.ErrMemoryLimit},
+		{"let a = [...range(0, 1000)]; fn f(...xs) { 0 }; for (i in range(0, 10)) { f(...a) }; 1", evaluator.ErrMemoryLimit},
+		{"try { let a = []; for (;;) { a = push(a, 1) } } catch (e) { 1 }", evaluator.ErrMemoryLimit},
+		{"let a = []; for (let i = 0; i < 10; i++) { a = push(a, i) }; len(a)", 10},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		budget := evaluator.NewBudget(context.Background(), evaluator.Limits{MaxMemory: 1 << 16})
+		result := New(comp.Bytecode()).RunBudget(budget)
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, result, int64(expected))
+		case error:
+			halt, ok := result.(*object.Halt)
+			if !ok {
+				t.Errorf("object is not Halt for %q. got=%T (%+v)", tt.input, result, result)
+				continue
+			}
+
+			if !errors.Is(halt.Err, expected) {
+				t.Errorf("wrong halt error for %q. expected=%q, got=%q", tt.input, expected, halt.Err)
+			}
+		}
+
+		if budget.Stats().Allocated == 0 {
+			t.Errorf("no memory counted for %q", tt.input)
+		}
+	}
+}
+
+func testEval(input string) object.Object {
+	program := parser.New(lexer.New(input)).ParseProgram()
+
+	return evaluator.Eval(program, object.NewEnvironment())
+}
+
+func testRun(input string) object.Object {
+	program := parser.New(lexer.New(input)).ParseProgram()
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		compileErr := err.(*compiler.Error)
+		return &object.Error{Message: compileErr.Message, Span: compileErr.Span}
+	}
+
+	return New(comp.Bytecode()).Run()
+}
+
+func sameObject(expected, actual object.Object) bool {
+	if expected == nil || actual == nil {
+		return expected == nil && actual == nil
+	}
+
+	if expected.Type() != actual.Type() {
+		return false
+	}
+
+	switch expected := expected.(type) {
+	case *object.Array:
+		actual := actual.(*object.Array)
+		if len(expected.Elements) != len(actual.Elements) {
+			return false
+		}
+
+		for i := range expected.Elements {
+			if !sameObject(expected.Elements[i], actual.Elements[i]) {
+				return false
+			}
+		}
+
+		return true
+
+	case *object.Hash:
+		actual := actual.(*object.Hash)
+		if len(expected.Pairs) != len(actual.Pairs) {
+			return false
+		}
+
+		for key, pair := range expected.Pairs {
+			other, ok := actual.Pairs[key]
+			if !ok || !sameObject(pair.Value, other.Value) {
+				return false
+			}
+		}
+
+		return true
+
+	default:
+		return expected.Inspect() == actual.Inspect()
+	}
+}
+
+func describe(obj object.Object) string {
+	if obj == nil {
+		return "<nil>"
+	}
+
+	return string(obj.Type()) + " " + obj.Inspect()
+}
+
+func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
+	integer, ok := obj.(*object.Integer)
+	if !ok {
+		t.Errorf("object is not Integer. got=%T (%+v)", obj, obj)
+		return false
+	}
+
+	if integer.Value != expected {
+		t.Errorf("object has wrong value. Expected=%d, got=%d",
+			expected, integer.Value)
+		return false
+	}
+
+	return true
+}